@@ -0,0 +1,193 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	shutdown_timeout = time.Second * 5
+	max_body         = 4096
+)
+
+// Encoder receives the events the manual datachannel raises
+type Encoder interface {
+	Raise(event_id, value int)
+}
+
+type Session interface {
+	Info() proxy.SessionInfo
+	Permissions() *datachannel.Permissions
+	Stop()
+}
+
+// Server is the local admin api:
+//
+//	GET  /sessions                    running sessions
+//	POST /sessions/{id}/kick          stop a session
+//	POST /sessions/{id}/permissions   {"input": false} grants or revokes capabilities
+//	POST /idr                         request a keyframe
+//	POST /bitrate                     {"value": 6000}
+//	POST /framerate                   {"value": 60}
+type Server struct {
+	token    string
+	encoder  Encoder
+	sessions func() []Session
+	http     *http.Server
+}
+
+type value struct {
+	Value int `json:"value"`
+}
+
+// Running adapts the sessions of the process to the admin api
+func Running() []Session {
+	ret := []Session{}
+	for _, session := range proxy.Sessions() {
+		ret = append(ret, session)
+	}
+	return ret
+}
+
+func NewServer(conf config.AdminConfig, encoder Encoder, sessions func() []Session) *Server {
+	server := &Server{
+		token:    conf.Token,
+		encoder:  encoder,
+		sessions: sessions,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", server.list)
+	mux.HandleFunc("POST /sessions/{id}/kick", server.kick)
+	mux.HandleFunc("POST /sessions/{id}/permissions", server.permissions)
+	mux.HandleFunc("POST /idr", func(w http.ResponseWriter, r *http.Request) {
+		server.encoder.Raise(proxy.Idr, 1)
+		reply(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+	mux.HandleFunc("POST /bitrate", server.raise(proxy.Bitrate))
+	mux.HandleFunc("POST /framerate", server.raise(proxy.Framerate))
+	server.http = &http.Server{
+		Addr:              conf.Listen,
+		Handler:           server.authenticate(mux),
+		ReadHeaderTimeout: shutdown_timeout,
+	}
+	return server
+}
+
+// Serve listens until ctx is done
+func (server *Server) Serve(ctx context.Context) error {
+	listener, err := net.Listen("tcp", server.http.Addr)
+	if err != nil {
+		return err
+	}
+
+	thread.OnDone(ctx, func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), shutdown_timeout)
+		defer cancel()
+		server.http.Shutdown(shutdown)
+	})
+	thread.SafeThread(func() {
+		if err := server.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("admin api stopped %s\n", err.Error())
+		}
+	})
+	return nil
+}
+
+func (server *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+			reply(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (server *Server) find(w http.ResponseWriter, r *http.Request) Session {
+	for _, session := range server.sessions() {
+		if session.Info().ID == r.PathValue("id") {
+			return session
+		}
+	}
+
+	reply(w, http.StatusNotFound, map[string]string{"error": "no such session"})
+	return nil
+}
+
+func (server *Server) list(w http.ResponseWriter, r *http.Request) {
+	infos := []proxy.SessionInfo{}
+	for _, session := range server.sessions() {
+		infos = append(infos, session.Info())
+	}
+	reply(w, http.StatusOK, infos)
+}
+
+func (server *Server) kick(w http.ResponseWriter, r *http.Request) {
+	if session := server.find(w, r); session != nil {
+		session.Stop()
+		reply(w, http.StatusOK, map[string]bool{"ok": true})
+	}
+}
+
+func (server *Server) permissions(w http.ResponseWriter, r *http.Request) {
+	changes := map[datachannel.Capability]bool{}
+	if err := decode(r, &changes); err != nil {
+		reply(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	for capability := range changes {
+		switch capability {
+		case datachannel.CapInput, datachannel.CapGamepad, datachannel.CapClipboard, datachannel.CapFile, datachannel.CapControl:
+		default:
+			reply(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown capability %s", capability)})
+			return
+		}
+	}
+
+	if session := server.find(w, r); session != nil {
+		for capability, allowed := range changes {
+			session.Permissions().Set(capability, allowed)
+		}
+		reply(w, http.StatusOK, session.Info())
+	}
+}
+
+func (server *Server) raise(event int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := value{}
+		if err := decode(r, &body); err != nil {
+			reply(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		} else if body.Value <= 0 {
+			reply(w, http.StatusBadRequest, map[string]string{"error": "value must be positive"})
+		} else {
+			server.encoder.Raise(event, body.Value)
+			reply(w, http.StatusOK, map[string]bool{"ok": true})
+		}
+	}
+}
+
+func decode(r *http.Request, out interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, max_body))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(out)
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
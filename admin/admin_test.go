@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+const token = "0123456789abcdef"
+
+type raised struct{ event, value int }
+
+type encoder struct{ events []raised }
+
+func (e *encoder) Raise(event_id, value int) {
+	e.events = append(e.events, raised{event_id, value})
+}
+
+type session struct {
+	id      string
+	perms   *datachannel.Permissions
+	stopped bool
+}
+
+func (s *session) Info() proxy.SessionInfo {
+	return proxy.SessionInfo{ID: s.id, Capabilities: s.perms.Capabilities()}
+}
+func (s *session) Permissions() *datachannel.Permissions { return s.perms }
+func (s *session) Stop()                                 { s.stopped = true }
+
+func newServer(t *testing.T) (*httptest.Server, *encoder, *session) {
+	enc := &encoder{}
+	viewer := &session{id: "42", perms: datachannel.NewPermissions(datachannel.CapInput)}
+	server := NewServer(config.AdminConfig{Token: token}, enc, func() []Session {
+		return []Session{viewer}
+	})
+	ts := httptest.NewServer(server.http.Handler)
+	t.Cleanup(ts.Close)
+	return ts, enc, viewer
+}
+
+func call(t *testing.T, ts *httptest.Server, method, path, auth, body string) (int, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.Nil(t, err)
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	out := json.RawMessage{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&out))
+	return resp.StatusCode, out
+}
+
+func TestAuthentication(t *testing.T) {
+	ts, _, _ := newServer(t)
+	status, _ := call(t, ts, http.MethodGet, "/sessions", "", "")
+	require.Equal(t, http.StatusUnauthorized, status)
+	status, _ = call(t, ts, http.MethodGet, "/sessions", "wrong", "")
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	status, body := call(t, ts, http.MethodGet, "/sessions", token, "")
+	require.Equal(t, http.StatusOK, status)
+	infos := []proxy.SessionInfo{}
+	require.Nil(t, json.Unmarshal(body, &infos))
+	require.Len(t, infos, 1)
+	require.Equal(t, "42", infos[0].ID)
+}
+
+func TestEncoderControls(t *testing.T) {
+	ts, enc, _ := newServer(t)
+	status, _ := call(t, ts, http.MethodPost, "/idr", token, "")
+	require.Equal(t, http.StatusOK, status)
+	status, _ = call(t, ts, http.MethodPost, "/bitrate", token, `{"value": 6000}`)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = call(t, ts, http.MethodPost, "/framerate", token, `{"value": 0}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	require.Equal(t, []raised{{proxy.Idr, 1}, {proxy.Bitrate, 6000}}, enc.events)
+}
+
+func TestSessionControls(t *testing.T) {
+	ts, _, viewer := newServer(t)
+	status, _ := call(t, ts, http.MethodPost, "/sessions/42/permissions", token, `{"input": false, "clipboard": true}`)
+	require.Equal(t, http.StatusOK, status)
+	require.False(t, viewer.perms.Allows(datachannel.CapInput))
+	require.True(t, viewer.perms.Allows(datachannel.CapClipboard))
+
+	status, _ = call(t, ts, http.MethodPost, "/sessions/42/permissions", token, `{"root": true}`)
+	require.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = call(t, ts, http.MethodPost, "/sessions/7/kick", token, "")
+	require.Equal(t, http.StatusNotFound, status)
+	status, _ = call(t, ts, http.MethodPost, "/sessions/42/kick", token, "")
+	require.Equal(t, http.StatusOK, status)
+	require.True(t, viewer.stopped)
+}
@@ -1,58 +1,62 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/pion/webrtc/v4"
 	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/admin"
 
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel/clipboard"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel/cursor"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel/filetransfer"
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel/hid"
 	"github.com/thinkonmay/thinkremote-rtchub/listener"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/audio"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/manual"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/video"
 	"github.com/thinkonmay/thinkremote-rtchub/signalling/http"
+	"github.com/thinkonmay/thinkremote-rtchub/turn"
+	"github.com/thinkonmay/thinkremote-rtchub/util/auth"
 	"github.com/thinkonmay/thinkremote-rtchub/util/config"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+	client "github.com/thinkonmay/thinkremote-rtchub/webrtc"
 )
 
 const (
 	DisplayFailureCode = 77
+	InvalidConfigCode  = 78
 )
 
 func main() {
-	args := os.Args[1:]
-	rtc := &config.WebRTCConfig{Ices: []webrtc.ICEServer{{}, {}}}
-
-	token := ""
-	videochannel := int64(0)
-	video_url := "http://localhost:60000/handshake/server?token=video"
-	audio_url := "http://localhost:60000/handshake/server?token=audio"
-	for i, arg := range args {
-		if arg == "--token" {
-			token = args[i+1]
-		} else if arg == "--video_channel" {
-			videochannel, _ = strconv.ParseInt(args[i+1], 10, 16)
-		} else if arg == "--video" {
-			video_url = args[i+1]
-		} else if arg == "--audio" {
-			audio_url = args[i+1]
-		} else if arg == "--stun" {
-			rtc.Ices[0].URLs = []string{args[i+1]}
-		} else if arg == "--turn" {
-			rtc.Ices[1].URLs = []string{args[i+1]}
-		} else if arg == "--turn_username" {
-			rtc.Ices[1].Username = args[i+1]
-		} else if arg == "--turn_password" {
-			rtc.Ices[1].Credential = args[i+1]
-		}
+	conf, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Printf("invalid configuration:\n%s\n", err.Error())
+		os.Exit(InvalidConfigCode)
+	} else if err := setupLogging(conf.Logging); err != nil {
+		fmt.Printf("error setup logging %s\n", err.Error())
+		os.Exit(InvalidConfigCode)
 	}
 
+	rtc := &conf.WebRTC
+	clip_policy := clipboard.DefaultPolicy()
+	clip_policy.Inbound = conf.Clipboard == "both" || conf.Clipboard == "inbound"
+	clip_policy.Outbound = conf.Clipboard == "both" || conf.Clipboard == "outbound"
+	clip_policy.MaxSize = conf.Limits.ClipboardSize
+	file_conf := filetransfer.DefaultConfig(conf.FileDir)
+	file_conf.MaxConcurrent = conf.Limits.FileConcurrency
+	file_conf.MaxFileSize = conf.Limits.FileSize
+
 	defer func() {
 		if err := recover(); err != nil {
 			os.Exit(DisplayFailureCode)
@@ -61,89 +65,208 @@ func main() {
 		}
 	}()
 
-	memory, err := proxy.ObtainSharedMemory(token)
+	memory, err := proxy.ObtainSharedMemory(conf.Token)
 	if err != nil {
 		fmt.Printf("error obtain shared memory %s\n", err.Error())
 		return
 	}
 
-	audioPipeline, err := audio.CreatePipeline(memory.GetQueue(proxy.Audio))
+	// video_channel is the display streamed until a viewer switches
+	displays := proxy.NewDisplays(memory, conf.VideoChannel)
+	if conf.Codecs.Simulcast {
+		displays = proxy.NewSimulcastDisplays(memory)
+	}
+	audioPipeline, err := audio.CreatePipeline(memory.GetQueue(proxy.Audio), conf.WebRTC.Opus.Channels)
 	if err != nil {
 		fmt.Printf("error initiate audio pipeline %s\n", err.Error())
 		return
 	}
 
-	videoPipeline, err := video.CreatePipeline(memory.GetQueue(int(videochannel)))
+	var videoPipeline listener.Listener
+	if conf.Codecs.Simulcast {
+		videoPipeline, err = video.CreateSimulcastPipeline(memory, conf.Codecs.VideoMimeType())
+	} else {
+		videoPipeline, err = video.CreatePipeline(displays, conf.Codecs.VideoMimeType())
+	}
 	if err != nil {
 		fmt.Printf("error initiate video pipeline %s\n", err.Error())
 		return
 	}
 
-	chans := datachannel.NewDatachannel("hid", "manual")
-	chans.RegisterConsumer("manual", manual.NewManualCtx(memory.GetQueue(int(videochannel))))
-	chans.RegisterConsumer("hid", hid.NewHIDSingleton(memory.GetQueue(int(videochannel))))
-	defer chans.DeregisterConsumer("hid")
-	defer chans.DeregisterConsumer("manual")
+	clip_backend, err := clipboard.NewSystemBackend()
+	if err != nil {
+		fmt.Printf("error initiate clipboard %s, clipboard will not reach host\n", err.Error())
+		clip_backend = clipboard.NewMemoryBackend()
+	}
+	clip := clipboard.NewClipboard(clip_backend, clip_policy)
+	defer clip.Close()
+
+	coalescers := map[string]func(queued, incoming datachannel.Message) (datachannel.Message, bool){
+		"hid":    hid.CoalesceMouse,
+		"mouse":  hid.CoalesceMouse,
+		"cursor": cursor.CoalescePosition,
+	}
+	keepers := map[string]func(datachannel.Message) bool{
+		"hid": hid.KeepInput,
+	}
+	overflows := map[string]datachannel.Overflow{
+		"":            datachannel.DropNewest,
+		"drop-newest": datachannel.DropNewest,
+		"drop-oldest": datachannel.DropOldest,
+		"coalesce":    datachannel.Coalesce,
+	}
+	groups := []datachannel.Group{}
+	has_file := false
+	for _, dc := range conf.Datachannels {
+		has_file = has_file || dc.Name == "file"
+		groups = append(groups, datachannel.Group{Name: dc.Name, Options: datachannel.Options{
+			Unordered:         dc.Unordered,
+			MaxRetransmits:    dc.MaxRetransmits,
+			MaxPacketLifeTime: dc.MaxPacketLifeTime,
+			ID:                dc.ID,
+			Protocol:          dc.Protocol,
+			QueueSize:         dc.QueueSize,
+			Overflow:          overflows[dc.Overflow],
+			Coalesce:          coalescers[dc.Name],
+			Keep:              keepers[dc.Name],
+		}})
+	}
+	if file_conf.Directory != "" && !has_file {
+		groups = append(groups, datachannel.Group{Name: "file", Options: datachannel.Options{
+			QueueSize: 256,
+		}})
+	}
+
+	hid.UnicodeSequence = conf.UnicodeInput
+	hid_adapter := hid.NewHIDSingleton(displays, clip)
+	chans := datachannel.NewDatachannel(groups...)
+	consumers := map[string]datachannel.DatachannelConsumer{
+		"manual": manual.NewManualCtx(displays),
+		"hid":    hid_adapter,
+		"mouse":  hid_adapter.Unreliable(),
+	}
+	if source := memory.GetCursor(); source != nil {
+		pointer := cursor.NewCursor(source, displays)
+		consumers["cursor"] = pointer
+		consumers["cursor-shape"] = pointer.Shapes()
+	}
+	if file_conf.Directory != "" {
+		if files, err := filetransfer.NewFileTransfer(file_conf); err != nil {
+			fmt.Printf("error initiate file transfer %s\n", err.Error())
+		} else {
+			consumers["file"] = files
+		}
+	}
+	for _, group := range chans.Groups() {
+		if _, found := consumers[group]; !found {
+			fmt.Printf("datachannel %s has no consumer on this host\n", group)
+		}
+	}
+	for group, consumer := range consumers {
+		defer consumer.Close()
+		if err := chans.RegisterConsumer(group, consumer); err != nil {
+			fmt.Printf("error register datachannel %s %s\n", group, err.Error())
+		} else {
+			defer chans.DeregisterConsumer(group)
+		}
+	}
 	defer audioPipeline.Close()
 	defer videoPipeline.Close()
 
-	handle_idr := func() { memory.GetQueue(int(videochannel)).Raise(proxy.Idr, 1) }
+	handle_idr := func() { displays.Raise(proxy.Idr, 1) }
 	handle_track := func(tr *webrtc.TrackRemote) {}
 
-	stop := make(chan bool, 2)
-	defer thread.TriggerStop(stop)
+	verifier, err := auth.New(conf.Auth.Scheme, conf.Auth.Secret, conf.Auth.Leeway)
+	if err != nil {
+		fmt.Printf("error setup session auth %s\n", err.Error())
+		return
+	}
 
-	thread.SafeLoop(stop, 0, func() {
-		next := make(chan bool)
-		if signaling_client, err := http.InitHttpClient(video_url); err != nil {
-			fmt.Printf("error initiate signaling client %s\n", err.Error())
+	var relay *turn.Server
+	if conf.Turn.Listen != "" {
+		if relay, err = turn.NewServer(conf.Turn); err != nil {
+			fmt.Printf("error start turn server %s\n", err.Error())
 			return
-		} else {
-			signaling_client.WaitForStart(func() {
-				next <- true
-				thread.SafeThread(func() {
-					if err := proxy.InitWebRTCProxy(signaling_client,
-						rtc,
-						chans,
-						[]listener.Listener{videoPipeline},
-						handle_track,
-						handle_idr,
-					); err != nil {
-						fmt.Printf("webrtc error :%s\n", err.Error())
-					}
-				})
-			})
 		}
+		defer relay.Close()
+	}
 
-		<-next
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// sessions share the ICE ports configured in conf.WebRTC.Network
+	defer client.SharedMux().Close()
 
-	thread.SafeLoop(stop, 0, func() {
-		next := make(chan bool)
-		if signaling_client, err := http.InitHttpClient(audio_url); err != nil {
-			fmt.Printf("error initiate signaling client %s\n", err.Error())
+	if conf.Admin.Listen != "" {
+		if err := admin.NewServer(conf.Admin, displays, admin.Running).Serve(ctx); err != nil {
+			fmt.Printf("error start admin api %s\n", err.Error())
 			return
-		} else {
-			signaling_client.WaitForStart(func() {
-				next <- true
-				thread.SafeThread(func() {
-					if err := proxy.InitWebRTCProxy(signaling_client,
-						rtc,
-						chans,
-						[]listener.Listener{audioPipeline},
-						handle_track,
-						func() {},
-					); err != nil {
-						fmt.Printf("webrtc error :%s\n", err.Error())
+		}
+	}
+
+	serve := func(url string, listeners []listener.Listener, onIDR func()) {
+		thread.SafeLoop(ctx, 0, func() {
+			next := make(chan bool)
+			if signaling_client, err := http.InitHttpClient(url); err != nil {
+				fmt.Printf("error initiate signaling client %s\n", err.Error())
+				return
+			} else {
+				signaling_client.WaitForStart(func() {
+					next <- true
+					var inject proxy.InjectFunc
+					if relay != nil {
+						// every session gets its own ephemeral credentials and quota
+						inject = relay.Inject
 					}
+					thread.SafeThread(func() {
+						if err := proxy.InitWebRTCProxy(signaling_client,
+							rtc,
+							verifier,
+							chans,
+							listeners,
+							handle_track,
+							onIDR,
+							inject,
+						); err != nil {
+							fmt.Printf("webrtc error :%s\n", err.Error())
+						}
+					})
 				})
-			})
-		}
 
-		<-next
-	})
+				select {
+				case <-next:
+				case <-ctx.Done():
+					signaling_client.Stop()
+				}
+			}
+		})
+	}
+
+	if conf.Signalling.Bundle != "" {
+		// one connection negotiates every track and datachannel, sharing a single stream for lip-sync
+		serve(conf.Signalling.Bundle, []listener.Listener{videoPipeline, audioPipeline}, handle_idr)
+	} else {
+		serve(conf.Signalling.Video, []listener.Listener{videoPipeline}, handle_idr)
+		serve(conf.Signalling.Audio, []listener.Listener{audioPipeline}, func() {})
+	}
 
 	chann := make(chan os.Signal, 16)
 	signal.Notify(chann, syscall.SIGTERM, os.Interrupt)
 	<-chann
 }
+
+// setupLogging redirects output to the log file and sets the level
+// pion reads when the first peer connection is created
+func setupLogging(conf config.LogConfig) error {
+	if conf.File != "" {
+		file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		os.Stdout, os.Stderr = file, file
+	}
+
+	if conf.Level != "disabled" {
+		os.Setenv("PION_LOG_"+strings.ToUpper(conf.Level), "all")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package clipboard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commandBackend drives wl-clipboard on Wayland or xclip on X11
+type commandBackend struct {
+	types func() *exec.Cmd
+	read  func(mime string) *exec.Cmd
+	write func(mime string) *exec.Cmd
+}
+
+func NewSystemBackend() (Backend, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-paste"); err != nil {
+			return nil, fmt.Errorf("wayland session without wl-clipboard: %s", err.Error())
+		}
+
+		return &commandBackend{
+			types: func() *exec.Cmd {
+				return exec.Command("wl-paste", "--list-types")
+			},
+			read: func(mime string) *exec.Cmd {
+				return exec.Command("wl-paste", "--no-newline", "--type", mime)
+			},
+			write: func(mime string) *exec.Cmd {
+				return exec.Command("wl-copy", "--type", mime)
+			},
+		}, nil
+	} else if os.Getenv("DISPLAY") != "" {
+		if _, err := exec.LookPath("xclip"); err != nil {
+			return nil, fmt.Errorf("x11 session without xclip: %s", err.Error())
+		}
+
+		return &commandBackend{
+			types: func() *exec.Cmd {
+				return exec.Command("xclip", "-selection", "clipboard", "-target", "TARGETS", "-out")
+			},
+			read: func(mime string) *exec.Cmd {
+				return exec.Command("xclip", "-selection", "clipboard", "-target", x11Target(mime), "-out")
+			},
+			write: func(mime string) *exec.Cmd {
+				return exec.Command("xclip", "-selection", "clipboard", "-target", x11Target(mime), "-in")
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no graphical session found for clipboard")
+}
+
+// x11Target maps plain text to the target most X11 applications offer
+func x11Target(mime string) string {
+	if mime == MimeText {
+		return "UTF8_STRING"
+	}
+	return mime
+}
+
+func (cmd *commandBackend) Types() ([]string, error) {
+	out, err := cmd.types().Output()
+	if err != nil {
+		return nil, err
+	}
+
+	types := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		} else if line == "UTF8_STRING" || line == "text/plain;charset=utf-8" {
+			line = MimeText
+		}
+		types = append(types, line)
+	}
+
+	return types, nil
+}
+
+func (cmd *commandBackend) Read(mime string) ([]byte, error) {
+	return cmd.read(mime).Output()
+}
+
+func (cmd *commandBackend) Write(mime string, data []byte) error {
+	write := cmd.write(mime)
+	write.Stdin = bytes.NewReader(data)
+	return write.Run()
+}
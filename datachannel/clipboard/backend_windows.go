@@ -0,0 +1,105 @@
+package clipboard
+
+/*
+#include <Windows.h>
+#include <stdlib.h>
+#include <string.h>
+
+int
+clipboard_has_text() {
+    return IsClipboardFormatAvailable(CF_UNICODETEXT);
+}
+
+wchar_t*
+clipboard_get_text(int* size) {
+    wchar_t* ret = NULL;
+    if (!IsClipboardFormatAvailable(CF_UNICODETEXT) || !OpenClipboard(0))
+        return NULL;
+
+    HGLOBAL hMem = GetClipboardData(CF_UNICODETEXT);
+    if (hMem) {
+        const wchar_t* text = (const wchar_t*)GlobalLock(hMem);
+        if (text) {
+            *size = wcslen(text);
+            ret = malloc((*size + 1) * sizeof(wchar_t));
+            memcpy(ret, text, (*size + 1) * sizeof(wchar_t));
+            GlobalUnlock(hMem);
+        }
+    }
+    CloseClipboard();
+    return ret;
+}
+
+// clipboard_set_text takes size utf-16 units ending with a null
+int
+clipboard_set_text(unsigned short* output, int size) {
+    HGLOBAL hMem =  GlobalAlloc(GMEM_MOVEABLE, size * sizeof(wchar_t));
+    if (!hMem)
+        return 0;
+    wchar_t* dst = (wchar_t*)GlobalLock(hMem);
+    memcpy(dst, output, size * sizeof(wchar_t));
+    GlobalUnlock(hMem);
+    if (!OpenClipboard(0)) {
+        GlobalFree(hMem);
+        return 0;
+    }
+    EmptyClipboard();
+    int ok = SetClipboardData(CF_UNICODETEXT, hMem) != NULL;
+    if (!ok)
+        GlobalFree(hMem);
+    CloseClipboard();
+    return ok;
+}
+*/
+import "C"
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// win32Backend only exchanges CF_UNICODETEXT, converted from and to the
+// utf-8 of the datachannel, other formats are reported unsupported
+type win32Backend struct{}
+
+func NewSystemBackend() (Backend, error) {
+	return &win32Backend{}, nil
+}
+
+func (*win32Backend) Types() ([]string, error) {
+	if C.clipboard_has_text() == 0 {
+		return []string{}, nil
+	}
+
+	return []string{MimeText}, nil
+}
+
+func (*win32Backend) Read(mime string) ([]byte, error) {
+	if mime != MimeText {
+		return nil, ErrUnsupportedMime
+	}
+
+	size := C.int(0)
+	text := C.clipboard_get_text(&size)
+	if text == nil {
+		return nil, errors.New("failed to read clipboard")
+	}
+	defer C.free(unsafe.Pointer(text))
+
+	return []byte(windows.UTF16ToString(unsafe.Slice((*uint16)(unsafe.Pointer(text)), int(size)))), nil
+}
+
+func (*win32Backend) Write(mime string, data []byte) error {
+	if mime != MimeText {
+		return ErrUnsupportedMime
+	}
+
+	text, err := windows.UTF16FromString(string(data))
+	if err != nil {
+		return err
+	} else if C.clipboard_set_text((*C.ushort)(unsafe.Pointer(&text[0])), C.int(len(text))) == 0 {
+		return errors.New("failed to write clipboard")
+	}
+	return nil
+}
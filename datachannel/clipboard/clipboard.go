@@ -0,0 +1,181 @@
+package clipboard
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	MimeText = "text/plain"
+	MimeHTML = "text/html"
+	MimePNG  = "image/png"
+
+	queue_size       = 8
+	poll_period      = time.Millisecond * 500
+	default_max_size = 32 * 1024
+
+	// MaxSize is the largest payload that still fits a single 64kB SCTP
+	// message once base64 encoded behind its cs or cu header
+	MaxSize = 47 * 1024
+)
+
+var (
+	ErrDisabled        = errors.New("clipboard direction disabled by policy")
+	ErrTooLarge        = errors.New("clipboard content exceeds size limit")
+	ErrUnsupportedMime = errors.New("clipboard mime type not supported")
+)
+
+// Backend is the platform clipboard, selected by NewSystemBackend
+// or replaced by MemoryBackend in tests
+type Backend interface {
+	// Types returns the mime types currently offered by the clipboard
+	Types() ([]string, error)
+	Read(mime string) ([]byte, error)
+	Write(mime string, data []byte) error
+}
+
+type Content struct {
+	Mime string
+	Data []byte
+}
+
+type Policy struct {
+	// Inbound allows the client to set the host clipboard
+	Inbound bool
+	// Outbound allows host clipboard changes to reach the client
+	Outbound bool
+	// MaxSize is the largest payload in bytes accepted in either direction
+	MaxSize int
+	// Mimes lists the accepted mime types, in order of preference
+	Mimes []string
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Inbound:  true,
+		Outbound: true,
+		MaxSize:  default_max_size,
+		Mimes:    []string{MimePNG, MimeHTML, MimeText},
+	}
+}
+
+type Clipboard struct {
+	backend Backend
+	policy  Policy
+
+	mut  *sync.Mutex
+	last [sha256.Size]byte
+
+	changes chan *Content
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewClipboard(backend Backend, policy Policy) *Clipboard {
+	if policy.MaxSize <= 0 {
+		policy.MaxSize = default_max_size
+	} else if policy.MaxSize > MaxSize {
+		fmt.Printf("clipboard size %d does not fit a datachannel message, limiting it to %d\n", policy.MaxSize, MaxSize)
+		policy.MaxSize = MaxSize
+	}
+
+	clip := &Clipboard{
+		backend: backend,
+		policy:  policy,
+		mut:     &sync.Mutex{},
+		changes: make(chan *Content, queue_size),
+	}
+	clip.ctx, clip.cancel = context.WithCancel(context.Background())
+
+	if policy.Outbound {
+		thread.SafeLoop(clip.ctx, poll_period, clip.poll)
+	}
+
+	return clip
+}
+
+func hash(mime string, data []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(mime+"\x00"), data...))
+}
+
+func (clip *Clipboard) accept(mime string) bool {
+	for _, m := range clip.policy.Mimes {
+		if m == mime {
+			return true
+		}
+	}
+	return false
+}
+
+// Set writes client clipboard content to the host
+func (clip *Clipboard) Set(mime string, data []byte) error {
+	if !clip.policy.Inbound {
+		return ErrDisabled
+	} else if !clip.accept(mime) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedMime, mime)
+	} else if len(data) > clip.policy.MaxSize {
+		return ErrTooLarge
+	}
+
+	clip.mut.Lock()
+	defer clip.mut.Unlock()
+	if err := clip.backend.Write(mime, data); err != nil {
+		return err
+	}
+
+	// remember what the client sent so the watcher does not echo it back
+	clip.last = hash(mime, data)
+	return nil
+}
+
+// Changes delivers host clipboard updates allowed by the outbound policy
+func (clip *Clipboard) Changes() chan *Content {
+	return clip.changes
+}
+
+func (clip *Clipboard) poll() {
+	types, err := clip.backend.Types()
+	if err != nil {
+		return
+	}
+
+	mime := ""
+	for _, preferred := range clip.policy.Mimes {
+		for _, available := range types {
+			if preferred == available && mime == "" {
+				mime = preferred
+			}
+		}
+	}
+	if mime == "" {
+		return
+	}
+
+	data, err := clip.backend.Read(mime)
+	if err != nil || len(data) == 0 || len(data) > clip.policy.MaxSize {
+		return
+	}
+
+	clip.mut.Lock()
+	defer clip.mut.Unlock()
+	if sum := hash(mime, data); sum == clip.last {
+		return
+	} else {
+		clip.last = sum
+	}
+
+	if len(clip.changes) == queue_size {
+		<-clip.changes
+	}
+	clip.changes <- &Content{Mime: mime, Data: data}
+}
+
+func (clip *Clipboard) Close() {
+	clip.cancel()
+}
@@ -0,0 +1,63 @@
+package clipboard
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetClipboard(t *testing.T) {
+	backend := NewMemoryBackend()
+	clip := NewClipboard(backend, Policy{Inbound: true, MaxSize: 8, Mimes: []string{MimeText}})
+	defer clip.Close()
+
+	require.Nil(t, clip.Set(MimeText, []byte("hello")))
+	data, err := backend.Read(MimeText)
+	require.Nil(t, err)
+	require.Equal(t, []byte("hello"), data)
+
+	require.ErrorIs(t, clip.Set(MimeText, []byte("too long for limit")), ErrTooLarge)
+	require.ErrorIs(t, clip.Set(MimePNG, []byte{0x89}), ErrUnsupportedMime)
+}
+
+func TestInboundDisabled(t *testing.T) {
+	clip := NewClipboard(NewMemoryBackend(), Policy{Outbound: true, Mimes: []string{MimeText}})
+	defer clip.Close()
+
+	require.ErrorIs(t, clip.Set(MimeText, []byte("hello")), ErrDisabled)
+}
+
+func TestWatchClipboard(t *testing.T) {
+	backend := NewMemoryBackend()
+	clip := NewClipboard(backend, DefaultPolicy())
+	defer clip.Close()
+
+	require.Nil(t, backend.Write(MimeHTML, []byte("<b>host</b>")))
+	select {
+	case content := <-clip.Changes():
+		require.Equal(t, MimeHTML, content.Mime)
+		require.Equal(t, []byte("<b>host</b>"), content.Data)
+	case <-time.After(poll_period * 4):
+		t.Fatal("host clipboard change was not reported")
+	}
+
+	// content set by the client must not be echoed back
+	require.Nil(t, clip.Set(MimeText, []byte("client")))
+	select {
+	case content := <-clip.Changes():
+		t.Fatalf("unexpected echo %s", content.Data)
+	case <-time.After(poll_period * 3):
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	// the largest payload fits one SCTP message once encoded
+	encoded := base64.StdEncoding.EncodedLen(MaxSize)
+	require.LessOrEqual(t, len("cs||")+encoded+len(MimeHTML), 64*1024)
+
+	clip := NewClipboard(NewMemoryBackend(), Policy{Inbound: true, MaxSize: 256 * 1024, Mimes: []string{MimeText}})
+	defer clip.Close()
+	require.ErrorIs(t, clip.Set(MimeText, make([]byte, MaxSize+1)), ErrTooLarge)
+}
@@ -0,0 +1,46 @@
+package clipboard
+
+import "sync"
+
+// MemoryBackend keeps a single clipboard entry in process memory
+type MemoryBackend struct {
+	mut     *sync.Mutex
+	content *Content
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		mut: &sync.Mutex{},
+	}
+}
+
+func (mem *MemoryBackend) Types() ([]string, error) {
+	mem.mut.Lock()
+	defer mem.mut.Unlock()
+	if mem.content == nil {
+		return []string{}, nil
+	}
+
+	return []string{mem.content.Mime}, nil
+}
+
+func (mem *MemoryBackend) Read(mime string) ([]byte, error) {
+	mem.mut.Lock()
+	defer mem.mut.Unlock()
+	if mem.content == nil || mem.content.Mime != mime {
+		return nil, ErrUnsupportedMime
+	}
+
+	return append([]byte{}, mem.content.Data...), nil
+}
+
+func (mem *MemoryBackend) Write(mime string, data []byte) error {
+	mem.mut.Lock()
+	defer mem.mut.Unlock()
+	mem.content = &Content{
+		Mime: mime,
+		Data: append([]byte{}, data...),
+	}
+
+	return nil
+}
@@ -0,0 +1,223 @@
+package cursor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+	"sync"
+	"time"
+
+	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	queue_size  = 32
+	poll_period = time.Millisecond * 8
+	// max_shapes bounds the cached images, the cache restarts when full
+	max_shapes = 64
+	// max_dimension and max_message bound the shapes sent, a ci message
+	// has to fit a single SCTP message
+	max_dimension = 256
+	max_message   = 60 * 1024
+)
+
+var ErrTooLarge = errors.New("cursor shape too large")
+
+// Source is the cursor region written by the capture process, or a fake
+// in tests
+type Source interface {
+	Read(shape int) (proxy.CursorState, bool)
+}
+
+// Display locates the streamed display inside the desktop
+type Display interface {
+	GetDisplay() (name string, width, height, offsetX, offsetY, envX, envY int)
+}
+
+// Cursor forwards the host cursor so the client draws it locally, over
+// the wire:
+//
+//	ci|<hash>|<hotspot x>|<hotspot y>|<base64 png>   new shape, selects it
+//	cc|<hash>                                        select a shape sent before
+//	cp|<serial>|<x>|<y>|<visible>                    position on the display, 0 to 1
+//
+// the client answers cr|<hash> for a shape it does not hold, after
+// joining late. Positions go out on the unreliable channel while shapes
+// and requests for them go through Shapes, which needs a reliable
+// ordered channel
+type Cursor struct {
+	source  Source
+	display Display
+
+	send   chan interface{}
+	images chan interface{}
+	recv   chan datachannel.Message
+
+	mut    *sync.Mutex
+	shapes map[string]datachannel.Message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewCursor(source Source, display Display) *Cursor {
+	c := &Cursor{
+		source:  source,
+		display: display,
+		send:    make(chan interface{}, queue_size),
+		images:  make(chan interface{}, queue_size),
+		recv:    make(chan datachannel.Message, queue_size),
+		mut:     &sync.Mutex{},
+		shapes:  map[string]datachannel.Message{},
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	last := proxy.CursorState{Shape: -1}
+	position := ""
+	thread.SafeLoop(c.ctx, poll_period, func() {
+		state, ok := c.source.Read(last.Shape)
+		if !ok || state.Serial == last.Serial {
+			return
+		}
+
+		if state.Shape != last.Shape && state.Bitmap != nil {
+			if err := c.shape(state); err != nil {
+				fmt.Printf("failed to encode cursor %s\n", err.Error())
+			}
+		}
+		if next := c.position(state); next != position {
+			position = next
+			c.send <- datachannel.Text(fmt.Sprintf("cp|%d|%s", state.Serial, position))
+		}
+		last = state
+	})
+	thread.SafeLoop(c.ctx, 0, func() {
+		select {
+		case <-c.ctx.Done():
+		case request := <-c.recv:
+			if hash, found := strings.CutPrefix(string(request.Data), "cr|"); found {
+				c.mut.Lock()
+				msg, cached := c.shapes[hash]
+				c.mut.Unlock()
+				if cached {
+					c.images <- msg.ReplyTo(request)
+				}
+			}
+		}
+	})
+
+	return c
+}
+
+// shape sends the image the first time its content is seen, and a
+// reference to the cached image afterwards
+func (c *Cursor) shape(state proxy.CursorState) error {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%d|%d|%d|%d|", state.Width, state.Height, state.HotspotX, state.HotspotY)
+	sum.Write(state.Bitmap)
+	hash := hex.EncodeToString(sum.Sum(nil)[:8])
+
+	c.mut.Lock()
+	_, cached := c.shapes[hash]
+	c.mut.Unlock()
+	if cached {
+		c.images <- datachannel.Text("cc|" + hash)
+		return nil
+	} else if state.Width > max_dimension || state.Height > max_dimension {
+		return fmt.Errorf("%w: %dx%d", ErrTooLarge, state.Width, state.Height)
+	}
+
+	img := &image.NRGBA{
+		Pix:    state.Bitmap,
+		Stride: state.Width * 4,
+		Rect:   image.Rect(0, 0, state.Width, state.Height),
+	}
+	encoded := &bytes.Buffer{}
+	if err := png.Encode(encoded, img); err != nil {
+		return err
+	}
+
+	msg := datachannel.Text(fmt.Sprintf("ci|%s|%d|%d|%s", hash, state.HotspotX, state.HotspotY,
+		base64.StdEncoding.EncodeToString(encoded.Bytes())))
+	if len(msg.Data) > max_message {
+		return fmt.Errorf("%w: %d bytes encoded", ErrTooLarge, len(msg.Data))
+	}
+
+	c.mut.Lock()
+	if len(c.shapes) >= max_shapes {
+		c.shapes = map[string]datachannel.Message{}
+	}
+	c.shapes[hash] = msg
+	c.mut.Unlock()
+	c.images <- msg
+	return nil
+}
+
+// position maps the hotspot onto the streamed display, a cursor outside
+// of it is hidden
+func (c *Cursor) position(state proxy.CursorState) string {
+	_, width, height, offsetX, offsetY, _, _ := c.display.GetDisplay()
+	if width <= 0 || height <= 0 {
+		return "0|0|0"
+	}
+
+	x := float64(state.X-offsetX) / float64(width)
+	y := float64(state.Y-offsetY) / float64(height)
+	visible := state.Visible && x >= 0 && x < 1 && y >= 0 && y < 1
+	if !visible {
+		return "0|0|0"
+	}
+	return fmt.Sprintf("%.4f|%.4f|1", x, y)
+}
+
+// CoalescePosition keeps only the latest of queued position updates
+func CoalescePosition(queued, incoming datachannel.Message) (datachannel.Message, bool) {
+	if strings.HasPrefix(string(queued.Data), "cp|") && strings.HasPrefix(string(incoming.Data), "cp|") {
+		return incoming, true
+	}
+	return incoming, false
+}
+
+func (c *Cursor) Recv() chan interface{} {
+	return c.send
+}
+
+func (c *Cursor) Send(msg datachannel.Message) {
+	select {
+	case c.recv <- msg:
+	default:
+	}
+}
+
+func (c *Cursor) Close() {
+	c.cancel()
+}
+
+// shapes carries ci and cc messages and the requests for them over the
+// reliable channel
+type shapes struct {
+	cursor *Cursor
+}
+
+func (c *Cursor) Shapes() datachannel.DatachannelConsumer {
+	return &shapes{cursor: c}
+}
+
+func (s *shapes) Recv() chan interface{} {
+	return s.cursor.images
+}
+func (s *shapes) Send(msg datachannel.Message) {
+	s.cursor.Send(msg)
+}
+
+// Close leaves the cursor to the position consumer
+func (s *shapes) Close() {}
@@ -0,0 +1,131 @@
+package cursor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+)
+
+type fakeSource struct {
+	mut   sync.Mutex
+	state proxy.CursorState
+	ok    bool
+}
+
+func (f *fakeSource) Read(shape int) (proxy.CursorState, bool) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	state := f.state
+	if state.Shape == shape {
+		state.Bitmap = nil
+	}
+	return state, f.ok
+}
+
+func (f *fakeSource) update(fun func(state *proxy.CursorState)) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	fun(&f.state)
+	f.state.Serial += 2
+}
+
+type fakeDisplay struct{}
+
+func (fakeDisplay) GetDisplay() (string, int, int, int, int, int, int) {
+	return "display", 1000, 500, 1000, 0, 2000, 500
+}
+
+func bitmap(fill byte) []byte {
+	return bytes.Repeat([]byte{fill, fill, fill, 0xFF}, 4*4)
+}
+
+func next(t *testing.T, consumer datachannel.DatachannelConsumer) string {
+	select {
+	case msg := <-consumer.Recv():
+		return string(msg.(datachannel.Message).Data)
+	case <-time.After(time.Second):
+		require.FailNow(t, "no cursor message")
+		return ""
+	}
+}
+
+func TestCursor(t *testing.T) {
+	source := &fakeSource{ok: true, state: proxy.CursorState{
+		Serial: 2, Visible: true, X: 1500, Y: 250,
+		Shape: 1, Width: 4, Height: 4, HotspotX: 1, HotspotY: 2,
+		Bitmap: bitmap(0x10),
+	}}
+	consumer := NewCursor(source, fakeDisplay{})
+	defer consumer.Close()
+	shapes := consumer.Shapes()
+
+	image := next(t, shapes)
+	parts := strings.Split(image, "|")
+	require.Len(t, parts, 5)
+	require.Equal(t, []string{"ci", "1", "2"}, []string{parts[0], parts[2], parts[3]})
+	encoded, err := base64.StdEncoding.DecodeString(parts[4])
+	require.Nil(t, err)
+	decoded, err := png.Decode(bytes.NewReader(encoded))
+	require.Nil(t, err)
+	require.Equal(t, 4, decoded.Bounds().Dx())
+	require.Equal(t, "cp|2|0.5000|0.5000|1", next(t, consumer))
+	arrow := parts[1]
+
+	source.update(func(state *proxy.CursorState) {
+		state.Shape, state.Bitmap = 2, bitmap(0x20)
+	})
+	require.True(t, strings.HasPrefix(next(t, shapes), "ci|"))
+
+	// the same image under a new shape is referenced, not resent
+	source.update(func(state *proxy.CursorState) {
+		state.Shape, state.Bitmap = 3, bitmap(0x10)
+	})
+	require.Equal(t, "cc|"+arrow, next(t, shapes))
+
+	// leaving the streamed display hides the cursor
+	source.update(func(state *proxy.CursorState) { state.X = 500 })
+	require.Equal(t, "cp|8|0|0|0", next(t, consumer))
+
+	// only the viewer asking for a shape gets it again
+	request := datachannel.Text("cr|" + arrow)
+	request.Peer = "late"
+	shapes.Send(request)
+	select {
+	case msg := <-shapes.Recv():
+		require.Equal(t, image, string(msg.(datachannel.Message).Data))
+		require.Equal(t, "late", msg.(datachannel.Message).Peer)
+	case <-time.After(time.Second):
+		require.FailNow(t, "shape was not resent")
+	}
+}
+
+func TestLargeShape(t *testing.T) {
+	c := &Cursor{images: make(chan interface{}, 1), mut: &sync.Mutex{}, shapes: map[string]datachannel.Message{}}
+	large := proxy.CursorState{Width: max_dimension + 1, Height: 1, Bitmap: make([]byte, (max_dimension+1)*4)}
+	require.ErrorIs(t, c.shape(large), ErrTooLarge)
+
+	// noise does not compress below the message limit
+	noise := make([]byte, max_dimension*max_dimension*4)
+	rand.New(rand.NewSource(1)).Read(noise)
+	require.ErrorIs(t, c.shape(proxy.CursorState{Width: max_dimension, Height: max_dimension, Bitmap: noise}), ErrTooLarge)
+	require.Empty(t, c.images)
+	require.Empty(t, c.shapes)
+}
+
+func TestCoalescePosition(t *testing.T) {
+	merged, ok := CoalescePosition(datachannel.Text("cp|2|0.1|0.1|1"), datachannel.Text("cp|4|0.2|0.2|1"))
+	require.True(t, ok)
+	require.Equal(t, "cp|4|0.2|0.2|1", string(merged.Data))
+
+	_, ok = CoalescePosition(datachannel.Text("ci|abc|0|0|"), datachannel.Text("cp|4|0.2|0.2|1"))
+	require.False(t, ok)
+}
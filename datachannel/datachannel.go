@@ -1,21 +1,172 @@
 package datachannel
 
+import (
+	"slices"
+	"sync"
+)
+
 const (
 	queue_size = 32
 )
 
+// Capability is something a session may be allowed to do over a datachannel
+type Capability string
+
+const (
+	CapInput     Capability = "input"
+	CapGamepad   Capability = "gamepad"
+	CapClipboard Capability = "clipboard"
+	CapFile      Capability = "file"
+	// CapControl changes the encoder and display settings every viewer
+	// of the host shares
+	CapControl Capability = "control"
+)
+
+// Permissions are the capabilities granted to a session, a nil
+// Permissions is an unrestricted local sender
+type Permissions struct {
+	mut  *sync.RWMutex
+	caps map[Capability]bool
+}
+
+func NewPermissions(caps ...Capability) *Permissions {
+	ret := &Permissions{
+		mut:  &sync.RWMutex{},
+		caps: map[Capability]bool{},
+	}
+	for _, c := range caps {
+		ret.caps[c] = true
+	}
+	return ret
+}
+
+// Allows reports whether the session holds c, the empty capability is
+// always allowed
+func (p *Permissions) Allows(c Capability) bool {
+	if p == nil || c == "" {
+		return true
+	}
+
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.caps[c]
+}
+
+// Set grants or revokes c while the session runs
+func (p *Permissions) Set(c Capability, allowed bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if allowed {
+		p.caps[c] = true
+	} else {
+		delete(p.caps, c)
+	}
+}
+
+func (p *Permissions) Capabilities() []Capability {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	ret := []Capability{}
+	for c := range p.caps {
+		ret = append(ret, c)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// Message is a single datachannel payload, Binary selects between
+// binary and text framing on the wire
+type Message struct {
+	Data   []byte
+	Binary bool
+
+	// From is the sender toward the consumer, consumers drop what it
+	// is not allowed to do
+	From *Permissions
+	// Requires keeps a message toward peers from sessions lacking it
+	Requires Capability
+	// Peer is the handle a message from a peer arrived through, a message
+	// toward peers with Peer set only reaches that handle
+	Peer string
+}
+
+func Text(msg string) Message {
+	return Message{Data: []byte(msg)}
+}
+
+func Bytes(data []byte) Message {
+	return Message{Data: data, Binary: true}
+}
+
+// Require restricts the message to peers allowed c
+func (msg Message) Require(c Capability) Message {
+	msg.Requires = c
+	return msg
+}
+
+// ReplyTo restricts the message to the peer request came from
+func (msg Message) ReplyTo(request Message) Message {
+	msg.Peer = request.Peer
+	return msg
+}
+
+// Options configures the underlying SCTP stream of a group, the zero value
+// is a reliable ordered channel announced in-band
+type Options struct {
+	Unordered bool
+	// at most one of MaxRetransmits and MaxPacketLifeTime (milliseconds) may be set
+	MaxRetransmits    *uint16
+	MaxPacketLifeTime *uint16
+	// ID negotiates the channel out of band with this stream id when set
+	ID       *uint16
+	Protocol string
+
+	// QueueSize bounds the queue toward the consumer and toward each peer
+	QueueSize int
+	Overflow  Overflow
+	// Coalesce merges an incoming message into the last queued one once the
+	// queue is full, reporting false when it cannot
+	Coalesce func(queued, incoming Message) (Message, bool)
+	// Keep marks messages that survive overflow, a full queue grows past
+	// QueueSize for them up to twice its size
+	Keep func(Message) bool
+}
+
+type HandlerStats struct {
+	Delivered uint64
+	Dropped   uint64
+	Failed    uint64
+}
+
+// GroupStats counts messages from peers to the consumer at group level,
+// and messages from the consumer to each peer per handler
+type GroupStats struct {
+	Delivered uint64
+	Dropped   uint64
+	Handlers  map[string]HandlerStats
+}
+
+type Group struct {
+	Name    string
+	Options Options
+}
+
 type IDatachannel interface {
 	Groups() []string
-	Send(group string, pkt string)
+	Options(group string) Options
+	Stats(group string) (GroupStats, error)
+	Send(group string, msg Message) error
 
-	RegisterHandle(group string, id string, handler func(msg string))
-	DeregisterHandle(group string, id string)
+	RegisterHandle(group string, id string, handler func(msg Message) error) error
+	DeregisterHandle(group string, id string) error
 
-	RegisterConsumer(group string, consumer DatachannelConsumer)
-	DeregisterConsumer(group string)
+	RegisterConsumer(group string, consumer DatachannelConsumer) error
+	DeregisterConsumer(group string) error
 }
 
+// DatachannelConsumer exchanges Message values, Recv delivers them toward the peer
 type DatachannelConsumer interface {
-	Send(string)
+	Send(Message)
 	Recv() chan interface{}
+	Close()
 }
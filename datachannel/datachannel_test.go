@@ -0,0 +1,28 @@
+package datachannel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissions(t *testing.T) {
+	var local *Permissions
+	require.True(t, local.Allows(CapFile))
+
+	view := NewPermissions()
+	require.True(t, view.Allows(""))
+	require.False(t, view.Allows(CapInput))
+
+	input := NewPermissions(CapInput, CapGamepad)
+	require.True(t, input.Allows(CapGamepad))
+	require.False(t, input.Allows(CapClipboard))
+
+	msg := Text("cu|data").Require(CapClipboard)
+	require.Equal(t, CapClipboard, msg.Requires)
+	require.False(t, input.Allows(msg.Requires))
+
+	input.Set(CapInput, false)
+	input.Set(CapClipboard, true)
+	require.Equal(t, []Capability{CapClipboard, CapGamepad}, input.Capabilities())
+}
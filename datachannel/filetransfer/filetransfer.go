@@ -0,0 +1,628 @@
+package filetransfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	queue_size      = 64
+	partial_suffix  = ".part"
+	progress_period = 1024 * 1024
+	expire_period   = time.Second
+)
+
+var (
+	ErrInvalidPath = errors.New("path escapes the transfer directory")
+	ErrTooLarge    = errors.New("file exceeds size limit")
+	ErrTooMany     = errors.New("too many concurrent transfers")
+	ErrUnknownID   = errors.New("no transfer with this id")
+	ErrChecksum    = errors.New("file checksum mismatch")
+	ErrOutOfRange  = errors.New("chunk outside of file")
+	ErrIdle        = errors.New("transfer idle for too long")
+	ErrTakenOver   = errors.New("upload resumed by another session")
+)
+
+type Config struct {
+	// Directory is the only place uploads are written to and downloads read from
+	Directory string
+	// MaxConcurrent bounds uploads and downloads running at the same time
+	MaxConcurrent int
+	// MaxFileSize rejects uploads larger than this, in bytes
+	MaxFileSize int64
+	// ChunkSize is the payload size of download chunks
+	ChunkSize int
+	// Window is the number of unacknowledged download bytes in flight
+	Window int64
+	// IdleTimeout drops uploads receiving nothing for this long, their
+	// partial file stays for a later resume
+	IdleTimeout time.Duration
+}
+
+func DefaultConfig(directory string) Config {
+	return Config{
+		Directory:     directory,
+		MaxConcurrent: 4,
+		MaxFileSize:   4 * 1024 * 1024 * 1024,
+		ChunkSize:     16 * 1024,
+		Window:        1024 * 1024,
+		IdleTimeout:   time.Minute * 2,
+	}
+}
+
+type transfer struct {
+	id     string
+	path   string
+	upload bool
+
+	file *os.File
+	size int64
+	// offset is the end of the contiguous prefix of an upload, pending
+	// holds chunks written past it keyed by start
+	offset   int64
+	pending  map[int64]int64
+	reported int64
+	checksum string
+	// touched is the last time the client sent anything for the transfer
+	touched time.Time
+
+	// request started the transfer, replies go to the peer it came from
+	request datachannel.Message
+
+	acked  chan int64
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// key identifies a transfer by the peer that started it and the id that
+// peer chose, ids of different peers never collide
+type key struct {
+	peer string
+	id   string
+}
+
+func (t *transfer) key() key {
+	return key{t.request.Peer, t.id}
+}
+
+type FileTransfer struct {
+	conf Config
+	root string
+
+	send chan interface{}
+	recv chan datachannel.Message
+
+	mut       *sync.Mutex
+	transfers map[key]*transfer
+
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+func NewFileTransfer(conf Config) (datachannel.DatachannelConsumer, error) {
+	root, err := filepath.Abs(conf.Directory)
+	if err != nil {
+		return nil, err
+	} else if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	} else if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, err
+	}
+
+	def := DefaultConfig(conf.Directory)
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = def.ChunkSize
+	}
+	if conf.Window <= 0 {
+		conf.Window = def.Window
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = def.IdleTimeout
+	}
+
+	ft := &FileTransfer{
+		conf:      conf,
+		root:      root,
+		send:      make(chan interface{}, queue_size),
+		recv:      make(chan datachannel.Message, queue_size),
+		mut:       &sync.Mutex{},
+		transfers: map[key]*transfer{},
+	}
+	ft.ctx, ft.stop = context.WithCancel(context.Background())
+
+	// uploads expire on the goroutine writing them
+	ticker := time.NewTicker(expire_period)
+	thread.OnDone(ft.ctx, ticker.Stop)
+	thread.SafeLoop(ft.ctx, 0, func() {
+		select {
+		case <-ft.ctx.Done():
+		case <-ticker.C:
+			ft.expire(time.Now())
+		case msg := <-ft.recv:
+			ft.handle(msg)
+		}
+	})
+
+	return ft, nil
+}
+
+// resolve maps a client supplied path into the transfer directory,
+// rejecting anything that would land outside of it
+func (ft *FileTransfer) resolve(path string) (string, error) {
+	for _, element := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+
+	clean := filepath.Clean(string(filepath.Separator) + filepath.FromSlash(path))
+	full := filepath.Join(ft.root, clean)
+	if rel, err := filepath.Rel(ft.root, full); err != nil || strings.HasPrefix(rel, "..") {
+		return "", ErrInvalidPath
+	}
+
+	// symlinks inside the directory must not point outside of it
+	parent, err := filepath.EvalSymlinks(filepath.Dir(full))
+	if err != nil {
+		return "", err
+	} else if rel, err := filepath.Rel(ft.root, parent); err != nil || strings.HasPrefix(rel, "..") {
+		return "", ErrInvalidPath
+	}
+	if info, err := os.Lstat(full); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", ErrInvalidPath
+	}
+
+	return full, nil
+}
+
+// reply goes only to the peer request came from
+func (ft *FileTransfer) reply(request datachannel.Message, op byte, ctrl *Control) {
+	select {
+	case <-ft.ctx.Done():
+	case ft.send <- datachannel.Bytes(EncodeControl(op, ctrl)).Require(datachannel.CapFile).ReplyTo(request):
+	}
+}
+
+func (ft *FileTransfer) fail(request datachannel.Message, id string, err error) {
+	ft.reply(request, OpError, &Control{ID: id, Error: err.Error()})
+}
+
+func (ft *FileTransfer) handle(msg datachannel.Message) {
+	frame := msg.Data
+	if len(frame) == 0 {
+		return
+	} else if frame[0] == OpChunk {
+		if chunk, err := DecodeChunk(frame); err != nil {
+			fmt.Printf("invalid file chunk %s\n", err.Error())
+		} else if err := ft.write(msg, chunk); err != nil {
+			ft.fail(msg, chunk.ID, err)
+		}
+		return
+	}
+
+	ctrl, err := DecodeControl(frame)
+	if err != nil {
+		fmt.Printf("invalid file control %s\n", err.Error())
+		return
+	}
+
+	switch frame[0] {
+	case OpUpload:
+		err = ft.startUpload(msg, ctrl)
+	case OpFinish:
+		err = ft.finishUpload(msg, ctrl)
+	case OpDownload:
+		err = ft.startDownload(msg, ctrl)
+	case OpProgress:
+		err = ft.ack(msg, ctrl)
+	case OpCancel:
+		err = ft.cancel(msg, ctrl.ID)
+	case OpList:
+		err = ft.list(msg, ctrl)
+	default:
+		err = fmt.Errorf("unknown opcode %c", frame[0])
+	}
+
+	if err != nil {
+		ft.fail(msg, ctrl.ID, err)
+	}
+}
+
+// register replaces any transfer the same peer runs under the same id
+func (ft *FileTransfer) register(t *transfer) error {
+	ft.mut.Lock()
+	defer ft.mut.Unlock()
+	if old, found := ft.transfers[t.key()]; found {
+		delete(ft.transfers, t.key())
+		old.close()
+	}
+
+	if len(t.id) == 0 || len(t.id) > 0xFF {
+		return fmt.Errorf("invalid transfer id %q", t.id)
+	} else if ft.conf.MaxConcurrent > 0 && len(ft.transfers) >= ft.conf.MaxConcurrent {
+		return ErrTooMany
+	}
+
+	ft.transfers[t.key()] = t
+	return nil
+}
+
+// lookup finds the transfer id of the peer request came from, transfers
+// of other peers are unknown to it
+func (ft *FileTransfer) lookup(request datachannel.Message, id string) (*transfer, error) {
+	ft.mut.Lock()
+	defer ft.mut.Unlock()
+	if t, found := ft.transfers[key{request.Peer, id}]; found {
+		return t, nil
+	}
+	return nil, ErrUnknownID
+}
+
+// uploading lists the uploads writing the partial file of path
+func (ft *FileTransfer) uploading(path string) []*transfer {
+	ft.mut.Lock()
+	defer ft.mut.Unlock()
+	ret := []*transfer{}
+	for _, t := range ft.transfers {
+		if t.upload && t.path == path {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// remove drops the transfer if it is still the active one for its key
+func (ft *FileTransfer) remove(t *transfer) bool {
+	ft.mut.Lock()
+	defer ft.mut.Unlock()
+	if ft.transfers[t.key()] != t {
+		return false
+	}
+
+	delete(ft.transfers, t.key())
+	t.close()
+	return true
+}
+
+// close cuts a partial upload back to its contiguous prefix, so a resume
+// starting from the file size never skips a gap
+func (t *transfer) close() {
+	if t.upload {
+		t.file.Truncate(t.offset)
+	}
+	t.file.Close()
+	t.cancel()
+}
+
+// expire drops uploads the client abandoned before now
+func (ft *FileTransfer) expire(now time.Time) {
+	idle := []*transfer{}
+	ft.mut.Lock()
+	for _, t := range ft.transfers {
+		if t.upload && now.Sub(t.touched) > ft.conf.IdleTimeout {
+			idle = append(idle, t)
+		}
+	}
+	ft.mut.Unlock()
+
+	for _, t := range idle {
+		if ft.remove(t) {
+			ft.fail(t.request, t.id, ErrIdle)
+		}
+	}
+}
+
+// startUpload opens or reopens the partial file, a reconnecting client
+// continues from the offset returned in OpAccept
+func (ft *FileTransfer) startUpload(request datachannel.Message, ctrl *Control) error {
+	path, err := ft.resolve(ctrl.Path)
+	if err != nil {
+		return err
+	} else if path == ft.root {
+		return ErrInvalidPath
+	} else if ft.conf.MaxFileSize > 0 && ctrl.Size > ft.conf.MaxFileSize {
+		return ErrTooLarge
+	}
+
+	// a resumed upload first trims the partial file and takes it over,
+	// the transfer writing it may belong to the session the client
+	// reconnected from
+	for _, old := range ft.uploading(path) {
+		if ft.remove(old) && old.key() != (key{request.Peer, ctrl.ID}) {
+			ft.fail(old.request, old.id, ErrTakenOver)
+		}
+	}
+
+	file, err := os.OpenFile(path+partial_suffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	offset := int64(0)
+	if info, err := file.Stat(); err == nil && info.Size() <= ctrl.Size {
+		offset = info.Size()
+	} else if err := file.Truncate(0); err != nil {
+		file.Close()
+		return err
+	}
+
+	t := &transfer{
+		id:       ctrl.ID,
+		path:     path,
+		upload:   true,
+		file:     file,
+		size:     ctrl.Size,
+		offset:   offset,
+		pending:  map[int64]int64{},
+		reported: offset,
+		checksum: ctrl.Checksum,
+		touched:  time.Now(),
+		request:  request,
+	}
+	t.ctx, t.cancel = context.WithCancel(ft.ctx)
+	if err := ft.register(t); err != nil {
+		file.Close()
+		t.cancel()
+		return err
+	}
+
+	ft.reply(t.request, OpAccept, &Control{ID: t.id, Size: t.size, Offset: t.offset})
+	return nil
+}
+
+func (ft *FileTransfer) write(request datachannel.Message, chunk *Chunk) error {
+	t, err := ft.lookup(request, chunk.ID)
+	if err != nil {
+		return err
+	} else if !t.upload || chunk.Offset < 0 || chunk.Offset+int64(len(chunk.Data)) > t.size {
+		return ErrOutOfRange
+	} else if _, err := t.file.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return err
+	}
+
+	t.touched = time.Now()
+	t.received(chunk.Offset, chunk.Offset+int64(len(chunk.Data)))
+	if t.offset-t.reported >= progress_period || t.offset == t.size {
+		t.reported = t.offset
+		ft.reply(t.request, OpProgress, &Control{ID: t.id, Size: t.size, Offset: t.offset})
+	}
+	return nil
+}
+
+// received extends the contiguous prefix with the chunk [start, end),
+// chunks arriving out of order wait in pending until the gap fills
+func (t *transfer) received(start, end int64) {
+	if start > t.offset {
+		t.pending[start] = max(t.pending[start], end)
+		return
+	}
+
+	t.offset = max(t.offset, end)
+	for merged := true; merged; {
+		merged = false
+		for start, end := range t.pending {
+			if start <= t.offset {
+				t.offset = max(t.offset, end)
+				delete(t.pending, start)
+				merged = true
+			}
+		}
+	}
+}
+
+func checksum(file *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	} else if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (ft *FileTransfer) finishUpload(request datachannel.Message, ctrl *Control) error {
+	t, err := ft.lookup(request, ctrl.ID)
+	if err != nil {
+		return err
+	} else if !t.upload {
+		return ErrUnknownID
+	}
+
+	expect := ctrl.Checksum
+	if expect == "" {
+		expect = t.checksum
+	}
+
+	sum, err := checksum(t.file)
+	ft.remove(t)
+	if err != nil {
+		return err
+	} else if t.offset != t.size {
+		return fmt.Errorf("upload incomplete, %d of %d bytes", t.offset, t.size)
+	} else if expect != "" && !strings.EqualFold(sum, expect) {
+		os.Remove(t.path + partial_suffix)
+		return ErrChecksum
+	} else if err := os.Rename(t.path+partial_suffix, t.path); err != nil {
+		return err
+	}
+
+	ft.reply(request, OpDone, &Control{ID: t.id, Size: t.size, Offset: t.size, Checksum: sum})
+	return nil
+}
+
+func (ft *FileTransfer) startDownload(request datachannel.Message, ctrl *Control) error {
+	path, err := ft.resolve(ctrl.Path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return fmt.Errorf("%s is not a file", ctrl.Path)
+	}
+
+	t := &transfer{
+		id:      ctrl.ID,
+		path:    path,
+		file:    file,
+		size:    info.Size(),
+		offset:  min(max(ctrl.Offset, 0), info.Size()),
+		request: request,
+		acked:   make(chan int64, queue_size),
+	}
+	t.ctx, t.cancel = context.WithCancel(ft.ctx)
+	if err := ft.register(t); err != nil {
+		file.Close()
+		t.cancel()
+		return err
+	}
+
+	ft.reply(t.request, OpAccept, &Control{ID: t.id, Size: t.size, Offset: t.offset})
+	thread.SafeThread(func() { ft.stream(t) })
+	return nil
+}
+
+// stream sends download chunks, keeping at most Window bytes ahead of the
+// offset the client acknowledged with OpProgress. The file is hashed as it
+// is read, OpDone carries the checksum of the whole file
+func (ft *FileTransfer) stream(t *transfer) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(t.file, 0, t.offset)); err != nil {
+		if ft.remove(t) {
+			ft.fail(t.request, t.id, err)
+		}
+		return
+	}
+
+	buffer := make([]byte, ft.conf.ChunkSize)
+	acked := t.offset
+	for t.offset < t.size {
+		for t.offset-acked >= ft.conf.Window {
+			select {
+			case acked = <-t.acked:
+			case <-t.ctx.Done():
+				return
+			case <-time.After(time.Second * 30):
+				if ft.remove(t) {
+					ft.fail(t.request, t.id, errors.New("client stopped acknowledging"))
+				}
+				return
+			}
+		}
+
+		n, err := t.file.ReadAt(buffer, t.offset)
+		if n == 0 && err != nil {
+			if ft.remove(t) {
+				ft.fail(t.request, t.id, err)
+			}
+			return
+		}
+
+		select {
+		case <-t.ctx.Done():
+			return
+		case ft.send <- datachannel.Bytes(EncodeChunk(&Chunk{ID: t.id, Offset: t.offset, Data: buffer[:n]})).Require(datachannel.CapFile).ReplyTo(t.request):
+			hash.Write(buffer[:n])
+			t.offset += int64(n)
+		}
+	}
+
+	if ft.remove(t) {
+		ft.reply(t.request, OpDone, &Control{ID: t.id, Size: t.size, Offset: t.size, Checksum: hex.EncodeToString(hash.Sum(nil))})
+	}
+}
+
+func (ft *FileTransfer) ack(request datachannel.Message, ctrl *Control) error {
+	t, err := ft.lookup(request, ctrl.ID)
+	if err != nil {
+		return err
+	} else if t.upload {
+		return nil
+	}
+
+	if len(t.acked) == queue_size {
+		<-t.acked
+	}
+	t.acked <- ctrl.Offset
+	return nil
+}
+
+// cancel keeps the partial file of an upload so it can be resumed later
+func (ft *FileTransfer) cancel(request datachannel.Message, id string) error {
+	t, err := ft.lookup(request, id)
+	if err != nil {
+		return err
+	}
+
+	ft.remove(t)
+	return nil
+}
+
+func (ft *FileTransfer) list(request datachannel.Message, ctrl *Control) error {
+	path, err := ft.resolve(ctrl.Path)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	entries := []Entry{}
+	for _, entry := range dir {
+		info, err := entry.Info()
+		if err != nil || strings.HasSuffix(entry.Name(), partial_suffix) {
+			continue
+		}
+		entries = append(entries, Entry{Name: entry.Name(), Size: info.Size(), IsDir: entry.IsDir()})
+	}
+
+	ft.reply(request, OpList, &Control{ID: ctrl.ID, Path: ctrl.Path, Entries: entries})
+	return nil
+}
+
+func (ft *FileTransfer) Recv() chan interface{} {
+	return ft.send
+}
+func (ft *FileTransfer) Send(msg datachannel.Message) {
+	if !msg.From.Allows(datachannel.CapFile) {
+		fmt.Printf("dropped file transfer message, session lacks %s\n", datachannel.CapFile)
+		return
+	}
+
+	select {
+	case <-ft.ctx.Done():
+	case ft.recv <- msg:
+	}
+}
+
+// Close stops the consumer along with every transfer still running
+func (ft *FileTransfer) Close() {
+	ft.stop()
+
+	ft.mut.Lock()
+	defer ft.mut.Unlock()
+	for k, t := range ft.transfers {
+		t.close()
+		delete(ft.transfers, k)
+	}
+}
@@ -0,0 +1,309 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread/leakcheck"
+)
+
+func recv(t *testing.T, ft *FileTransfer) []byte {
+	select {
+	case msg := <-ft.Recv():
+		return msg.(datachannel.Message).Data
+	case <-time.After(time.Second):
+		t.Fatal("no reply from file transfer")
+		return nil
+	}
+}
+
+func expect(t *testing.T, ft *FileTransfer, op byte) *Control {
+	frame := recv(t, ft)
+	require.Equal(t, string(op), string(frame[0]), string(frame))
+	ctrl, err := DecodeControl(frame)
+	require.Nil(t, err)
+	return ctrl
+}
+
+func newTransfer(t *testing.T) (*FileTransfer, string) {
+	leakcheck.Check(t)
+	dir := t.TempDir()
+	consumer, err := NewFileTransfer(DefaultConfig(dir))
+	require.Nil(t, err)
+	t.Cleanup(consumer.Close)
+	return consumer.(*FileTransfer), dir
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	frame := EncodeChunk(&Chunk{ID: "abc", Offset: 42, Data: []byte("payload")})
+	chunk, err := DecodeChunk(frame)
+	require.Nil(t, err)
+	require.Equal(t, "abc", chunk.ID)
+	require.Equal(t, int64(42), chunk.Offset)
+	require.Equal(t, []byte("payload"), chunk.Data)
+
+	frame[len(frame)-1] ^= 0xFF
+	_, err = DecodeChunk(frame)
+	require.ErrorIs(t, err, errBadCRC)
+}
+
+func TestResumeUpload(t *testing.T) {
+	ft, dir := newTransfer(t)
+	content := []byte("hello remote desktop")
+	sum := sha256.Sum256(content)
+
+	upload := &Control{ID: "1", Path: "docs/hello.txt", Size: int64(len(content)), Checksum: hex.EncodeToString(sum[:])}
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "docs"), 0755))
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, upload)))
+	require.Equal(t, int64(0), expect(t, ft, OpAccept).Offset)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: content[:5]})))
+
+	// reconnect, the host reports what it already has
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, upload)))
+	require.Equal(t, int64(5), expect(t, ft, OpAccept).Offset)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 5, Data: content[5:]})))
+	require.Equal(t, int64(len(content)), expect(t, ft, OpProgress).Offset)
+
+	ft.Send(datachannel.Bytes(EncodeControl(OpFinish, &Control{ID: "1"})))
+	expect(t, ft, OpDone)
+
+	written, err := os.ReadFile(filepath.Join(dir, "docs", "hello.txt"))
+	require.Nil(t, err)
+	require.Equal(t, content, written)
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	ft, dir := newTransfer(t)
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "bad.bin", Size: 3, Checksum: "00"})))
+	expect(t, ft, OpAccept)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: []byte("abc")})))
+	expect(t, ft, OpProgress)
+	ft.Send(datachannel.Bytes(EncodeControl(OpFinish, &Control{ID: "1"})))
+	require.Equal(t, ErrChecksum.Error(), expect(t, ft, OpError).Error)
+
+	_, err := os.Stat(filepath.Join(dir, "bad.bin"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPathTraversal(t *testing.T) {
+	ft, dir := newTransfer(t)
+	outside := t.TempDir()
+	require.Nil(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+	for _, path := range []string{"../escape.txt", "/../../etc/passwd", "link/escape.txt", ""} {
+		ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: path, Size: 1})))
+		expect(t, ft, OpError)
+	}
+
+	entries, err := os.ReadDir(outside)
+	require.Nil(t, err)
+	require.Empty(t, entries)
+}
+
+func TestDownloadFromOffset(t *testing.T) {
+	ft, dir := newTransfer(t)
+	content := make([]byte, DefaultConfig(dir).ChunkSize*3)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "game.sav"), content, 0644))
+
+	ft.Send(datachannel.Bytes(EncodeControl(OpDownload, &Control{ID: "2", Path: "game.sav", Offset: 100})))
+	accept := expect(t, ft, OpAccept)
+	require.Equal(t, int64(100), accept.Offset)
+	require.Equal(t, int64(len(content)), accept.Size)
+
+	received := []byte{}
+	for int64(100+len(received)) < accept.Size {
+		chunk, err := DecodeChunk(recv(t, ft))
+		require.Nil(t, err)
+		require.Equal(t, int64(100+len(received)), chunk.Offset)
+		received = append(received, chunk.Data...)
+	}
+	require.Equal(t, content[100:], received)
+	sum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sum[:]), expect(t, ft, OpDone).Checksum)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	dir := t.TempDir()
+	conf := DefaultConfig(dir)
+	conf.MaxConcurrent = 1
+	consumer, err := NewFileTransfer(conf)
+	require.Nil(t, err)
+	ft := consumer.(*FileTransfer)
+
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "a", Size: 10})))
+	expect(t, ft, OpAccept)
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "2", Path: "b", Size: 10})))
+	require.Equal(t, ErrTooMany.Error(), expect(t, ft, OpError).Error)
+}
+
+func TestPermissions(t *testing.T) {
+	ft, dir := newTransfer(t)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "game.sav"), []byte("save"), 0644))
+
+	msg := datachannel.Bytes(EncodeControl(OpDownload, &Control{ID: "3", Path: "game.sav"}))
+	msg.From = datachannel.NewPermissions(datachannel.CapInput)
+	ft.Send(msg)
+	select {
+	case reply := <-ft.Recv():
+		t.Fatalf("view only session got a reply %v", reply)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	msg.From = datachannel.NewPermissions(datachannel.CapFile)
+	ft.Send(msg)
+	reply := recv(t, ft)
+	require.Equal(t, string(OpAccept), string(reply[0]))
+}
+
+func TestRepliesToRequester(t *testing.T) {
+	ft, dir := newTransfer(t)
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "saves"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "saves", "game.sav"), []byte("save"), 0644))
+
+	list := datachannel.Bytes(EncodeControl(OpList, &Control{ID: "1", Path: "saves"}))
+	list.Peer = "viewer"
+	ft.Send(list)
+	reply := (<-ft.Recv()).(datachannel.Message)
+	require.Equal(t, string(OpList), string(reply.Data[0]))
+	require.Equal(t, "viewer", reply.Peer)
+
+	download := datachannel.Bytes(EncodeControl(OpDownload, &Control{ID: "2", Path: "saves/game.sav"}))
+	download.Peer = "other"
+	ft.Send(download)
+	for range 3 {
+		require.Equal(t, "other", (<-ft.Recv()).(datachannel.Message).Peer)
+	}
+}
+
+func TestOutOfOrderUpload(t *testing.T) {
+	ft, _ := newTransfer(t)
+	upload := &Control{ID: "1", Path: "out.bin", Size: int64(3 * progress_period)}
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, upload)))
+	expect(t, ft, OpAccept)
+
+	block := make([]byte, progress_period)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: progress_period, Data: block})))
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 2 * progress_period, Data: block})))
+	select {
+	case msg := <-ft.Recv():
+		t.Fatalf("progress past a gap %v", msg)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// a resume before the gap is filled starts at the gap
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, upload)))
+	require.Equal(t, int64(0), expect(t, ft, OpAccept).Offset)
+
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 2 * progress_period, Data: block})))
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: block})))
+	require.Equal(t, int64(progress_period), expect(t, ft, OpProgress).Offset)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: progress_period, Data: block})))
+	require.Equal(t, upload.Size, expect(t, ft, OpProgress).Offset)
+}
+
+func TestIdleUpload(t *testing.T) {
+	ft, dir := newTransfer(t)
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "idle.bin", Size: 10})))
+	expect(t, ft, OpAccept)
+	ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: []byte("abcdefghij")})))
+	expect(t, ft, OpProgress)
+
+	ft.expire(time.Now().Add(ft.conf.IdleTimeout + time.Second))
+	require.Equal(t, ErrIdle.Error(), expect(t, ft, OpError).Error)
+	_, err := ft.lookup(datachannel.Message{}, "1")
+	require.ErrorIs(t, err, ErrUnknownID)
+
+	partial, err := os.ReadFile(filepath.Join(dir, "idle.bin"+partial_suffix))
+	require.Nil(t, err)
+	require.Equal(t, []byte("abcdefghij"), partial)
+}
+
+func TestTransferOwnership(t *testing.T) {
+	ft, dir := newTransfer(t)
+	owner := datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "mine.bin", Size: 4}))
+	owner.Peer = "owner"
+	ft.Send(owner)
+	expect(t, ft, OpAccept)
+
+	// another viewer picking the same id neither writes into nor cancels it
+	for _, frame := range [][]byte{
+		EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: []byte("evil")}),
+		EncodeControl(OpProgress, &Control{ID: "1", Offset: 4}),
+		EncodeControl(OpCancel, &Control{ID: "1"}),
+	} {
+		msg := datachannel.Bytes(frame)
+		msg.Peer = "other"
+		ft.Send(msg)
+		reply := (<-ft.Recv()).(datachannel.Message)
+		require.Equal(t, "other", reply.Peer)
+		ctrl, err := DecodeControl(reply.Data)
+		require.Nil(t, err)
+		require.Equal(t, ErrUnknownID.Error(), ctrl.Error)
+	}
+
+	other := datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "theirs.bin", Size: 4}))
+	other.Peer = "other"
+	ft.Send(other)
+	expect(t, ft, OpAccept)
+
+	chunk := datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: 0, Data: []byte("mine")}))
+	chunk.Peer = "owner"
+	ft.Send(chunk)
+	require.Equal(t, int64(4), expect(t, ft, OpProgress).Offset)
+	finish := datachannel.Bytes(EncodeControl(OpFinish, &Control{ID: "1"}))
+	finish.Peer = "owner"
+	ft.Send(finish)
+	expect(t, ft, OpDone)
+
+	written, err := os.ReadFile(filepath.Join(dir, "mine.bin"))
+	require.Nil(t, err)
+	require.Equal(t, []byte("mine"), written)
+}
+
+func TestExpireWhileWriting(t *testing.T) {
+	leakcheck.Check(t)
+	conf := DefaultConfig(t.TempDir())
+	conf.IdleTimeout = time.Nanosecond
+	consumer, err := NewFileTransfer(conf)
+	require.Nil(t, err)
+	ft := consumer.(*FileTransfer)
+	defer ft.Close()
+
+	ft.Send(datachannel.Bytes(EncodeControl(OpUpload, &Control{ID: "1", Path: "busy.bin", Size: progress_period})))
+	expect(t, ft, OpAccept)
+
+	// chunks keep arriving until the upload expires under them
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for offset := int64(0); ; offset = (offset + 1024) % progress_period {
+			select {
+			case <-done:
+				return
+			default:
+				ft.Send(datachannel.Bytes(EncodeChunk(&Chunk{ID: "1", Offset: offset, Data: make([]byte, 1024)})))
+			}
+		}
+	}()
+
+	timeout := time.After(expire_period * 3)
+	for {
+		select {
+		case msg := <-ft.Recv():
+			if ctrl, err := DecodeControl(msg.(datachannel.Message).Data); err == nil && ctrl.Error == ErrIdle.Error() {
+				return
+			}
+		case <-timeout:
+			t.Fatal("upload never expired")
+		}
+	}
+}
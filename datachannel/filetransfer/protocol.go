@@ -0,0 +1,101 @@
+package filetransfer
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+)
+
+// every frame starts with one opcode byte, control frames carry a json
+// encoded Control while OpChunk carries a binary chunk header followed by data
+//
+//	+--------+--------+-------------+--------------+-------------+------+
+//	| OpChunk| id len |     id      | offset (u64) | crc32 (u32) | data |
+//	+--------+--------+-------------+--------------+-------------+------+
+const (
+	OpUpload   = byte('U') // client -> host, start or resume an upload
+	OpDownload = byte('G') // client -> host, start or resume a download
+	OpChunk    = byte('C') // both ways, a piece of file content
+	OpFinish   = byte('F') // client -> host, upload content complete
+	OpProgress = byte('P') // both ways, bytes received so far
+	OpCancel   = byte('X') // client -> host, abort a transfer
+	OpList     = byte('L') // client -> host, list a directory
+	OpAccept   = byte('A') // host -> client, transfer accepted from offset
+	OpDone     = byte('D') // host -> client, transfer verified and complete
+	OpError    = byte('E') // host -> client, transfer failed
+
+	chunk_header_size = 1 + 1 + 8 + 4
+)
+
+var (
+	errShortFrame = errors.New("frame is not large enough")
+	errBadCRC     = errors.New("chunk checksum mismatch")
+)
+
+type Entry struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"dir"`
+}
+
+type Control struct {
+	ID       string  `json:"id"`
+	Path     string  `json:"path,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Offset   int64   `json:"offset"`
+	Checksum string  `json:"checksum,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Entries  []Entry `json:"entries,omitempty"`
+}
+
+type Chunk struct {
+	ID     string
+	Offset int64
+	Data   []byte
+}
+
+func EncodeControl(op byte, ctrl *Control) []byte {
+	b, _ := json.Marshal(ctrl)
+	return append([]byte{op}, b...)
+}
+
+func DecodeControl(frame []byte) (*Control, error) {
+	if len(frame) < 2 {
+		return nil, errShortFrame
+	}
+
+	ctrl := &Control{}
+	if err := json.Unmarshal(frame[1:], ctrl); err != nil {
+		return nil, err
+	}
+	return ctrl, nil
+}
+
+func EncodeChunk(chunk *Chunk) []byte {
+	frame := make([]byte, chunk_header_size+len(chunk.ID)+len(chunk.Data))
+	frame[0] = OpChunk
+	frame[1] = byte(len(chunk.ID))
+	i := 2 + copy(frame[2:], chunk.ID)
+	binary.BigEndian.PutUint64(frame[i:], uint64(chunk.Offset))
+	binary.BigEndian.PutUint32(frame[i+8:], crc32.ChecksumIEEE(chunk.Data))
+	copy(frame[i+12:], chunk.Data)
+	return frame
+}
+
+func DecodeChunk(frame []byte) (*Chunk, error) {
+	if len(frame) < chunk_header_size || len(frame) < chunk_header_size+int(frame[1]) {
+		return nil, errShortFrame
+	}
+
+	i := 2 + int(frame[1])
+	chunk := &Chunk{
+		ID:     string(frame[2:i]),
+		Offset: int64(binary.BigEndian.Uint64(frame[i:])),
+		Data:   frame[i+12:],
+	}
+	if crc32.ChecksumIEEE(chunk.Data) != binary.BigEndian.Uint32(frame[i+8:]) {
+		return nil, errBadCRC
+	}
+	return chunk, nil
+}
@@ -10,6 +10,7 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"unsafe"
 )
 
@@ -49,7 +50,7 @@ var (
 		0x0D /* VKEY_RETURN */ :           {C.KEY_ENTER, 0x70028},
 		0x10 /* VKEY_SHIFT */ :            {C.KEY_LEFTSHIFT, 0x700E1},
 		0x11 /* VKEY_CONTROL */ :          {C.KEY_LEFTCTRL, 0x700E0},
-		0x12 /* VKEY_MENU */ :             {C.KEY_LEFTALT, UNKNOWN},
+		0x12 /* VKEY_MENU */ :             {C.KEY_LEFTALT, 0x700E2},
 		0x13 /* VKEY_PAUSE */ :            {C.KEY_PAUSE, UNKNOWN},
 		0x14 /* VKEY_CAPITAL */ :          {C.KEY_CAPSLOCK, 0x70039},
 		0x15 /* VKEY_KANA */ :             {C.KEY_KATAKANAHIRAGANA, UNKNOWN},
@@ -127,37 +128,37 @@ var (
 		0x6D /* VKEY_SUBTRACT */ :         {C.KEY_KPMINUS, 0x70056},
 		0x6E /* VKEY_DECIMAL */ :          {C.KEY_KPDOT, 0x70063},
 		0x6F /* VKEY_DIVIDE */ :           {C.KEY_KPSLASH, 0x70054},
-		0x70 /* VKEY_F1 */ :               {C.KEY_F1, 0x70046},
-		0x71 /* VKEY_F2 */ :               {C.KEY_F2, 0x70047},
-		0x72 /* VKEY_F3 */ :               {C.KEY_F3, 0x70048},
-		0x73 /* VKEY_F4 */ :               {C.KEY_F4, 0x70049},
-		0x74 /* VKEY_F5 */ :               {C.KEY_F5, 0x7004a},
-		0x75 /* VKEY_F6 */ :               {C.KEY_F6, 0x7004b},
-		0x76 /* VKEY_F7 */ :               {C.KEY_F7, 0x7004c},
-		0x77 /* VKEY_F8 */ :               {C.KEY_F8, 0x7004d},
-		0x78 /* VKEY_F9 */ :               {C.KEY_F9, 0x7004e},
-		0x79 /* VKEY_F10 */ :              {C.KEY_F10, 0x70044},
+		0x70 /* VKEY_F1 */ :               {C.KEY_F1, 0x7003A},
+		0x71 /* VKEY_F2 */ :               {C.KEY_F2, 0x7003B},
+		0x72 /* VKEY_F3 */ :               {C.KEY_F3, 0x7003C},
+		0x73 /* VKEY_F4 */ :               {C.KEY_F4, 0x7003D},
+		0x74 /* VKEY_F5 */ :               {C.KEY_F5, 0x7003E},
+		0x75 /* VKEY_F6 */ :               {C.KEY_F6, 0x7003F},
+		0x76 /* VKEY_F7 */ :               {C.KEY_F7, 0x70040},
+		0x77 /* VKEY_F8 */ :               {C.KEY_F8, 0x70041},
+		0x78 /* VKEY_F9 */ :               {C.KEY_F9, 0x70042},
+		0x79 /* VKEY_F10 */ :              {C.KEY_F10, 0x70043},
 		0x7A /* VKEY_F11 */ :              {C.KEY_F11, 0x70044},
 		0x7B /* VKEY_F12 */ :              {C.KEY_F12, 0x70045},
-		0x7C /* VKEY_F13 */ :              {C.KEY_F13, 0x7003a},
-		0x7D /* VKEY_F14 */ :              {C.KEY_F14, 0x7003b},
-		0x7E /* VKEY_F15 */ :              {C.KEY_F15, 0x7003c},
-		0x7F /* VKEY_F16 */ :              {C.KEY_F16, 0x7003d},
-		0x80 /* VKEY_F17 */ :              {C.KEY_F17, 0x7003e},
-		0x81 /* VKEY_F18 */ :              {C.KEY_F18, 0x7003f},
-		0x82 /* VKEY_F19 */ :              {C.KEY_F19, 0x70040},
-		0x83 /* VKEY_F20 */ :              {C.KEY_F20, 0x70041},
-		0x84 /* VKEY_F21 */ :              {C.KEY_F21, 0x70042},
-		0x85 /* VKEY_F22 */ :              {C.KEY_F12, 0x70043},
-		0x86 /* VKEY_F23 */ :              {C.KEY_F23, 0x70044},
-		0x87 /* VKEY_F24 */ :              {C.KEY_F24, 0x70045},
+		0x7C /* VKEY_F13 */ :              {C.KEY_F13, 0x70068},
+		0x7D /* VKEY_F14 */ :              {C.KEY_F14, 0x70069},
+		0x7E /* VKEY_F15 */ :              {C.KEY_F15, 0x7006A},
+		0x7F /* VKEY_F16 */ :              {C.KEY_F16, 0x7006B},
+		0x80 /* VKEY_F17 */ :              {C.KEY_F17, 0x7006C},
+		0x81 /* VKEY_F18 */ :              {C.KEY_F18, 0x7006D},
+		0x82 /* VKEY_F19 */ :              {C.KEY_F19, 0x7006E},
+		0x83 /* VKEY_F20 */ :              {C.KEY_F20, 0x7006F},
+		0x84 /* VKEY_F21 */ :              {C.KEY_F21, 0x70070},
+		0x85 /* VKEY_F22 */ :              {C.KEY_F22, 0x70071},
+		0x86 /* VKEY_F23 */ :              {C.KEY_F23, 0x70072},
+		0x87 /* VKEY_F24 */ :              {C.KEY_F24, 0x70073},
 		0x90 /* VKEY_NUMLOCK */ :          {C.KEY_NUMLOCK, 0x70053},
 		0x91 /* VKEY_SCROLL */ :           {C.KEY_SCROLLLOCK, 0x70047},
 		0xA0 /* VKEY_LSHIFT */ :           {C.KEY_LEFTSHIFT, 0x700E1},
 		0xA1 /* VKEY_RSHIFT */ :           {C.KEY_RIGHTSHIFT, 0x700E5},
 		0xA2 /* VKEY_LCONTROL */ :         {C.KEY_LEFTCTRL, 0x700E0},
 		0xA3 /* VKEY_RCONTROL */ :         {C.KEY_RIGHTCTRL, 0x700E4},
-		0xA4 /* VKEY_LMENU */ :            {C.KEY_LEFTALT, 0x7002E},
+		0xA4 /* VKEY_LMENU */ :            {C.KEY_LEFTALT, 0x700E2},
 		0xA5 /* VKEY_RMENU */ :            {C.KEY_RIGHTALT, 0x700E6},
 		0xBA /* VKEY_OEM_1 */ :            {C.KEY_SEMICOLON, 0x70033},
 		0xBB /* VKEY_OEM_PLUS */ :         {C.KEY_EQUAL, 0x7002E},
@@ -172,6 +173,9 @@ var (
 		0xDE /* VKEY_OEM_7 */ :            {C.KEY_APOSTROPHE, 0x70034},
 		0xE2 /* VKEY_NON_US_BACKSLASH */ : {C.KEY_102ND, 0x70064},
 	}
+	// usages maps a USB HID usage back to its virtual key through the scancode column
+	usages = map[int]int{}
+
 	mouse_abs_input *C.struct_libevdev_uinput
 	mouse_rel_input *C.struct_libevdev_uinput
 	keyboard_input  *C.struct_libevdev_uinput
@@ -309,6 +313,12 @@ func _init() error {
 }
 
 func init() {
+	for vk, code := range keycodes {
+		if code.scancode != UNKNOWN {
+			usages[int(code.scancode)] = vk
+		}
+	}
+
 	if err := _init();err != nil {
 		fmt.Printf("failed to initialize hid for linux : %s\n", err.Error())
 	}
@@ -387,5 +397,43 @@ func SendKeyboard(keycode int, is_up bool, scan_code bool) {
 	C.libevdev_uinput_write_event(keyboard_input, C.EV_SYN, C.SYN_REPORT, 0)
 }
 
-func SetClipboard(text string) {
+func SendKeyboardUsage(usage int, is_up bool) {
+	if vk, ok := usages[normalizeUsage(usage)]; ok {
+		SendKeyboard(vk, is_up, false)
+	}
+}
+
+func tapKey(stroke keystroke, modifiers ...int) {
+	if stroke.shift {
+		modifiers = append(modifiers, LSHIFT)
+	}
+	for _, modifier := range modifiers {
+		SendKeyboard(modifier, false, false)
+	}
+	SendKeyboard(stroke.vk, false, false)
+	SendKeyboard(stroke.vk, true, false)
+	for _, modifier := range modifiers {
+		SendKeyboard(modifier, true, false)
+	}
+}
+
+// SendText types text through the virtual keyboard as if the host used a
+// US layout, runes missing from it need UnicodeSequence
+func SendText(text string) {
+	for _, r := range text {
+		if stroke, ok := usLayout(r); ok {
+			tapKey(stroke)
+			continue
+		} else if !UnicodeSequence {
+			fmt.Printf("dropped text input %q, unicode sequence is disabled\n", r)
+			continue
+		}
+
+		tapKey(keystroke{vk: KEY_U}, LCONTROL, LSHIFT)
+		for _, digit := range strconv.FormatInt(int64(r), 16) {
+			stroke, _ := usLayout(digit)
+			tapKey(stroke)
+		}
+		tapKey(keystroke{vk: SPACE})
+	}
 }
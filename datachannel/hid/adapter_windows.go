@@ -162,23 +162,67 @@ handle_keyboard_javascript(int opcode,
     }
 }
 
-
 void
-SetClipboard(char* output) {
-    const size_t len = strlen(output) + 1;
-    HGLOBAL hMem =  GlobalAlloc(GMEM_MOVEABLE, len);
-    memcpy(GlobalLock(hMem), output, len);
-    GlobalUnlock(hMem);
-    OpenClipboard(0);
-    EmptyClipboard();
-    SetClipboardData(CF_TEXT, hMem);
-    CloseClipboard();
+handle_unicode_javascript(unsigned short* units,
+                          int count)
+{
+    UINT send;
+    INPUT window_input[4] = {0};
+    if (count > 2)
+        count = 2;
+
+    // every unit goes down before any goes up, a surrogate pair
+    // split by a key up reaches applications as two broken halves
+    for (int i = 0; i < count; i++) {
+        window_input[i].type = INPUT_KEYBOARD;
+        window_input[i].ki.wScan = units[i];
+        window_input[i].ki.dwFlags = KEYEVENTF_UNICODE;
+        window_input[count + i] = window_input[i];
+        window_input[count + i].ki.dwFlags |= KEYEVENTF_KEYUP;
+    }
+
+    retry:
+    send = SendInput(count * 2, window_input, sizeof(INPUT));
+    if (send != count * 2) {
+        HDESK hDesk = syncThreadDesktop();
+        if (_lastKnownInputDesktop != hDesk) {
+            _lastKnownInputDesktop = hDesk;
+            goto retry;
+        }
+    }
 }
 
 */
 import "C"
 
+// usages maps USB HID keyboard page usages to virtual keys
+var usages = map[int]int{
+	0x28: RETURN, 0x29: ESCAPE, 0x2A: BACK, 0x2B: TAB, 0x2C: SPACE,
+	0x2D: OEM_MINUS, 0x2E: OEM_PLUS, 0x2F: OEM_4, 0x30: OEM_6, 0x31: OEM_5,
+	0x33: OEM_1, 0x34: OEM_7, 0x35: OEM_3, 0x36: OEM_COMMA, 0x37: OEM_PERIOD,
+	0x38: OEM_2, 0x39: CAPITAL, 0x46: SNAPSHOT, 0x47: SCROLL, 0x48: PAUSE,
+	0x49: INSERT, 0x4A: HOME, 0x4B: PRIOR, 0x4C: DELETE, 0x4D: END,
+	0x4E: NEXT, 0x4F: RIGHT, 0x50: LEFT, 0x51: DOWN, 0x52: UP,
+	0x53: NUMLOCK, 0x54: DIVIDE, 0x55: MULTIPLY, 0x56: SUBTRACT, 0x57: ADD,
+	0x58: RETURN, 0x62: NUMPAD0, 0x63: DECIMAL, 0x64: OEM_102, 0x65: APPS,
+	0xE0: LCONTROL, 0xE1: LSHIFT, 0xE2: LMENU, 0xE3: LWIN,
+	0xE4: RCONTROL, 0xE5: RSHIFT, 0xE6: RMENU, 0xE7: RWIN,
+}
+
 func init() {
+	for i := 0; i < 26; i++ {
+		usages[0x04+i] = KEY_A + i
+	}
+	for i := 0; i < 9; i++ {
+		usages[0x1E+i] = KEY_1 + i
+		usages[0x59+i] = NUMPAD1 + i
+	}
+	usages[0x27] = KEY_0
+	for i := 0; i < 12; i++ {
+		usages[0x3A+i] = F1 + i
+		usages[0x68+i] = F13 + i
+	}
+
 	C.syncThreadDesktop()
 }
 
@@ -250,6 +294,22 @@ func SendKeyboard(keycode int,
 	)
 }
 
-func SetClipboard(text string) {
-	C.SetClipboard(C.CString(text))
+func SendKeyboardUsage(usage int, is_up bool) {
+	if usage, ok := keyboardUsage(usage); !ok {
+		return
+	} else if vk, ok := usages[usage]; ok {
+		SendKeyboard(vk, is_up, false)
+	}
+}
+
+// SendText injects text as unicode keystrokes, independent of the host layout
+func SendText(text string) {
+	for _, r := range text {
+		units := [2]C.ushort{}
+		encoded := utf16Units(r)
+		for i, unit := range encoded {
+			units[i] = C.ushort(unit)
+		}
+		C.handle_unicode_javascript(&units[0], C.int(len(encoded)))
+	}
 }
@@ -5,14 +5,15 @@ package hid
 */
 import "C"
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	proxy "github.com/thinkonmay/thinkremote-rtchub"
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel/clipboard"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
@@ -23,14 +24,21 @@ const (
 
 type HIDAdapter struct {
 	send chan interface{}
-	recv chan string
+	recv chan datachannel.Message
+
+	controller *Xbox360Controller
+	cancel     context.CancelFunc
 }
 
-func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
+// NewHIDSingleton maps absolute mouse positions onto the selected display
+func NewHIDSingleton(displays *proxy.Displays, clip *clipboard.Clipboard) *HIDAdapter {
 	ret := HIDAdapter{
 		send: make(chan interface{}, queue_size),
-		recv: make(chan string, queue_size),
+		recv: make(chan datachannel.Message, queue_size),
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ret.cancel = cancel
+
 	em, err := NewEmulator()
 	if err != nil {
 		fmt.Printf("%s\n", err.Error())
@@ -46,15 +54,24 @@ func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
 		fmt.Printf("%s\n", err.Error())
 	}
 
+	ret.controller = controller
 	controller.emulator.onVibration = func(vibration Vibration) {
-		ret.send <- fmt.Sprintf("grum|%d|%d", int(vibration.LargeMotor), int(vibration.SmallMotor))
+		ret.send <- datachannel.Text(fmt.Sprintf("grum|%d|%d", int(vibration.LargeMotor), int(vibration.SmallMotor))).
+			Require(datachannel.CapGamepad)
 	}
 
-	offsetX, offsetY, width, height, envX, envY := 0, 0, 0, 0, 0, 0
-	thread.SafeLoop(make(chan bool), time.Second*5, func() {
-		_, width, height, offsetX, offsetY, envX, envY = queue.GetDisplay()
+	thread.SafeLoop(ctx, 0, func() {
+		select {
+		case <-ctx.Done():
+		case content := <-clip.Changes():
+			ret.send <- datachannel.Text(fmt.Sprintf("cu|%s|%s", base64.StdEncoding.EncodeToString(content.Data), content.Mime)).
+				Require(datachannel.CapClipboard)
+		}
 	})
+
+	// geometry is read per event so it follows display switches
 	convert_pos_win := func(a, b float64) (X, Y float32) {
+		_, width, height, offsetX, offsetY, envX, envY := displays.GetDisplay()
 		return (float32(offsetX) + (float32(width) * float32(a))) / float32(envX),
 			(float32(offsetY) + (float32(height) * float32(b))) / float32(envY)
 	}
@@ -63,8 +80,16 @@ func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
 			float32(b) * float32(1080)
 	}
 
-	thread.HighPriorityLoop(make(chan bool), func() {
-		msg := strings.Split(<-ret.recv, "|")
+	thread.HighPriorityLoop(ctx, func() {
+		var request datachannel.Message
+		var msg []string
+		select {
+		case <-ctx.Done():
+			return
+		case request = <-ret.recv:
+			msg = strings.Split(string(request.Data), "|")
+		}
+
 		switch msg[0] {
 		case "mma":
 			x, _ := strconv.ParseFloat(msg[1], 32)
@@ -97,6 +122,18 @@ func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
 		case "kds":
 			x, _ := strconv.ParseInt(msg[1], 10, 32)
 			SendKeyboard(int(x), false, true)
+		case "kuu":
+			x, _ := strconv.ParseInt(msg[1], 0, 32)
+			SendKeyboardUsage(int(x), true)
+		case "kdu":
+			x, _ := strconv.ParseInt(msg[1], 0, 32)
+			SendKeyboardUsage(int(x), false)
+		case "ti":
+			if decoded, err := base64.StdEncoding.DecodeString(msg[1]); err != nil {
+				fmt.Printf("invalid text input payload %s\n", err.Error())
+			} else {
+				SendText(string(decoded))
+			}
 		case "kr":
 			for i := 0; i < 0xFF; i++ {
 				SendKeyboard(i, true, false)
@@ -113,8 +150,14 @@ func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
 			y, _ := strconv.ParseInt(msg[2], 10, 32)
 			controller.pressButton(y, msg[3] == "1")
 		case "cs":
-			if decoded, err := base64.StdEncoding.DecodeString(msg[1]); err == nil {
-				SetClipboard(string(decoded))
+			mime := clipboard.MimeText
+			if len(msg) > 2 {
+				mime = msg[2]
+			}
+			if decoded, err := base64.StdEncoding.DecodeString(msg[1]); err != nil {
+				ret.send <- clipboardError(request, err)
+			} else if err := clip.Set(mime, decoded); err != nil {
+				ret.send <- clipboardError(request, err)
 			}
 		}
 	})
@@ -122,10 +165,101 @@ func NewHIDSingleton(queue *proxy.Queue) datachannel.DatachannelConsumer {
 	return &ret
 }
 
+// clipboardError is "ce|<reason>", telling the sender of a cs message
+// the host clipboard was left unchanged
+func clipboardError(request datachannel.Message, err error) datachannel.Message {
+	fmt.Printf("failed to set clipboard %s\n", err.Error())
+	return datachannel.Text("ce|" + strings.ReplaceAll(err.Error(), "|", " ")).
+		Require(datachannel.CapClipboard).
+		ReplyTo(request)
+}
+
+// CoalesceMouse merges queued mouse movement so a host that falls behind
+// applies the latest absolute position or the summed relative motion
+func CoalesceMouse(queued, incoming datachannel.Message) (datachannel.Message, bool) {
+	a := strings.Split(string(queued.Data), "|")
+	b := strings.Split(string(incoming.Data), "|")
+	if len(a) != 3 || len(b) != 3 || a[0] != b[0] || queued.From != incoming.From {
+		return incoming, false
+	}
+
+	switch a[0] {
+	case "mma":
+		return incoming, true
+	case "mmr":
+		ax, _ := strconv.ParseFloat(a[1], 32)
+		ay, _ := strconv.ParseFloat(a[2], 32)
+		bx, _ := strconv.ParseFloat(b[1], 32)
+		by, _ := strconv.ParseFloat(b[2], 32)
+		merged := datachannel.Text(fmt.Sprintf("mmr|%g|%g", ax+bx, ay+by))
+		merged.From = incoming.From
+		return merged, true
+	}
+
+	return incoming, false
+}
+
+// KeepInput keeps everything but mouse movement out of overflow, a lost
+// key or button release leaves it held down on the host
+func KeepInput(msg datachannel.Message) bool {
+	op, _, _ := strings.Cut(string(msg.Data), "|")
+	return op != "mma" && op != "mmr"
+}
+
 func (hid *HIDAdapter) Recv() chan interface{} {
 	return hid.send
 
 }
-func (hid *HIDAdapter) Send(msg string) {
+
+func (hid *HIDAdapter) Send(msg datachannel.Message) {
+	if capability := required(string(msg.Data)); !msg.From.Allows(capability) {
+		fmt.Printf("dropped hid message, session lacks %s\n", capability)
+		return
+	}
+	proxy.RecordInput(msg.From)
 	hid.recv <- msg
 }
+
+// required maps a hid message to the capability its sender needs
+func required(data string) datachannel.Capability {
+	op, _, _ := strings.Cut(data, "|")
+	switch op {
+	case "gs", "ga", "gb":
+		return datachannel.CapGamepad
+	case "cs":
+		return datachannel.CapClipboard
+	default:
+		return datachannel.CapInput
+	}
+}
+
+func (hid *HIDAdapter) Close() {
+	hid.cancel()
+	if hid.controller != nil {
+		hid.controller.Close()
+	}
+}
+
+// unreliableHID feeds the same input queue from a lossy channel,
+// stale mouse movement is better dropped than retransmitted
+type unreliableHID struct {
+	hid  *HIDAdapter
+	send chan interface{}
+}
+
+func (hid *HIDAdapter) Unreliable() datachannel.DatachannelConsumer {
+	return &unreliableHID{
+		hid:  hid,
+		send: make(chan interface{}),
+	}
+}
+
+func (hid *unreliableHID) Recv() chan interface{} {
+	return hid.send
+}
+func (hid *unreliableHID) Send(msg datachannel.Message) {
+	hid.hid.Send(msg)
+}
+
+// Close leaves the shared input queue to the reliable consumer
+func (hid *unreliableHID) Close() {}
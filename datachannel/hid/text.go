@@ -0,0 +1,85 @@
+package hid
+
+import (
+	"unicode"
+	"unicode/utf16"
+)
+
+// keystroke is a virtual key typed on a US layout, with shift held when needed
+type keystroke struct {
+	vk    int
+	shift bool
+}
+
+const keyboard_page = 0x70000
+
+var us_symbols = map[rune]keystroke{
+	' ':  {SPACE, false},
+	'\n': {RETURN, false},
+	'\t': {TAB, false},
+	'`':  {OEM_3, false}, '~': {OEM_3, true},
+	'-': {OEM_MINUS, false}, '_': {OEM_MINUS, true},
+	'=': {OEM_PLUS, false}, '+': {OEM_PLUS, true},
+	'[': {OEM_4, false}, '{': {OEM_4, true},
+	']': {OEM_6, false}, '}': {OEM_6, true},
+	'\\': {OEM_5, false}, '|': {OEM_5, true},
+	';': {OEM_1, false}, ':': {OEM_1, true},
+	'\'': {OEM_7, false}, '"': {OEM_7, true},
+	',': {OEM_COMMA, false}, '<': {OEM_COMMA, true},
+	'.': {OEM_PERIOD, false}, '>': {OEM_PERIOD, true},
+	'/': {OEM_2, false}, '?': {OEM_2, true},
+	'!': {KEY_1, true}, '@': {KEY_2, true}, '#': {KEY_3, true},
+	'$': {KEY_4, true}, '%': {KEY_5, true}, '^': {KEY_6, true},
+	'&': {KEY_7, true}, '*': {KEY_8, true}, '(': {KEY_9, true},
+	')': {KEY_0, true},
+}
+
+// usLayout resolves a rune that can be typed directly on a US layout,
+// anything else has to be entered as a unicode sequence
+func usLayout(r rune) (keystroke, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return keystroke{KEY_A + int(r-'a'), false}, true
+	case r >= 'A' && r <= 'Z':
+		return keystroke{KEY_A + int(r-'A'), true}, true
+	case r >= '0' && r <= '9':
+		return keystroke{KEY_0 + int(r-'0'), false}, true
+	}
+
+	stroke, found := us_symbols[r]
+	return stroke, found
+}
+
+// normalizeUsage accepts a keyboard page usage either bare (0x04)
+// or qualified with its page (0x70004)
+func normalizeUsage(usage int) int {
+	if usage < 0x10000 {
+		return keyboard_page | usage
+	}
+	return usage
+}
+
+// keyboardUsage strips the page from a keyboard page usage, usages of
+// other pages are rejected instead of aliasing onto keyboard keys
+func keyboardUsage(usage int) (int, bool) {
+	usage = normalizeUsage(usage)
+	return usage & 0xFFFF, usage&^0xFFFF == keyboard_page
+}
+
+// UnicodeSequence lets the linux host type runes missing from the US
+// layout as ctrl+shift+u followed by their hex code point. Only ibus and
+// gtk understand the sequence, anywhere else it types stray characters,
+// so those runes are dropped unless it is enabled
+var UnicodeSequence = false
+
+// utf16Units is the UTF-16 encoding of r, a surrogate pair outside the
+// basic multilingual plane, which windows injects down, down, up, up so
+// the pair reaches the focused window as a single character
+func utf16Units(r rune) []uint16 {
+	if high, low := utf16.EncodeRune(r); high != unicode.ReplacementChar {
+		return []uint16{uint16(high), uint16(low)}
+	} else if r < 0 || r > unicode.MaxRune || utf16.IsSurrogate(r) {
+		return []uint16{unicode.ReplacementChar}
+	}
+	return []uint16{uint16(r)}
+}
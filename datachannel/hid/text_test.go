@@ -0,0 +1,50 @@
+package hid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUSLayout(t *testing.T) {
+	for r, expected := range map[rune]keystroke{
+		'a': {KEY_A, false},
+		'Z': {KEY_Z, true},
+		'7': {KEY_7, false},
+		'&': {KEY_7, true},
+		'?': {OEM_2, true},
+	} {
+		stroke, ok := usLayout(r)
+		require.True(t, ok)
+		require.Equal(t, expected, stroke, string(r))
+	}
+
+	_, ok := usLayout('é')
+	require.False(t, ok)
+}
+
+func TestNormalizeUsage(t *testing.T) {
+	require.Equal(t, 0x70004, normalizeUsage(0x04))
+	require.Equal(t, 0x700E1, normalizeUsage(0x700E1))
+}
+
+func TestKeyboardUsage(t *testing.T) {
+	usage, ok := keyboardUsage(0x04)
+	require.True(t, ok)
+	require.Equal(t, 0x04, usage)
+	usage, ok = keyboardUsage(0x700E1)
+	require.True(t, ok)
+	require.Equal(t, 0xE1, usage)
+
+	// consumer page volume up must not alias keyboard usage 0xE9
+	_, ok = keyboardUsage(0xC00E9)
+	require.False(t, ok)
+}
+
+func TestUTF16Units(t *testing.T) {
+	require.Equal(t, []uint16{'a'}, utf16Units('a'))
+	require.Equal(t, []uint16{0xE9}, utf16Units('é'))
+	require.Equal(t, []uint16{0xD83D, 0xDE00}, utf16Units('😀'))
+	require.Equal(t, []uint16{0xFFFD}, utf16Units(0xD800))
+	require.Equal(t, []uint16{0xFFFD}, utf16Units(0x110000))
+}
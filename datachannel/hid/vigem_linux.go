@@ -8,6 +8,7 @@ typedef struct input_absinfo absinfo;
 */
 import "C"
 import (
+	"context"
 	"errors"
 	"fmt"
 	"unsafe"
@@ -75,11 +76,14 @@ type Emulator struct {
 }
 
 type Xbox360Controller struct {
+	emulator          *Emulator
 	gamepad_state_old *gamepad_state
 	channel           chan *gamepad_state
+	cancel            context.CancelFunc
 }
 
 func (c *Xbox360Controller) Close() error {
+	c.cancel()
 	return nil
 }
 
@@ -195,6 +199,7 @@ func (e *Emulator) Close() error {
 
 func (e *Emulator) CreateXbox360Controller() (*Xbox360Controller, error) {
 	ret := &Xbox360Controller{
+		emulator: e,
 		gamepad_state_old: &gamepad_state{
 			buttonStates: map[int]C.int{},
 		},
@@ -205,8 +210,14 @@ func (e *Emulator) CreateXbox360Controller() (*Xbox360Controller, error) {
 		ret.gamepad_state_old.buttonStates[i] = 0
 	}
 
-	thread.SafeLoop(make(chan bool), 0, func() {
-		ret.send(<-ret.channel)
+	var ctx context.Context
+	ctx, ret.cancel = context.WithCancel(context.Background())
+	thread.SafeLoop(ctx, 0, func() {
+		select {
+		case <-ctx.Done():
+		case state := <-ret.channel:
+			ret.send(state)
+		}
 	})
 	return ret, nil
 }
@@ -1,22 +1,28 @@
 package datachannel
 
 import (
-	"fmt"
+	"context"
 	"sync"
+	"sync/atomic"
 
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
 type Handler struct {
-	handler      func(string)
-	handle_queue chan interface{}
-	stop         chan bool
+	handler func(Message) error
+	queue   *queue
+	cancel  context.CancelFunc
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
 }
 
 type DatachannelGroup struct {
-	send chan interface{}
-	recv chan interface{}
-	stop chan bool
+	options Options
+
+	send      *queue
+	delivered atomic.Uint64
+	cancel    context.CancelFunc
 
 	mutext   *sync.Mutex
 	handlers map[string]*Handler
@@ -28,30 +34,18 @@ type Datachannel struct {
 	groups map[string]*DatachannelGroup
 }
 
-func NewDatachannel(names ...string) IDatachannel {
+func NewDatachannel(groups ...Group) IDatachannel {
 	dc := &Datachannel{
 		groups: map[string]*DatachannelGroup{},
 	}
 
-	for _, name := range names {
-		group := &DatachannelGroup{
-			send:     make(chan interface{}, queue_size),
-			recv:     make(chan interface{}, queue_size),
-			stop:     make(chan bool, 2),
+	for _, conf := range groups {
+		dc.groups[conf.Name] = &DatachannelGroup{
+			options:  conf.Options,
+			send:     newQueue(conf.Options),
 			handlers: map[string]*Handler{},
 			mutext:   &sync.Mutex{},
 		}
-
-		thread.SafeSelect(group.stop, group.recv, func(_msg interface{}) {
-			msg := _msg.(string)
-			group.mutext.Lock()
-			defer group.mutext.Unlock()
-			for _, handler := range group.handlers {
-				handler.handle_queue <- msg
-			}
-		})
-
-		dc.groups[name] = group
 	}
 
 	return dc
@@ -65,77 +59,154 @@ func (dc *Datachannel) Groups() []string {
 	return keys
 }
 
-func (dc *Datachannel) Send(group string, pkt string) {
+func (dc *Datachannel) Options(group string) Options {
 	if dc.groups[group] == nil {
-		return
-	} else if len(dc.groups[group].send) == queue_size {
-		return
+		return Options{}
 	}
 
-	dc.groups[group].send <- pkt
+	return dc.groups[group].options
+}
+
+func (dc *Datachannel) Stats(group_name string) (GroupStats, error) {
+	group, found := dc.groups[group_name]
+	if !found {
+		return GroupStats{}, ErrNoGroup
+	}
+
+	group.mutext.Lock()
+	defer group.mutext.Unlock()
+	stats := GroupStats{
+		Delivered: group.delivered.Load(),
+		Dropped:   group.send.dropped.Load(),
+		Handlers:  map[string]HandlerStats{},
+	}
+	for id, handler := range group.handlers {
+		stats.Handlers[id] = HandlerStats{
+			Delivered: handler.delivered.Load(),
+			Dropped:   handler.queue.dropped.Load(),
+			Failed:    handler.failed.Load(),
+		}
+	}
+
+	return stats, nil
+}
+
+// Send queues a message from a peer toward the group consumer
+func (dc *Datachannel) Send(group string, pkt Message) error {
+	if dc.groups[group] == nil {
+		return ErrNoGroup
+	}
+
+	return dc.groups[group].send.push(pkt)
 }
 
 func (dc *Datachannel) RegisterHandle(group_name string,
 	id string,
-	fun func(msg string)) {
+	fun func(msg Message) error) error {
 
-	if group, found := dc.groups[group_name]; !found {
-		fmt.Printf("no group name %s available\n", group_name)
-	} else {
-		handler := &Handler{
-			handler:      fun,
-			handle_queue: make(chan interface{}, queue_size),
-			stop:         make(chan bool, 2),
-		}
+	group, found := dc.groups[group_name]
+	if !found {
+		return ErrNoGroup
+	}
 
-		thread.SafeSelect(handler.stop, handler.handle_queue, func(_msg interface{}) {
-			handler.handler(_msg.(string))
-		})
+	group.mutext.Lock()
+	defer group.mutext.Unlock()
+	if _, found := group.handlers[id]; found {
+		return ErrHasHandler
+	}
 
-		group.mutext.Lock()
-		defer group.mutext.Unlock()
-		group.handlers[id] = handler
+	handler := &Handler{
+		handler: fun,
+		queue:   newQueue(group.options),
 	}
+
+	var ctx context.Context
+	ctx, handler.cancel = context.WithCancel(context.Background())
+	thread.SafeSelect(ctx, handler.queue.ready, func(interface{}) {
+		handler.queue.drain(func(msg Message) {
+			if err := handler.handler(msg); err != nil {
+				handler.failed.Add(1)
+			} else {
+				handler.delivered.Add(1)
+			}
+		})
+	})
+
+	group.handlers[id] = handler
+	return nil
 }
 
-func (dc *Datachannel) DeregisterHandle(group_name string, id string) {
+func (dc *Datachannel) DeregisterHandle(group_name string, id string) error {
 	group, found := dc.groups[group_name]
 	if !found {
-		fmt.Printf("no group name %s available\n", group_name)
-		return
+		return ErrNoGroup
 	}
 
 	group.mutext.Lock()
 	defer group.mutext.Unlock()
 	if handler, found := group.handlers[id]; !found {
-		fmt.Printf("no handler name %s available\n", id)
+		return ErrNoHandler
 	} else {
-		thread.TriggerStop(handler.stop)
+		handler.cancel()
 		delete(group.handlers, id)
 	}
+
+	return nil
 }
 
-func (dc *Datachannel) RegisterConsumer(group_name string, consumer DatachannelConsumer) {
-	if group, found := dc.groups[group_name]; !found {
-		fmt.Printf("no group name %s available\n", group_name)
-	} else if group.consumer != nil {
-		fmt.Printf("consumer for group %s available\n", group_name)
-	} else {
-		thread.SafeSelect(group.stop, consumer.Recv(), func(data interface{}) {
-			group.recv <- data
-		})
-		thread.SafeSelect(group.stop, group.send, func(data interface{}) {
-			consumer.Send(data.(string))
-		})
+// RegisterConsumer starts moving messages both ways, a handler that
+// falls behind only overflows its own queue and never stalls the others.
+// Replies carrying a Peer only reach the handle the request came through
+func (dc *Datachannel) RegisterConsumer(group_name string, consumer DatachannelConsumer) error {
+	group, found := dc.groups[group_name]
+	if !found {
+		return ErrNoGroup
+	}
 
-		group.consumer = consumer
+	group.mutext.Lock()
+	defer group.mutext.Unlock()
+	if group.consumer != nil {
+		return ErrConsumer
 	}
+
+	var ctx context.Context
+	ctx, group.cancel = context.WithCancel(context.Background())
+	thread.SafeSelect(ctx, consumer.Recv(), func(data interface{}) {
+		msg := data.(Message)
+		group.mutext.Lock()
+		defer group.mutext.Unlock()
+		if msg.Peer != "" {
+			if handler, found := group.handlers[msg.Peer]; found {
+				handler.queue.push(msg)
+			}
+			return
+		}
+		for _, handler := range group.handlers {
+			handler.queue.push(msg)
+		}
+	})
+	thread.SafeSelect(ctx, group.send.ready, func(interface{}) {
+		group.send.drain(func(msg Message) {
+			consumer.Send(msg)
+			group.delivered.Add(1)
+		})
+	})
+
+	group.consumer = consumer
+	return nil
 }
 
-func (dc *Datachannel) DeregisterConsumer(group_name string) {
-	if group, found := dc.groups[group_name]; !found {
-		fmt.Printf("no group name %s available\n", group_name)
-	} else {
-		thread.TriggerStop(group.stop)
+func (dc *Datachannel) DeregisterConsumer(group_name string) error {
+	group, found := dc.groups[group_name]
+	if !found {
+		return ErrNoGroup
+	}
+
+	group.mutext.Lock()
+	defer group.mutext.Unlock()
+	if group.consumer != nil {
+		group.cancel()
+		group.consumer = nil
 	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package datachannel
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow decides what a full queue does with the next message
+type Overflow int
+
+const (
+	// DropNewest discards the incoming message and reports ErrDropped
+	DropNewest Overflow = iota
+	// DropOldest discards the message waiting the longest
+	DropOldest
+	// Coalesce merges the incoming message into the last queued one with
+	// Options.Coalesce, falling back to DropNewest when they do not merge
+	Coalesce
+)
+
+var (
+	ErrNoGroup    = errors.New("no such datachannel group")
+	ErrNoHandler  = errors.New("no such datachannel handler")
+	ErrHasHandler = errors.New("datachannel handler already registered")
+	ErrConsumer   = errors.New("datachannel group already has a consumer")
+	ErrDropped    = errors.New("datachannel queue full, message dropped")
+)
+
+// keep_factor bounds a queue holding kept messages to this many times
+// its size, past it kept messages are dropped as well
+const keep_factor = 2
+
+// queue is a bounded message queue that never blocks the producer, only
+// messages not marked by keep count against its size
+type queue struct {
+	mut   *sync.Mutex
+	items []Message
+	size  int
+
+	overflow Overflow
+	coalesce func(queued, incoming Message) (Message, bool)
+	keep     func(Message) bool
+
+	ready   chan interface{}
+	dropped atomic.Uint64
+}
+
+func newQueue(opts Options) *queue {
+	size := opts.QueueSize
+	if size <= 0 {
+		size = queue_size
+	}
+
+	return &queue{
+		mut:      &sync.Mutex{},
+		items:    make([]Message, 0, size),
+		size:     size,
+		overflow: opts.Overflow,
+		coalesce: opts.Coalesce,
+		keep:     opts.Keep,
+		ready:    make(chan interface{}, 1),
+	}
+}
+
+func (q *queue) push(msg Message) error {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	full := len(q.items) >= q.size
+	if full && q.overflow == Coalesce && q.coalesce != nil {
+		if merged, ok := q.coalesce(q.items[len(q.items)-1], msg); ok {
+			q.items[len(q.items)-1] = merged
+			return nil
+		}
+	}
+
+	// kept messages grow a full queue up to keep_factor times its size
+	if full && !q.kept(msg) || len(q.items) >= q.size*keep_factor {
+		oldest := slices.IndexFunc(q.items, func(queued Message) bool { return !q.kept(queued) })
+		if q.overflow != DropOldest || (oldest < 0 && !q.kept(msg)) {
+			q.dropped.Add(1)
+			return ErrDropped
+		} else if oldest < 0 {
+			oldest = 0
+		}
+
+		q.dropped.Add(1)
+		q.items = slices.Delete(q.items, oldest, oldest+1)
+	}
+
+	q.items = append(q.items, msg)
+	select {
+	case q.ready <- true:
+	default:
+	}
+	return nil
+}
+
+func (q *queue) kept(msg Message) bool {
+	return q.keep != nil && q.keep(msg)
+}
+
+func (q *queue) pop() (Message, bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if len(q.items) == 0 {
+		return Message{}, false
+	}
+
+	msg := q.items[0]
+	copy(q.items, q.items[1:])
+	q.items = q.items[:len(q.items)-1]
+	return msg, true
+}
+
+// drain hands every queued message to fun, it runs whenever ready fires
+func (q *queue) drain(fun func(Message)) {
+	for msg, ok := q.pop(); ok; msg, ok = q.pop() {
+		fun(msg)
+	}
+}
@@ -0,0 +1,178 @@
+package datachannel
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread/leakcheck"
+)
+
+func drained(q *queue) []string {
+	out := []string{}
+	q.drain(func(msg Message) { out = append(out, string(msg.Data)) })
+	return out
+}
+
+func TestDropNewest(t *testing.T) {
+	q := newQueue(Options{QueueSize: 2})
+	require.Nil(t, q.push(Text("a")))
+	require.Nil(t, q.push(Text("b")))
+	require.ErrorIs(t, q.push(Text("c")), ErrDropped)
+	require.Equal(t, []string{"a", "b"}, drained(q))
+	require.Equal(t, uint64(1), q.dropped.Load())
+}
+
+func TestDropOldest(t *testing.T) {
+	q := newQueue(Options{QueueSize: 2, Overflow: DropOldest})
+	for _, msg := range []string{"a", "b", "c"} {
+		require.Nil(t, q.push(Text(msg)))
+	}
+	require.Equal(t, []string{"b", "c"}, drained(q))
+	require.Equal(t, uint64(1), q.dropped.Load())
+}
+
+func TestCoalesce(t *testing.T) {
+	sum := func(queued, incoming Message) (Message, bool) {
+		a, errA := strconv.Atoi(string(queued.Data))
+		b, errB := strconv.Atoi(string(incoming.Data))
+		if errA != nil || errB != nil {
+			return incoming, false
+		}
+		return Text(strconv.Itoa(a + b)), true
+	}
+
+	// nothing merges while the queue has room
+	q := newQueue(Options{QueueSize: 2, Overflow: Coalesce, Coalesce: sum})
+	require.Nil(t, q.push(Text("1")))
+	require.Nil(t, q.push(Text("2")))
+	require.Nil(t, q.push(Text("3")))
+	require.ErrorIs(t, q.push(Text("key")), ErrDropped)
+	require.Equal(t, []string{"1", "5"}, drained(q))
+}
+
+func TestKeep(t *testing.T) {
+	motion := func(msg Message) bool { return strings.HasPrefix(string(msg.Data), "mmr|") }
+	merge := func(queued, incoming Message) (Message, bool) {
+		return incoming, motion(queued) && motion(incoming)
+	}
+	keep := func(msg Message) bool { return !motion(msg) }
+
+	// a key released while the queue is full of motion still arrives
+	q := newQueue(Options{QueueSize: 2, Overflow: Coalesce, Coalesce: merge, Keep: keep})
+	require.Nil(t, q.push(Text("kd|4")))
+	require.Nil(t, q.push(Text("mmr|1|1")))
+	require.Nil(t, q.push(Text("mmr|2|2")))
+	require.Nil(t, q.push(Text("ku|4")))
+	require.ErrorIs(t, q.push(Text("mmr|3|3")), ErrDropped)
+	require.Equal(t, []string{"kd|4", "mmr|2|2", "ku|4"}, drained(q))
+
+	// dropping the oldest passes over kept messages
+	q = newQueue(Options{QueueSize: 2, Overflow: DropOldest, Keep: keep})
+	for _, msg := range []string{"kd|4", "mmr|1|1", "mmr|2|2", "ku|4"} {
+		require.Nil(t, q.push(Text(msg)))
+	}
+	require.Equal(t, []string{"kd|4", "mmr|2|2", "ku|4"}, drained(q))
+	require.Equal(t, uint64(1), q.dropped.Load())
+}
+
+func TestKeepBounded(t *testing.T) {
+	keep := func(Message) bool { return true }
+	q := newQueue(Options{QueueSize: 2, Keep: keep})
+	for i := range 4 {
+		require.Nil(t, q.push(Text(strconv.Itoa(i))))
+	}
+	require.ErrorIs(t, q.push(Text("4")), ErrDropped)
+	require.Equal(t, []string{"0", "1", "2", "3"}, drained(q))
+	require.Equal(t, uint64(1), q.dropped.Load())
+
+	q = newQueue(Options{QueueSize: 2, Overflow: DropOldest, Keep: keep})
+	for i := range 6 {
+		require.Nil(t, q.push(Text(strconv.Itoa(i))))
+	}
+	require.Equal(t, []string{"2", "3", "4", "5"}, drained(q))
+	require.Equal(t, uint64(2), q.dropped.Load())
+}
+
+func TestStuckHandler(t *testing.T) {
+	dc := NewDatachannel(Group{Name: "hid", Options: Options{QueueSize: 4}})
+	consumer := &echo{recv: make(chan interface{}, 16)}
+	require.Nil(t, dc.RegisterConsumer("hid", consumer))
+
+	stuck := make(chan bool)
+	received := make(chan Message, 16)
+	require.Nil(t, dc.RegisterHandle("hid", "stuck", func(Message) error { <-stuck; return nil }))
+	require.Nil(t, dc.RegisterHandle("hid", "live", func(msg Message) error { received <- msg; return nil }))
+	require.ErrorIs(t, dc.RegisterHandle("none", "live", nil), ErrNoGroup)
+
+	for i := 0; i < 10; i++ {
+		consumer.recv <- Text(strconv.Itoa(i))
+		select {
+		case msg := <-received:
+			require.Equal(t, strconv.Itoa(i), string(msg.Data))
+		case <-time.After(time.Second):
+			t.Fatal("live handler stalled behind stuck handler")
+		}
+	}
+
+	stats, err := dc.Stats("hid")
+	require.Nil(t, err)
+	require.Equal(t, uint64(10), stats.Handlers["live"].Delivered)
+	require.NotZero(t, stats.Handlers["stuck"].Dropped)
+	close(stuck)
+}
+
+func TestTeardown(t *testing.T) {
+	leakcheck.Check(t)
+	dc := NewDatachannel(Group{Name: "hid"}, Group{Name: "manual"})
+	for _, group := range dc.Groups() {
+		require.Nil(t, dc.RegisterConsumer(group, &echo{recv: make(chan interface{})}))
+		require.Nil(t, dc.RegisterHandle(group, "peer", func(Message) error { return nil }))
+	}
+
+	for _, group := range dc.Groups() {
+		require.Nil(t, dc.DeregisterHandle(group, "peer"))
+		require.Nil(t, dc.DeregisterConsumer(group))
+	}
+}
+
+type echo struct {
+	recv chan interface{}
+}
+
+func (e *echo) Send(Message)           {}
+func (e *echo) Recv() chan interface{} { return e.recv }
+func (e *echo) Close()                 {}
+
+func TestReplyTo(t *testing.T) {
+	dc := NewDatachannel(Group{Name: "file"})
+	consumer := &echo{recv: make(chan interface{}, 16)}
+	require.Nil(t, dc.RegisterConsumer("file", consumer))
+
+	received := map[string]chan Message{}
+	for _, id := range []string{"a", "b"} {
+		received[id] = make(chan Message, 16)
+		require.Nil(t, dc.RegisterHandle("file", id, func(msg Message) error { received[id] <- msg; return nil }))
+	}
+
+	request := Message{Data: []byte("list"), Peer: "b"}
+	consumer.recv <- Text("entries").ReplyTo(request)
+	consumer.recv <- Text("broadcast")
+	for _, expect := range []string{"entries", "broadcast"} {
+		select {
+		case msg := <-received["b"]:
+			require.Equal(t, expect, string(msg.Data))
+		case <-time.After(time.Second):
+			t.Fatal("requester did not receive the reply")
+		}
+	}
+	select {
+	case msg := <-received["a"]:
+		require.Equal(t, "broadcast", string(msg.Data))
+	case <-time.After(time.Second):
+		t.Fatal("broadcast did not reach every handle")
+	}
+	require.Empty(t, received["a"])
+}
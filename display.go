@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Displays selects the video queue that is streamed, the video pipeline,
+// absolute mouse mapping and encoder events follow the selection
+type Displays struct {
+	memory   *SharedMemory
+	selected atomic.Int32
+	// simulcast is set when Video1 is a lower quality encoding of the
+	// display in Video0 rather than a display of its own
+	simulcast bool
+}
+
+func NewDisplays(memory *SharedMemory, initial int) *Displays {
+	displays := &Displays{memory: memory}
+	displays.selected.Store(int32(initial))
+	return displays
+}
+
+// NewSimulcastDisplays streams Video0 with Video1 as its low encoding,
+// the selection cannot change. Sessions request keyframes of the low
+// encoding from its queue directly
+func NewSimulcastDisplays(memory *SharedMemory) *Displays {
+	displays := NewDisplays(memory, Video0)
+	displays.simulcast = true
+	return displays
+}
+
+func (displays *Displays) Index() int {
+	return int(displays.selected.Load())
+}
+
+func (displays *Displays) Current() *Queue {
+	return displays.memory.GetQueue(displays.Index())
+}
+
+// Active lists the video queues an encoder currently writes into, the
+// low encoding of simulcast is no display of its own
+func (displays *Displays) Active() []int {
+	ret := []int{}
+	for _, index := range []int{Video0, Video1} {
+		if displays.simulcast && index == Video1 {
+			break
+		} else if displays.memory.GetQueue(index).Active() {
+			ret = append(ret, index)
+		}
+	}
+	return ret
+}
+
+// Select streams the video queue index from its next keyframe on, which
+// is requested right away. The selection is shared by every session, so
+// viewers need the control capability to change it
+func (displays *Displays) Select(index int) error {
+	if displays.simulcast {
+		return fmt.Errorf("display switching is disabled with simulcast")
+	} else if index != Video0 && index != Video1 {
+		return fmt.Errorf("no display %d", index)
+	} else if queue := displays.memory.GetQueue(index); !queue.Active() {
+		return fmt.Errorf("display %d is not active", index)
+	} else if displays.selected.Swap(int32(index)) != int32(index) {
+		queue.Raise(Idr, 1)
+	}
+	return nil
+}
+
+// Raise forwards encoder events to the selected display
+func (displays *Displays) Raise(event_id, value int) {
+	displays.Current().Raise(event_id, value)
+}
+
+func (displays *Displays) GetDisplay() (name string, width, height, offsetX, offsetY, envX, envY int) {
+	return displays.Current().GetDisplay()
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelect(t *testing.T) {
+	memory := new(SharedMemory)
+	displays := NewDisplays(memory, Video0)
+	idr := func(index int) bool {
+		event := &memory.GetQueue(index).events[Idr]
+		raised := event.read == 0
+		event.read = 1
+		return raised
+	}
+	idr(Video0)
+	idr(Video1)
+
+	require.Error(t, displays.Select(Audio))
+	require.Error(t, displays.Select(-1))
+	require.Error(t, displays.Select(Video1), "queue 1 has no encoder")
+	require.Equal(t, Video0, displays.Index())
+	require.Equal(t, []int{}, displays.Active())
+
+	memory.GetQueue(Video0).metadata.active = 1
+	memory.GetQueue(Video1).metadata.active = 1
+	require.Equal(t, []int{Video0, Video1}, displays.Active())
+
+	// a keyframe is only requested when the selection changes
+	require.Nil(t, displays.Select(Video0))
+	require.False(t, idr(Video0))
+	require.Nil(t, displays.Select(Video1))
+	require.Equal(t, Video1, displays.Index())
+	require.True(t, idr(Video1))
+	require.Nil(t, displays.Select(Video1))
+	require.False(t, idr(Video1))
+	require.False(t, idr(Video0))
+
+	simulcast := NewSimulcastDisplays(memory)
+	require.Error(t, simulcast.Select(Video1))
+	require.Equal(t, []int{Video0}, simulcast.Active())
+}
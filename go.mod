@@ -5,12 +5,15 @@ go 1.22
 require (
 	github.com/ebitengine/purego v0.7.1
 	github.com/faiface/beep v1.1.0
+	github.com/pion/ice/v4 v4.0.1
 	github.com/pion/opus v0.0.0-20240105012622-483adc6e6efc
 	github.com/pion/rtcp v1.2.15
 	github.com/pion/rtp v1.8.10
+	github.com/pion/turn/v4 v4.0.0
 	github.com/pion/webrtc/v4 v4.0.0-beta.29.0.20240901035136-4ef00e6e5f78
 	github.com/stretchr/testify v1.10.0
 	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -19,12 +22,10 @@ require (
 	github.com/hajimehoshi/oto v0.7.1 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/pion/dtls/v3 v3.0.2 // indirect
-	github.com/pion/ice/v4 v4.0.1 // indirect
 	github.com/pion/mdns/v2 v2.0.7 // indirect
 	github.com/pion/srtp/v3 v3.0.3 // indirect
 	github.com/pion/stun/v3 v3.0.0 // indirect
 	github.com/pion/transport/v3 v3.0.7 // indirect
-	github.com/pion/turn/v4 v4.0.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/go-internal v1.13.1 // indirect
@@ -32,13 +33,12 @@ require (
 	golang.org/x/exp v0.0.0-20190306152737-a1d7652674e8 // indirect
 	golang.org/x/image v0.0.0-20190227222117-0694c2d4d067 // indirect
 	golang.org/x/mobile v0.0.0-20190415191353-3e0bab5405d6 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
 	github.com/google/uuid v1.6.0
 	github.com/pion/datachannel v1.5.10 // indirect
-	github.com/pion/interceptor v0.1.37 // indirect
+	github.com/pion/interceptor v0.1.37
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pion/randutil v0.1.0
 	github.com/pion/sctp v1.8.35 // indirect
@@ -1,47 +1,101 @@
 package audio
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/multiplexer"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/opus"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
 type AudioPipeline struct {
-	closed chan bool
+	ctx    context.Context
+	cancel context.CancelFunc
 	mut    *sync.Mutex
 
-	clockRate float64
+	clock   *clock.Clock
+	history *clock.History
 
-	codec       string
+	codec    string
+	channels int
+	// front sends the first stream of multistream packets, the front pair
+	// of encoders whose layout cannot be sent as multiopus
+	front       bool
 	Multiplexer *multiplexer.Multiplexer
 }
 
-func CreatePipeline(queue *proxy.Queue) (*AudioPipeline, error) {
+// CreatePipeline packetizes the Opus packets of queue, channels is what
+// the encoder writes or zero to read it from the queue. Surround audio is
+// sent as multiopus, other multistream layouts fall back to stereo
+func CreatePipeline(queue *proxy.Queue, channels int) (*AudioPipeline, error) {
+	if channels == 0 {
+		channels = queue.Channels()
+	}
+
+	front := false
+	if channels == 0 {
+		channels = 2
+	} else if _, found := opus.Layouts[channels]; !found && channels > 2 {
+		fmt.Printf("no opus layout for %d channels, sending the front pair as stereo\n", channels)
+		channels, front = 2, true
+	}
+
 	pipeline := &AudioPipeline{
-		closed:    make(chan bool, 2),
-		clockRate: 48000,
-		codec:     webrtc.MimeTypeOpus,
-		mut:       &sync.Mutex{},
+		front:    front,
+		clock:    clock.NewClock(48000),
+		history:  clock.NewHistory(),
+		codec:    webrtc.MimeTypeOpus,
+		channels: channels,
+		mut:      &sync.Mutex{},
 
 		Multiplexer: multiplexer.NewMultiplexer("audio", opus.NewOpusPayloader()),
 	}
 
+	if channels > 2 {
+		pipeline.codec = opus.MimeTypeMultiOpus
+	}
+
 	buffer := make([]byte, 256*1024) //256kB
 	local_index := queue.CurrentIndex()
-	thread.HighPriorityLoop(pipeline.closed, func() {
+	pipeline.ctx, pipeline.cancel = context.WithCancel(context.Background())
+	thread.HighPriorityLoop(pipeline.ctx, func() {
 		for local_index >= queue.CurrentIndex() {
+			if pipeline.ctx.Err() != nil {
+				return
+			}
 			time.Sleep(time.Millisecond)
 		}
 
 		local_index++
 		size, _ := queue.Copy(buffer, local_index)
-		pipeline.Multiplexer.Send(buffer[:size], uint32(pipeline.clockRate/100))
+		packet := buffer[:size]
+		if pipeline.front {
+			stream, ok := opus.FirstStream(packet)
+			if !ok {
+				return
+			}
+			packet = stream
+		}
+		captured, now := queue.Captured(local_index), time.Now()
+		timestamp := now
+		if !captured.IsZero() {
+			timestamp = captured
+		}
+
+		samples := opus.Samples(packet)
+		if samples == 0 {
+			samples = uint32(pipeline.clock.Rate() / 100)
+		}
+		info := clock.Frame{Timestamp: pipeline.clock.Contiguous(timestamp, samples), Captured: captured, Packetized: now}
+		pipeline.history.Add(info)
+		pipeline.Multiplexer.Send(packet, info.Timestamp)
 	})
 	return pipeline, nil
 }
@@ -50,8 +104,23 @@ func (p *AudioPipeline) GetCodec() string {
 	return p.codec
 }
 
+// Channels is the channel count of the track
+func (p *AudioPipeline) Channels() int {
+	return p.channels
+}
+
+// RTPTime maps wallclock t onto the RTP timestamps of the track
+func (p *AudioPipeline) RTPTime(t time.Time) (uint32, bool) {
+	return p.clock.RTPTime(t)
+}
+
+// Frame is what the host knows about the packet stamped with timestamp
+func (p *AudioPipeline) Frame(timestamp uint32) (clock.Frame, bool) {
+	return p.history.Get(timestamp)
+}
+
 func (p *AudioPipeline) Close() {
-	thread.TriggerStop(p.closed)
+	p.cancel()
 }
 
 func (p *AudioPipeline) RegisterRTPHandler(id string, fun func(pkt *rtp.Packet)) {
@@ -0,0 +1,121 @@
+package clock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/randutil"
+)
+
+const (
+	// tolerance is how far contiguous media may drift from its capture
+	// time before the timeline is anchored again
+	tolerance = 60 * time.Millisecond
+
+	// ntp_epoch is the offset between the NTP and unix epochs in seconds
+	ntp_epoch = 2208988800
+)
+
+// Clock maps capture times of one track onto its RTP timeline, which
+// starts at a random timestamp. Tracks whose clocks see the same capture
+// wallclock can be synchronised by the receiver through sender reports
+type Clock struct {
+	mut  *sync.Mutex
+	rate int64
+	base uint32
+
+	// origin is the capture time of base, zero until the first sample
+	origin time.Time
+	// next is the timestamp following the previous sample
+	next    uint32
+	started bool
+}
+
+func NewClock(rate int) *Clock {
+	return &Clock{
+		mut:  &sync.Mutex{},
+		rate: int64(rate),
+		base: randutil.NewMathRandomGenerator().Uint32(),
+	}
+}
+
+// Rate is the number of RTP ticks per second
+func (c *Clock) Rate() int {
+	return int(c.rate)
+}
+
+// at converts t to RTP time rounded to the nearest tick, origin has to
+// be set
+func (c *Clock) at(t time.Time) uint32 {
+	elapsed := t.Sub(c.origin)
+	ticks := int64(elapsed/time.Second)*c.rate +
+		(int64(elapsed%time.Second)*c.rate+int64(time.Second)/2)/int64(time.Second)
+	return c.base + uint32(ticks)
+}
+
+// Timestamp is the RTP timestamp of a frame captured at t. Timestamps
+// never go backwards, a frame captured out of order takes the tick after
+// the previous one
+func (c *Clock) Timestamp(t time.Time) uint32 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if !c.started {
+		c.origin, c.started = t, true
+		c.next = c.base + 1
+		return c.base
+	}
+
+	ts := c.at(t)
+	if int32(ts-c.next) < 0 {
+		ts = c.next
+	}
+	c.next = ts + 1
+	return ts
+}
+
+// Contiguous is the RTP timestamp of a packet of samples following the
+// previous one, as produced by an audio encoder. Capture time t is only
+// followed once the stream drifts beyond tolerance: ahead of it, after
+// the encoder skipped silence, the timeline jumps; behind it, the
+// timeline is anchored on the stream so timestamps stay contiguous
+func (c *Clock) Contiguous(t time.Time, samples uint32) uint32 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ts := c.base
+	if !c.started {
+		c.origin, c.started = t, true
+	} else {
+		ts = c.next
+		limit := int32(int64(tolerance) * c.rate / int64(time.Second))
+		switch drift := int32(c.at(t) - c.next); {
+		case drift > limit:
+			ts = c.at(t)
+		case drift < -limit:
+			elapsed := int64(ts - c.base)
+			c.origin = t.Add(-time.Duration(elapsed * int64(time.Second) / c.rate))
+		}
+	}
+	c.next = ts + samples
+	return ts
+}
+
+// RTPTime maps wallclock t onto the timeline for sender reports, ok is
+// false before the first sample
+func (c *Clock) RTPTime(t time.Time) (ts uint32, ok bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if !c.started {
+		return 0, false
+	}
+	return c.at(t), true
+}
+
+// NTP is t in the 64 bit NTP format of sender reports
+func NTP(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntp_epoch)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
@@ -0,0 +1,51 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestamp(t *testing.T) {
+	c := NewClock(90000)
+	start := time.Unix(1700000000, 0)
+
+	_, ok := c.RTPTime(start)
+	require.False(t, ok)
+
+	base := c.Timestamp(start)
+	require.Equal(t, base+1500, c.Timestamp(start.Add(time.Second/60)))
+	require.Equal(t, base+90000, c.Timestamp(start.Add(time.Second)))
+	// frames never go backwards
+	require.Equal(t, base+90001, c.Timestamp(start.Add(time.Second/2)))
+
+	now, ok := c.RTPTime(start.Add(2 * time.Second))
+	require.True(t, ok)
+	require.Equal(t, base+180000, now)
+}
+
+func TestContiguous(t *testing.T) {
+	c := NewClock(48000)
+	start := time.Unix(1700000000, 0)
+
+	base := c.Contiguous(start, 960)
+	// jitter in capture time does not leak into timestamps
+	require.Equal(t, base+960, c.Contiguous(start.Add(25*time.Millisecond), 960))
+	require.Equal(t, base+1920, c.Contiguous(start.Add(35*time.Millisecond), 960))
+
+	// the encoder skipped silence
+	require.Equal(t, base+48000, c.Contiguous(start.Add(time.Second), 960))
+
+	// the stream runs ahead of the capture clock, the report follows it
+	at := start.Add(time.Second)
+	for _, ts := range []uint32{48960, 49920, 50880, 51840} {
+		require.Equal(t, base+ts, c.Contiguous(at, 960))
+	}
+	now, _ := c.RTPTime(at)
+	require.Equal(t, base+51840, now)
+}
+
+func TestNTP(t *testing.T) {
+	require.Equal(t, uint64(2208988800)<<32|1<<31, NTP(time.Unix(0, int64(time.Second/2))))
+}
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// history_size covers the packets a session may lag behind
+	history_size = 256
+)
+
+// Frame is what the host knows about the frame stamped with Timestamp,
+// zero times are unknown
+type Frame struct {
+	Timestamp uint32
+	Captured  time.Time
+	// EncodeStart and EncodeFinish are reported by the encoder
+	EncodeStart, EncodeFinish time.Time
+	// Packetized is when the frame was handed to the packetizer
+	Packetized time.Time
+
+	// FrameNumber counts the frames of the stream, TemporalID is the
+	// temporal layer of the frame, zero without layers
+	FrameNumber uint16
+	TemporalID  int
+	Keyframe    bool
+}
+
+// History remembers the latest frames of a track by RTP timestamp
+type History struct {
+	mut    *sync.Mutex
+	frames [history_size]Frame
+	next   int
+}
+
+func NewHistory() *History {
+	return &History{mut: &sync.Mutex{}}
+}
+
+func (h *History) Add(frame Frame) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.frames[h.next%history_size] = frame
+	h.next++
+}
+
+// Get looks frame timestamp up from the latest one, ok is false once
+// it was forgotten
+func (h *History) Get(timestamp uint32) (frame Frame, ok bool) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for i := h.next - 1; i >= 0 && i >= h.next-history_size; i-- {
+		if frame = h.frames[i%history_size]; frame.Timestamp == timestamp {
+			return frame, true
+		}
+	}
+	return Frame{}, false
+}
@@ -1,7 +1,10 @@
 package listener
 
 import (
+	"time"
+
 	"github.com/pion/rtp"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
 )
 
 type Listener interface {
@@ -11,3 +14,30 @@ type Listener interface {
 
 	Close()
 }
+
+// Timeline is implemented by listeners whose RTP timestamps follow the
+// capture wallclock, it drives RTCP sender reports
+type Timeline interface {
+	RTPTime(time.Time) (uint32, bool)
+}
+
+// Frames is implemented by listeners remembering the capture and encode
+// times of their frames, they fill timing header extensions
+type Frames interface {
+	Frame(timestamp uint32) (clock.Frame, bool)
+}
+
+// Encoding is one encoding of a simulcast listener, named by its rid.
+// Keyframe asks the encoder of this encoding alone for a keyframe
+type Encoding struct {
+	RID      string
+	Listener Listener
+	Keyframe func()
+}
+
+// Simulcast is implemented by listeners publishing their source as
+// several encodings, ordered from the highest quality down. Sessions
+// forward the one fitting the viewer bandwidth
+type Simulcast interface {
+	Encodings() []Encoding
+}
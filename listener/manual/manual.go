@@ -1,54 +1,101 @@
 package manual
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	proxy "github.com/thinkonmay/thinkremote-rtchub"
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
 const (
 	queue_size = 32
+	hdr_period = time.Millisecond * 500
 )
 
 type Manual struct {
 	In  chan string
 	Out chan interface{}
+
+	cancel context.CancelFunc
 }
 
 type ManualPacket struct {
 	Type  string `json:"type"`
 	Value int    `json:"value"`
+	// HDR is the static metadata of the display, sent to the client with
+	// type hdr while value is 1
+	HDR *hdr.Metadata `json:"hdr,omitempty"`
 }
 
-func NewManualCtx(queue *proxy.Queue) datachannel.DatachannelConsumer {
+// NewManualCtx raises encoder events on the selected display, a display
+// packet switches the streamed display and an hdr packet asks the encoder
+// for HDR, the client is told whenever the HDR state changes
+func NewManualCtx(displays *proxy.Displays) datachannel.DatachannelConsumer {
 	ret := &Manual{
 		In:  make(chan string, queue_size),
 		Out: make(chan interface{}, queue_size),
 	}
 
+	var ctx context.Context
+	ctx, ret.cancel = context.WithCancel(context.Background())
+
 	dat := ManualPacket{}
-	thread.SafeLoop(make(chan bool), 0, func() {
-		if err := json.Unmarshal([]byte(<-ret.In), &dat); err != nil {
+	thread.SafeLoop(ctx, 0, func() {
+		var in string
+		select {
+		case <-ctx.Done():
+			return
+		case in = <-ret.In:
+		}
+
+		if err := json.Unmarshal([]byte(in), &dat); err != nil {
 			fmt.Printf("error unmarshal packet %s\n", err.Error())
 		} else {
 			switch dat.Type {
 			case "bitrate":
-				queue.Raise(proxy.Bitrate, dat.Value)
+				displays.Raise(proxy.Bitrate, dat.Value)
 			case "framerate":
-				queue.Raise(proxy.Framerate, dat.Value)
+				displays.Raise(proxy.Framerate, dat.Value)
 			case "pointer":
-				queue.Raise(proxy.Pointer, dat.Value)
+				displays.Raise(proxy.Pointer, dat.Value)
 			case "reset":
-				queue.Raise(proxy.Idr, dat.Value)
+				displays.Raise(proxy.Idr, dat.Value)
+			case "hdr":
+				displays.Raise(proxy.Hdr, dat.Value)
+			case "display":
+				if err := displays.Select(dat.Value); err != nil {
+					fmt.Printf("error switch display %s\n", err.Error())
+				}
 			case "danger-reset":
 			}
 		}
 
 	})
 
+	var last *hdr.Metadata
+	thread.SafeLoop(ctx, hdr_period, func() {
+		packet := ManualPacket{Type: "hdr"}
+		if metadata, ok := displays.Current().HDR(); ok {
+			packet.Value, packet.HDR = 1, &metadata
+		}
+		if (last == nil) == (packet.HDR == nil) && (last == nil || *last == *packet.HDR) {
+			return
+		}
+
+		last = packet.HDR
+		if data, err := json.Marshal(packet); err == nil {
+			select {
+			case ret.Out <- datachannel.Text(string(data)):
+			case <-ctx.Done():
+			}
+		}
+	})
+
 	return ret
 }
 
@@ -56,6 +103,27 @@ func NewManualCtx(queue *proxy.Queue) datachannel.DatachannelConsumer {
 func (manual *Manual) Recv() chan interface{} {
 	return manual.Out
 }
-func (manual *Manual) Send(msg string) {
-	manual.In <- msg
+func (manual *Manual) Send(msg datachannel.Message) {
+	packet := ManualPacket{}
+	if err := json.Unmarshal(msg.Data, &packet); err != nil {
+	} else if capability := required(packet.Type); !msg.From.Allows(capability) {
+		fmt.Printf("dropped manual %s, session lacks %s\n", packet.Type, capability)
+		return
+	}
+	manual.In <- string(msg.Data)
+}
+
+// required maps a manual packet to the capability its sender needs, the
+// settings every viewer shares need control
+func required(kind string) datachannel.Capability {
+	switch kind {
+	case "bitrate", "framerate", "display", "hdr", "danger-reset":
+		return datachannel.CapControl
+	default:
+		return ""
+	}
+}
+
+func (manual *Manual) Close() {
+	manual.cancel()
 }
@@ -0,0 +1,29 @@
+package manual
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+)
+
+func TestControl(t *testing.T) {
+	manual := &Manual{In: make(chan string, queue_size)}
+	send := func(from *datachannel.Permissions, data string) {
+		msg := datachannel.Text(data)
+		msg.From = from
+		manual.Send(msg)
+	}
+
+	// a view-only session may ask for keyframes but changes nothing shared
+	view := datachannel.NewPermissions()
+	for _, kind := range []string{"bitrate", "framerate", "display", "hdr", "danger-reset"} {
+		send(view, `{"type":"`+kind+`","value":1}`)
+	}
+	require.Empty(t, manual.In)
+	send(view, `{"type":"reset","value":1}`)
+	require.Equal(t, `{"type":"reset","value":1}`, <-manual.In)
+
+	send(datachannel.NewPermissions(datachannel.CapControl), `{"type":"bitrate","value":6000}`)
+	require.Equal(t, `{"type":"bitrate","value":6000}`, <-manual.In)
+}
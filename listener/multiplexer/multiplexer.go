@@ -1,6 +1,7 @@
 package multiplexer
 
 import (
+	"context"
 	"sync"
 
 	"github.com/pion/rtp"
@@ -13,9 +14,9 @@ const (
 )
 
 type sample struct {
-	data    []byte
-	samples uint32
-	id      int
+	data      []byte
+	timestamp uint32
+	id        int
 }
 type Multiplexer struct {
 	id string
@@ -30,7 +31,7 @@ type Multiplexer struct {
 type Handler struct {
 	handler func(*rtp.Packet)
 	buffer  chan *rtp.Packet
-	stop    chan bool
+	cancel  context.CancelFunc
 }
 
 func NewMultiplexer(id string, packetizer rtppay.Packetizer) *Multiplexer {
@@ -45,8 +46,9 @@ func NewMultiplexer(id string, packetizer rtppay.Packetizer) *Multiplexer {
 	return ret
 }
 
-func (ret *Multiplexer) Send(Buff []byte, Samples uint32) {
-	packets := ret.packetizer.Packetize(Buff, Samples)
+// Send packetizes a frame with RTP timestamp Timestamp for every handler
+func (ret *Multiplexer) Send(Buff []byte, Timestamp uint32) {
+	packets := ret.packetizer.Packetize(Buff, Timestamp)
 	ret.mutex.Lock()
 	defer ret.mutex.Unlock()
 	for _, handler := range ret.handler {
@@ -71,15 +73,15 @@ func (p *Multiplexer) RegisterRTPHandler(id string, fun func(pkt *rtp.Packet)) {
 	handler := &Handler{
 		handler: fun,
 		buffer:  make(chan *rtp.Packet, queue_size),
-		stop:    make(chan bool, 2),
 	}
 
-	thread.HighPriorityLoop(handler.stop, func() {
+	var ctx context.Context
+	ctx, handler.cancel = context.WithCancel(context.Background())
+	thread.HighPriorityLoop(ctx, func() {
 		select {
 		case buffer := <-handler.buffer:
 			handler.handler(buffer)
-		case <-handler.stop:
-			thread.TriggerStop(handler.stop)
+		case <-ctx.Done():
 		}
 	})
 
@@ -92,7 +94,7 @@ func (p *Multiplexer) DeregisterRTPHandler(id string) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	if handler, found := p.handler[id]; found {
-		thread.TriggerStop(handler.stop)
+		handler.cancel()
 		delete(p.handler, id)
 	}
 }
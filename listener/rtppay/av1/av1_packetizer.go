@@ -2,9 +2,7 @@ package av1
 
 import (
 	"errors"
-	"time"
 
-	"github.com/pion/randutil"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/pkg/obu"
 )
@@ -48,12 +46,7 @@ type AV1Payloader struct{
 	PayloadType      uint8
 	SSRC             uint32
 	Sequencer        rtp.Sequencer
-	Timestamp        uint32
 	ClockRate        uint32
-	extensionNumbers struct { // put extension numbers in here. If they're 0, the extension is disabled (0 is not a legal extension number)
-		AbsSendTime int // http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
-	}
-	timegen func() time.Time
 }
 
 
@@ -64,10 +57,7 @@ func NewAV1Payloader(mtu uint16, pt uint8, ssrc uint32, clockRate uint32) *AV1Pa
 		PayloadType:      0,
 		SSRC:             0,
 		Sequencer:   rtp.NewRandomSequencer(),
-		Timestamp:   randutil.NewMathRandomGenerator().Uint32(),
 		ClockRate:   clockRate,
-		extensionNumbers: struct{ AbsSendTime int }{AbsSendTime: 22},
-		timegen:     time.Now,
 	}
 }
 
@@ -209,7 +199,7 @@ func (p *AV1Packet) Unmarshal(payload []byte) ([]byte, error) {
 
 
 // Packetize packetizes the payload of an RTP packet and returns one or more RTP packets
-func (p *AV1Payloader) Packetize(payload []byte, samples uint32) []*rtp.Packet {
+func (p *AV1Payloader) Packetize(payload []byte, timestamp uint32) []*rtp.Packet {
 	payloads := p.payload(p.MTU-12, payload)
 	packets := make([]*rtp.Packet, len(payloads))
 
@@ -222,26 +212,12 @@ func (p *AV1Payloader) Packetize(payload []byte, samples uint32) []*rtp.Packet {
 				Marker:         i == len(payloads)-1,
 				PayloadType:    p.PayloadType,
 				SequenceNumber: p.Sequencer.NextSequenceNumber(),
-				Timestamp:      p.Timestamp, // Figure out how to do timestamps
+				Timestamp:      timestamp,
 				SSRC:           p.SSRC,
 			},
 			Payload: pp,
 		}
 	}
-	p.Timestamp += samples
-
-	if len(packets) != 0 && p.extensionNumbers.AbsSendTime != 0 {
-		sendTime := rtp.NewAbsSendTimeExtension(p.timegen())
-		// apply http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
-		b, err := sendTime.Marshal()
-		if err != nil {
-			return nil // never happens
-		}
-		err = packets[len(packets)-1].SetExtension(uint8(p.extensionNumbers.AbsSendTime), b)
-		if err != nil {
-			return nil // never happens
-		}
-	}
 
 	return packets
 }
\ No newline at end of file
@@ -0,0 +1,191 @@
+package av1
+
+import (
+	"slices"
+
+	"github.com/pion/rtp/pkg/obu"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/bits"
+)
+
+// DependencyDescriptorURI is the header extension describing how AV1
+// frames depend on each other
+const DependencyDescriptorURI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+const (
+	obuFrameHeader = 3
+	obuTileGroup   = 4
+	obuFrame       = 6
+
+	// MaxTemporalID is the highest layer of the template structure, up
+	// to three temporal layers of a single spatial layer
+	MaxTemporalID = 2
+
+	// decode target indications
+	dtiNotPresent  = 0
+	dtiDiscardable = 1
+	dtiSwitch      = 2
+)
+
+// TemporalID is the temporal layer of a temporal unit read from the
+// extension header of its first frame OBU, ok is false without one
+func TemporalID(tu []byte) (tid int, ok bool) {
+	for len(tu) > 0 {
+		header, kind := tu[0], tu[0]>>3&0x0F
+		extended, sized := header&0x04 != 0, header&0x02 != 0
+
+		i := 1
+		if extended {
+			if len(tu) < 2 {
+				return 0, false
+			} else if kind == obuFrame || kind == obuFrameHeader || kind == obuTileGroup {
+				return int(tu[1] >> 5), true
+			}
+			i++
+		}
+		if !sized {
+			return 0, false
+		}
+
+		size, read, err := obu.ReadLeb128(tu[i:])
+		if err != nil || uint(len(tu)) < uint(i)+read+size {
+			return 0, false
+		}
+		tu = tu[uint(i)+read+size:]
+	}
+	return 0, false
+}
+
+type template struct {
+	tid        int
+	dtis       []int
+	fdiffs     []uint16
+	chainFdiff uint16
+}
+
+// templates are the L1T3 structure, three decode targets each adding a
+// temporal layer and one chain protecting the base layer
+var templates = []template{
+	{tid: 0, dtis: []int{dtiSwitch, dtiSwitch, dtiSwitch}, fdiffs: nil, chainFdiff: 0},
+	{tid: 0, dtis: []int{dtiSwitch, dtiSwitch, dtiSwitch}, fdiffs: []uint16{4}, chainFdiff: 4},
+	{tid: 1, dtis: []int{dtiNotPresent, dtiDiscardable, dtiSwitch}, fdiffs: []uint16{2}, chainFdiff: 2},
+	{tid: 2, dtis: []int{dtiNotPresent, dtiNotPresent, dtiDiscardable}, fdiffs: []uint16{1}, chainFdiff: 1},
+	{tid: 2, dtis: []int{dtiNotPresent, dtiNotPresent, dtiDiscardable}, fdiffs: []uint16{1}, chainFdiff: 3},
+}
+
+// DependencyDescriptor is the dependency descriptor of one packet, frames
+// whose references do not match a template carry them explicitly
+type DependencyDescriptor struct {
+	StartOfFrame, EndOfFrame bool
+	FrameNumber              uint16
+	TemporalID               int
+	// Structure attaches the template structure, to the first packet of
+	// keyframes
+	Structure bool
+	// FrameDiffs are the frame number distances to the references, and
+	// ChainDiff the distance to the previous base layer frame, zero on
+	// keyframes
+	FrameDiffs []uint16
+	ChainDiff  uint16
+}
+
+func (d DependencyDescriptor) Marshal() []byte {
+	tid := min(d.TemporalID, MaxTemporalID)
+	index := -1
+	for i, t := range templates {
+		if t.tid != tid {
+			continue
+		} else if index < 0 {
+			index = i
+		}
+		if slices.Equal(t.fdiffs, d.FrameDiffs) && t.chainFdiff == d.ChainDiff {
+			index = i
+			break
+		}
+	}
+	customFdiffs := !slices.Equal(templates[index].fdiffs, d.FrameDiffs)
+	customChains := templates[index].chainFdiff != d.ChainDiff
+
+	w := bits.NewWriter(nil)
+	w.WriteBool(d.StartOfFrame)
+	w.WriteBool(d.EndOfFrame)
+	w.WriteBits(uint32(index), 6) // template_id_offset is zero
+	w.WriteBits(uint32(d.FrameNumber), 16)
+	if !d.Structure && !customFdiffs && !customChains {
+		return w.Bytes()
+	}
+
+	w.WriteBool(d.Structure)
+	w.WriteBool(false) // active_decode_targets_present_flag
+	w.WriteBool(false) // custom_dtis_flag
+	w.WriteBool(customFdiffs)
+	w.WriteBool(customChains)
+	if d.Structure {
+		writeStructure(w)
+	}
+
+	if customFdiffs {
+		for _, fdiff := range d.FrameDiffs {
+			size := 1
+			for ; size < 3 && uint32(fdiff-1) >= 1<<(4*size); size++ {
+			}
+			w.WriteBits(uint32(size), 2)
+			w.WriteBits(uint32(fdiff-1), byte(4*size))
+		}
+		w.WriteBits(0, 2)
+	}
+	if customChains {
+		w.WriteBits(uint32(min(int(d.ChainDiff), 0xFF)), 8)
+	}
+	return w.Bytes()
+}
+
+func writeStructure(w *bits.Writer) {
+	decodeTargets := len(templates[0].dtis)
+	w.WriteBits(0, 6) // template_id_offset
+	w.WriteBits(uint32(decodeTargets-1), 5)
+
+	for i, t := range templates {
+		switch {
+		case i == len(templates)-1:
+			w.WriteBits(3, 2) // no more templates
+		case templates[i+1].tid == t.tid:
+			w.WriteBits(0, 2) // same layer
+		default:
+			w.WriteBits(1, 2) // next temporal layer
+		}
+	}
+	for _, t := range templates {
+		for _, dti := range t.dtis {
+			w.WriteBits(uint32(dti), 2)
+		}
+	}
+	for _, t := range templates {
+		for _, fdiff := range t.fdiffs {
+			w.WriteBool(true)
+			w.WriteBits(uint32(fdiff-1), 4)
+		}
+		w.WriteBool(false)
+	}
+
+	// one chain protecting every decode target, the protected_by indices
+	// take no bits with a single chain
+	writeNonSymmetric(w, 1, uint32(decodeTargets+1))
+	for _, t := range templates {
+		w.WriteBits(uint32(t.chainFdiff), 4)
+	}
+	w.WriteBool(false) // resolutions_present_flag
+}
+
+// writeNonSymmetric writes v below n in the ns(n) encoding
+func writeNonSymmetric(w *bits.Writer, v, n uint32) {
+	width := byte(0)
+	for x := n; x != 0; x >>= 1 {
+		width++
+	}
+	m := uint32(1)<<width - n
+	if v < m {
+		w.WriteBits(v, width-1)
+	} else {
+		w.WriteBits(v+m, width)
+	}
+}
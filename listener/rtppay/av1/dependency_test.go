@@ -0,0 +1,75 @@
+package av1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/bits"
+)
+
+func TestTemporalID(t *testing.T) {
+	delimiter := []byte{0x12, 0x00}
+	frame := []byte{obuFrame<<3 | 0x06, 2 << 5, 1, 0xFF}
+	tid, ok := TemporalID(append(delimiter, frame...))
+	require.True(t, ok)
+	require.Equal(t, 2, tid)
+
+	_, ok = TemporalID(append(delimiter, obuFrame<<3|0x02, 1, 0xFF))
+	require.False(t, ok)
+	_, ok = TemporalID([]byte{0x12, 0x05})
+	require.False(t, ok)
+}
+
+func TestDependencyDescriptor(t *testing.T) {
+	require.Equal(t, []byte{0xC2, 0x12, 0x34}, DependencyDescriptor{
+		StartOfFrame: true, EndOfFrame: true, FrameNumber: 0x1234,
+		TemporalID: 1, FrameDiffs: []uint16{2}, ChainDiff: 2,
+	}.Marshal())
+
+	// references outside the templates are written per frame
+	require.Equal(t, []byte{0x03, 0x00, 0x01, 0x12, 0x10, 0x98}, DependencyDescriptor{
+		FrameNumber: 1, TemporalID: 2, FrameDiffs: []uint16{1, 20}, ChainDiff: 1,
+	}.Marshal())
+}
+
+func TestDependencyStructure(t *testing.T) {
+	raw := DependencyDescriptor{StartOfFrame: true, FrameNumber: 7, Structure: true}.Marshal()
+	r := bits.NewReader(raw)
+	require.Equal(t, uint32(0x80), r.ReadBits(8))
+	require.Equal(t, uint32(7), r.ReadBits(16))
+	require.Equal(t, uint32(0x10), r.ReadBits(5)) // structure only
+
+	require.Equal(t, uint32(0), r.ReadBits(6))
+	decodeTargets := int(r.ReadBits(5)) + 1
+	require.Equal(t, 3, decodeTargets)
+
+	tids := []int{0}
+	for idc := r.ReadBits(2); idc != 3; idc = r.ReadBits(2) {
+		next := tids[len(tids)-1]
+		if idc == 1 {
+			next++
+		}
+		tids = append(tids, next)
+	}
+	require.Len(t, tids, len(templates))
+
+	for i := range templates {
+		require.Equal(t, templates[i].tid, tids[i])
+		for dt := 0; dt < decodeTargets; dt++ {
+			require.Equal(t, uint32(templates[i].dtis[dt]), r.ReadBits(2))
+		}
+	}
+	for i := range templates {
+		fdiffs := []uint16{}
+		for r.ReadBit() == 1 {
+			fdiffs = append(fdiffs, uint16(r.ReadBits(4))+1)
+		}
+		require.Equal(t, len(templates[i].fdiffs), len(fdiffs))
+	}
+
+	require.Equal(t, uint32(1), r.ReadBits(2)) // ns(4) of one chain
+	for i := range templates {
+		require.Equal(t, uint32(templates[i].chainFdiff), r.ReadBits(4))
+	}
+	require.Equal(t, byte(0), r.ReadBit())
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/pion/rtp"
 )
 
+// Packetizer splits a frame into RTP packets stamped with timestamp
 type Packetizer interface {
-	Packetize(buff []byte, samples uint32) []*rtp.Packet
+	Packetize(buff []byte, timestamp uint32) []*rtp.Packet
 }
\ No newline at end of file
@@ -93,3 +93,17 @@ func TestDecodeSPS2(t *testing.T) {
 	sps := DecodeSPS(b)
 	assert.Nil(t, sps) // broken SPS?
 }
+
+func TestTemporalID(t *testing.T) {
+	prefix := []byte{0, 0, 0, 4, NALUTypePrefix | 0x60, 0x80, 0x00, 2 << 5}
+	slice := []byte{0, 0, 0, 2, NALUTypePFrame | 0x60, 0xFF}
+	tid, ok := TemporalID(append(prefix, slice...))
+	require.True(t, ok)
+	require.Equal(t, 2, tid)
+
+	_, ok = TemporalID(slice)
+	require.False(t, ok)
+
+	marking := FrameMarking{Start: true, Discardable: true, TemporalID: 2, TL0PICIDX: 9}
+	require.Equal(t, []byte{0x92, 0, 9}, marking.Marshal())
+}
@@ -0,0 +1,62 @@
+package h264
+
+import (
+	"encoding/binary"
+)
+
+// FrameMarkingURI is the header extension carrying the layer of a frame
+// for codecs without a layer aware payload format
+const FrameMarkingURI = "http://tools.ietf.org/html/draft-ietf-avtext-framemarking-07"
+
+const (
+	NALUTypePrefix         = 14 // Prefix NAL unit of SVC
+	NALUTypeSliceExtension = 20 // Coded slice extension of SVC
+)
+
+// TemporalID is the temporal layer of an access unit read from the SVC
+// header extension of its prefix or slice extension NAL units, ok is
+// false for plain AVC
+func TemporalID(au []byte) (tid int, ok bool) {
+	for len(au) > 4 {
+		size := int(binary.BigEndian.Uint32(au)) + 4
+		if size > len(au) {
+			return 0, false
+		}
+
+		switch NALUType(au) {
+		case NALUTypePrefix, NALUTypeSliceExtension:
+			// svc_extension_flag, then temporal_id in the third byte
+			if size >= 8 && au[5]&0x80 != 0 {
+				return int(au[7] >> 5), true
+			}
+		}
+		au = au[size:]
+	}
+	return 0, false
+}
+
+// FrameMarking is the scalable form of the frame marking extension
+type FrameMarking struct {
+	// Start and End delimit the frame
+	Start, End bool
+	// Independent frames decode alone, Discardable ones are referenced
+	// by no other frame
+	Independent, Discardable bool
+	// BaseSync frames only depend on the base layer
+	BaseSync bool
+
+	TemporalID uint8
+	LayerID    uint8
+	// TL0PICIDX counts base layer frames
+	TL0PICIDX uint8
+}
+
+func (f FrameMarking) Marshal() []byte {
+	b := f.TemporalID & 0x07
+	for i, set := range []bool{f.Start, f.End, f.Independent, f.Discardable, f.BaseSync} {
+		if set {
+			b |= 0x80 >> i
+		}
+	}
+	return []byte{b, f.LayerID, f.TL0PICIDX}
+}
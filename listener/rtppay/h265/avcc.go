@@ -6,9 +6,9 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 
-	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/core"
-	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay\h264"
 	"github.com/pion/rtp"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/core"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
 )
 
 func RepairAVCC(codec *core.Codec, handler core.HandlerFunc) core.HandlerFunc {
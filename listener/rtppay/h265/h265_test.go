@@ -2,9 +2,13 @@ package h265
 
 import (
 	"encoding/base64"
+	"encoding/binary"
+	mathbits "math/bits"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/bits"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
 )
 
 func TestDecodeSPS(t *testing.T) {
@@ -17,3 +21,124 @@ func TestDecodeSPS(t *testing.T) {
 	require.Equal(t, uint16(5120), sps.Width())
 	require.Equal(t, uint16(1440), sps.Height())
 }
+
+func writeUE(w *bits.Writer, v uint32) {
+	n := byte(mathbits.Len32(v + 1))
+	w.WriteBits(0, n-1)
+	w.WriteBits(v+1, n)
+}
+
+// hdrSPS is a main 10 SPS exercising scaling lists and predicted short
+// term reference sets ahead of a BT.2020 PQ VUI
+func hdrSPS() []byte {
+	w := bits.NewWriter(nil)
+	w.WriteBits8(0, 4) // sps_video_parameter_set_id
+	w.WriteBits8(0, 3) // sps_max_sub_layers_minus1
+	w.WriteBit(1)      // sps_temporal_id_nesting_flag
+	w.WriteBits8(0, 3) // profile space, tier
+	w.WriteBits8(2, 5) // general_profile_idc
+	w.WriteBits(0x20000000, 32)
+	w.WriteBits(0, 32)
+	w.WriteBits(0, 16)
+	w.WriteBits8(153, 8) // general_level_idc
+
+	for _, v := range []uint32{0, 1, 1920, 1080} {
+		writeUE(w, v)
+	}
+	w.WriteBit(0) // conformance_window_flag
+	for _, v := range []uint32{2, 2, 4} {
+		writeUE(w, v)
+	}
+	w.WriteBit(1) // sps_sub_layer_ordering_info_present_flag
+	for _, v := range []uint32{4, 0, 0, 0, 3, 0, 3, 0, 0} {
+		writeUE(w, v)
+	}
+
+	w.WriteBit(1) // scaling_list_enabled_flag
+	w.WriteBit(1) // sps_scaling_list_data_present_flag
+	w.WriteBit(1) // explicit 4x4 list
+	for i := 0; i < 16; i++ {
+		writeUE(w, 1) // se 1
+	}
+	for i := 1; i < 6+6+6+2; i++ {
+		w.WriteBit(0)
+		writeUE(w, 0)
+	}
+
+	w.WriteBit(1) // amp_enabled_flag
+	w.WriteBit(1) // sample_adaptive_offset_enabled_flag
+	w.WriteBit(0) // pcm_enabled_flag
+	writeUE(w, 2) // num_short_term_ref_pic_sets
+	writeUE(w, 1) // num_negative_pics
+	writeUE(w, 0) // num_positive_pics
+	writeUE(w, 0) // delta_poc_s0_minus1
+	w.WriteBit(1) // used_by_curr_pic_s0_flag
+	w.WriteBit(1) // inter_ref_pic_set_prediction_flag
+	w.WriteBit(0) // delta_rps_sign
+	writeUE(w, 0) // abs_delta_rps_minus1
+	w.WriteBit(1) // used_by_curr_pic_flag
+	w.WriteBit(0) // used_by_curr_pic_flag
+	w.WriteBit(0) // use_delta_flag
+
+	w.WriteBit(0) // long_term_ref_pics_present_flag
+	w.WriteBit(1) // sps_temporal_mvp_enabled_flag
+	w.WriteBit(1) // strong_intra_smoothing_enabled_flag
+	w.WriteBit(1) // vui_parameters_present_flag
+	w.WriteBit(0) // aspect_ratio_info_present_flag
+	w.WriteBit(0) // overscan_info_present_flag
+	w.WriteBit(1) // video_signal_type_present_flag
+	w.WriteBits8(5, 3)
+	w.WriteBit(0) // video_full_range_flag
+	w.WriteBit(1) // colour_description_present_flag
+	w.WriteBits8(9, 8)
+	w.WriteBits8(16, 8)
+	w.WriteBits8(9, 8)
+	w.WriteBit(1) // rbsp_stop_one_bit
+
+	return append([]byte{NALUTypeSPS << 1, 1}, escape(w.Bytes())...)
+}
+
+func TestDecodeSPSVUI(t *testing.T) {
+	sps := DecodeSPS(hdrSPS())
+	require.NotNil(t, sps)
+	require.Equal(t, uint16(1920), sps.Width())
+	require.Equal(t, 10, sps.BitDepth())
+	primaries, transfer, matrix, ok := sps.ColourDescription()
+	require.True(t, ok)
+	require.Equal(t, []uint8{9, 16, 9}, []uint8{primaries, transfer, matrix})
+}
+
+func lengthPrefixed(nalus ...[]byte) []byte {
+	out := []byte{}
+	for _, nalu := range nalus {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(nalu)))
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+func TestInjectHDR(t *testing.T) {
+	m := hdr.Metadata{
+		Primaries:    [3][2]uint16{{35400, 14600}, {8500, 39850}, {6550, 2300}},
+		WhitePoint:   [2]uint16{15635, 16450},
+		MaxLuminance: 1000,
+		MinLuminance: 50,
+		MaxCLL:       1000,
+		MaxFALL:      400,
+	}
+	sei := HDRSEI(m)
+	require.Equal(t, []int{SEIMasteringDisplay, SEIContentLightLevel}, SEITypes(sei))
+
+	idr := []byte{NALUTypeIFrame << 1, 1, 0xAF, 0x00}
+	au := lengthPrefixed(hdrSPS(), idr)
+	signalled, found := SignalsHDR(au)
+	require.True(t, found)
+	require.True(t, signalled)
+
+	injected := InjectHDR(au, m)
+	require.Equal(t, lengthPrefixed(hdrSPS(), sei, idr), injected)
+	require.Equal(t, injected, InjectHDR(injected, m))
+
+	delta := lengthPrefixed([]byte{NALUTypePFrame << 1, 1, 0xAF})
+	require.Equal(t, delta, InjectHDR(delta, m))
+}
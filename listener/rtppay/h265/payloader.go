@@ -2,7 +2,7 @@ package h265
 
 import (
 	"encoding/binary"
-	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay\h264"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
 	"math"
 )
 
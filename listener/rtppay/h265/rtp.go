@@ -3,9 +3,9 @@ package h265
 import (
 	"encoding/binary"
 
-	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/core"
-	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay\h264"
 	"github.com/pion/rtp"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/core"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
 )
 
 func RTPDepay(codec *core.Codec, handler core.HandlerFunc) core.HandlerFunc {
@@ -0,0 +1,142 @@
+package h265
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
+)
+
+const (
+	SEIMasteringDisplay  = 137
+	SEIContentLightLevel = 144
+)
+
+// HDRSEI is a prefix SEI NAL unit with the mastering display colour volume
+// and content light level of m, without length prefix
+func HDRSEI(m hdr.Metadata) []byte {
+	rbsp := []byte{SEIMasteringDisplay, 24}
+	// primaries are ordered green, blue, red
+	for _, xy := range [][2]uint16{m.Primaries[1], m.Primaries[2], m.Primaries[0], m.WhitePoint} {
+		rbsp = binary.BigEndian.AppendUint16(rbsp, xy[0])
+		rbsp = binary.BigEndian.AppendUint16(rbsp, xy[1])
+	}
+	rbsp = binary.BigEndian.AppendUint32(rbsp, uint32(m.MaxLuminance)*10000)
+	rbsp = binary.BigEndian.AppendUint32(rbsp, uint32(m.MinLuminance))
+
+	rbsp = append(rbsp, SEIContentLightLevel, 4)
+	rbsp = binary.BigEndian.AppendUint16(rbsp, m.MaxCLL)
+	rbsp = binary.BigEndian.AppendUint16(rbsp, m.MaxFALL)
+	rbsp = append(rbsp, 0x80) // rbsp_trailing_bits
+
+	return append([]byte{NALUTypePrefixSEI << 1, 1}, escape(rbsp)...)
+}
+
+// SEITypes lists the payload types of the messages in a SEI NAL unit
+func SEITypes(nalu []byte) []int {
+	if len(nalu) < 3 {
+		return nil
+	}
+
+	rbsp := bytes.ReplaceAll(nalu[2:], []byte{0, 0, 3}, []byte{0, 0})
+	types := []int{}
+	for i := 0; i < len(rbsp) && rbsp[i] != 0x80; {
+		kind, size := 0, 0
+		for ; i < len(rbsp) && rbsp[i] == 0xFF; i++ {
+			kind += 0xFF
+		}
+		if i >= len(rbsp) {
+			break
+		}
+		kind += int(rbsp[i])
+		for i++; i < len(rbsp) && rbsp[i] == 0xFF; i++ {
+			size += 0xFF
+		}
+		if i >= len(rbsp) {
+			break
+		}
+		size += int(rbsp[i])
+		types = append(types, kind)
+		i += 1 + size
+	}
+	return types
+}
+
+// InjectHDR prepends the HDR SEI of m to the first picture of keyframe
+// access units lacking a mastering display SEI, au is length prefixed
+func InjectHDR(au []byte, m hdr.Metadata) []byte {
+	if len(au) < 5 || !IsKeyframe(au) {
+		return au
+	}
+
+	vcl := -1
+	for i := 0; i+4 < len(au); {
+		size := int(binary.BigEndian.Uint32(au[i:]))
+		if i+4+size > len(au) {
+			return au
+		}
+
+		nalu := au[i+4 : i+4+size]
+		switch kind := NALUType(au[i:]); {
+		case kind == NALUTypePrefixSEI:
+			for _, payload := range SEITypes(nalu) {
+				if payload == SEIMasteringDisplay {
+					return au
+				}
+			}
+		case kind < NALUTypeVPS && vcl < 0:
+			vcl = i
+		}
+		i += 4 + size
+	}
+	if vcl < 0 {
+		return au
+	}
+
+	sei := HDRSEI(m)
+	out := make([]byte, 0, len(au)+4+len(sei))
+	out = append(out, au[:vcl]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(sei)))
+	out = append(out, sei...)
+	return append(out, au[vcl:]...)
+}
+
+// SignalsHDR reports whether the SPS of a keyframe access unit describes a
+// BT.2020 PQ or HLG stream, found is false without a parsable SPS
+func SignalsHDR(au []byte) (signalled, found bool) {
+	for i := 0; i+4 < len(au); {
+		size := int(binary.BigEndian.Uint32(au[i:]))
+		if i+4+size > len(au) {
+			return
+		} else if NALUType(au[i:]) == NALUTypeSPS {
+			sps := DecodeSPS(au[i+4 : i+4+size])
+			if sps == nil {
+				return
+			}
+			primaries, transfer, _, ok := sps.ColourDescription()
+			return ok && primaries == hdr.PrimariesBT2020 &&
+				(transfer == hdr.TransferPQ || transfer == hdr.TransferHLG), true
+		}
+		i += 4 + size
+	}
+	return
+}
+
+// escape inserts emulation prevention bytes
+func escape(rbsp []byte) []byte {
+	out := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
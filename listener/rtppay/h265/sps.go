@@ -29,6 +29,27 @@ type SPS struct {
 
 	pic_width_in_luma_samples  uint32
 	pic_height_in_luma_samples uint32
+
+	bit_depth_luma_minus8             uint32
+	log2_max_pic_order_cnt_lsb_minus4 uint32
+	vui_parameters_present_flag       byte
+	colour_description_present_flag   byte
+	colour_primaries                  uint8
+	transfer_characteristics          uint8
+	matrix_coeffs                     uint8
+	video_full_range_flag             byte
+}
+
+// BitDepth is the luma sample depth, 10 for HDR10
+func (s *SPS) BitDepth() int {
+	return int(s.bit_depth_luma_minus8) + 8
+}
+
+// ColourDescription reports the H.273 code points signalled in the VUI,
+// ok is false when the SPS carries none
+func (s *SPS) ColourDescription() (primaries, transfer, matrix uint8, ok bool) {
+	return s.colour_primaries, s.transfer_characteristics, s.matrix_coeffs,
+		s.colour_description_present_flag != 0
 }
 
 func (s *SPS) Width() uint16 {
@@ -62,17 +83,176 @@ func DecodeSPS(nalu []byte) *SPS {
 	s.pic_width_in_luma_samples = r.ReadUEGolomb()
 	s.pic_height_in_luma_samples = r.ReadUEGolomb()
 
-	//...
-
 	if r.EOF {
 		return nil
 	}
 
+	// the VUI is optional, an SPS whose tail can not be parsed keeps
+	// its size and profile
+	if !s.tail(r) || r.EOF {
+		s.vui_parameters_present_flag = 0
+		s.colour_description_present_flag = 0
+	}
+
 	return s
 }
 
-// profile_tier_level supports ONLY general_profile_idc == 1
-// over variants very complicated...
+//goland:noinspection GoSnakeCaseUsage
+func (s *SPS) tail(r *bits.Reader) bool {
+	if conformance_window_flag := r.ReadBit(); conformance_window_flag != 0 {
+		for i := 0; i < 4; i++ {
+			_ = r.ReadUEGolomb() // conf_win offsets
+		}
+	}
+
+	s.bit_depth_luma_minus8 = r.ReadUEGolomb()
+	_ = r.ReadUEGolomb() // bit_depth_chroma_minus8
+	s.log2_max_pic_order_cnt_lsb_minus4 = r.ReadUEGolomb()
+
+	sps_sub_layer_ordering_info_present_flag := r.ReadBit()
+	first := s.sps_max_sub_layers_minus1
+	if sps_sub_layer_ordering_info_present_flag != 0 {
+		first = 0
+	}
+	for i := first; i <= s.sps_max_sub_layers_minus1; i++ {
+		_ = r.ReadUEGolomb() // sps_max_dec_pic_buffering_minus1
+		_ = r.ReadUEGolomb() // sps_max_num_reorder_pics
+		_ = r.ReadUEGolomb() // sps_max_latency_increase_plus1
+	}
+
+	_ = r.ReadUEGolomb() // log2_min_luma_coding_block_size_minus3
+	_ = r.ReadUEGolomb() // log2_diff_max_min_luma_coding_block_size
+	_ = r.ReadUEGolomb() // log2_min_luma_transform_block_size_minus2
+	_ = r.ReadUEGolomb() // log2_diff_max_min_luma_transform_block_size
+	_ = r.ReadUEGolomb() // max_transform_hierarchy_depth_inter
+	_ = r.ReadUEGolomb() // max_transform_hierarchy_depth_intra
+
+	if scaling_list_enabled_flag := r.ReadBit(); scaling_list_enabled_flag != 0 {
+		if sps_scaling_list_data_present_flag := r.ReadBit(); sps_scaling_list_data_present_flag != 0 {
+			scaling_list_data(r)
+		}
+	}
+
+	_ = r.ReadBit() // amp_enabled_flag
+	_ = r.ReadBit() // sample_adaptive_offset_enabled_flag
+	if pcm_enabled_flag := r.ReadBit(); pcm_enabled_flag != 0 {
+		_ = r.ReadBits8(4)   // pcm_sample_bit_depth_luma_minus1
+		_ = r.ReadBits8(4)   // pcm_sample_bit_depth_chroma_minus1
+		_ = r.ReadUEGolomb() // log2_min_pcm_luma_coding_block_size_minus3
+		_ = r.ReadUEGolomb() // log2_diff_max_min_pcm_luma_coding_block_size
+		_ = r.ReadBit()      // pcm_loop_filter_disabled_flag
+	}
+
+	num_short_term_ref_pic_sets := r.ReadUEGolomb()
+	if num_short_term_ref_pic_sets > 64 {
+		return false
+	}
+	num_delta_pocs := make([]uint32, num_short_term_ref_pic_sets)
+	for i := uint32(0); i < num_short_term_ref_pic_sets; i++ {
+		num_delta_pocs[i] = st_ref_pic_set(r, i, num_delta_pocs)
+	}
+
+	if long_term_ref_pics_present_flag := r.ReadBit(); long_term_ref_pics_present_flag != 0 {
+		num_long_term_ref_pics_sps := r.ReadUEGolomb()
+		for i := uint32(0); i < num_long_term_ref_pics_sps && !r.EOF; i++ {
+			_ = r.ReadBits(byte(s.log2_max_pic_order_cnt_lsb_minus4 + 4)) // lt_ref_pic_poc_lsb_sps
+			_ = r.ReadBit()                                               // used_by_curr_pic_lt_sps_flag
+		}
+	}
+
+	_ = r.ReadBit() // sps_temporal_mvp_enabled_flag
+	_ = r.ReadBit() // strong_intra_smoothing_enabled_flag
+
+	if s.vui_parameters_present_flag = r.ReadBit(); s.vui_parameters_present_flag != 0 {
+		if aspect_ratio_info_present_flag := r.ReadBit(); aspect_ratio_info_present_flag != 0 {
+			if aspect_ratio_idc := r.ReadBits8(8); aspect_ratio_idc == 255 {
+				_ = r.ReadBits(16) // sar_width
+				_ = r.ReadBits(16) // sar_height
+			}
+		}
+		if overscan_info_present_flag := r.ReadBit(); overscan_info_present_flag != 0 {
+			_ = r.ReadBit() // overscan_appropriate_flag
+		}
+		if video_signal_type_present_flag := r.ReadBit(); video_signal_type_present_flag != 0 {
+			_ = r.ReadBits8(3) // video_format
+			s.video_full_range_flag = r.ReadBit()
+			if s.colour_description_present_flag = r.ReadBit(); s.colour_description_present_flag != 0 {
+				s.colour_primaries = r.ReadBits8(8)
+				s.transfer_characteristics = r.ReadBits8(8)
+				s.matrix_coeffs = r.ReadBits8(8)
+			}
+		}
+	}
+
+	return true
+}
+
+//goland:noinspection GoSnakeCaseUsage
+func scaling_list_data(r *bits.Reader) {
+	for sizeId := 0; sizeId < 4; sizeId++ {
+		step := 1
+		if sizeId == 3 {
+			step = 3
+		}
+		for matrixId := 0; matrixId < 6; matrixId += step {
+			if scaling_list_pred_mode_flag := r.ReadBit(); scaling_list_pred_mode_flag == 0 {
+				_ = r.ReadUEGolomb() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+
+			coefNum := min(64, 1<<(4+(sizeId<<1)))
+			if sizeId > 1 {
+				_ = r.ReadSEGolomb() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum; i++ {
+				_ = r.ReadSEGolomb() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+// st_ref_pic_set skips a short term reference picture set of the SPS and
+// returns its NumDeltaPocs, sets predicted from the previous one need it
+//
+//goland:noinspection GoSnakeCaseUsage
+func st_ref_pic_set(r *bits.Reader, idx uint32, num_delta_pocs []uint32) uint32 {
+	inter_ref_pic_set_prediction_flag := byte(0)
+	if idx != 0 {
+		inter_ref_pic_set_prediction_flag = r.ReadBit()
+	}
+
+	if inter_ref_pic_set_prediction_flag != 0 {
+		_ = r.ReadBit()      // delta_rps_sign
+		_ = r.ReadUEGolomb() // abs_delta_rps_minus1
+
+		count := uint32(0)
+		for j := uint32(0); j <= num_delta_pocs[idx-1] && !r.EOF; j++ {
+			use_delta_flag := byte(1)
+			if used_by_curr_pic_flag := r.ReadBit(); used_by_curr_pic_flag == 0 {
+				use_delta_flag = r.ReadBit()
+			}
+			if use_delta_flag != 0 {
+				count++
+			}
+		}
+		return count
+	}
+
+	num_negative_pics := r.ReadUEGolomb()
+	num_positive_pics := r.ReadUEGolomb()
+	if num_negative_pics > 16 || num_positive_pics > 16 {
+		r.EOF = true
+		return 0
+	}
+	for i := uint32(0); i < num_negative_pics+num_positive_pics; i++ {
+		_ = r.ReadUEGolomb() // delta_poc_minus1
+		_ = r.ReadBit()      // used_by_curr_pic_flag
+	}
+	return num_negative_pics + num_positive_pics
+}
+
+// profile_tier_level supports ONLY general_profile_idc 1 and 2, main
+// and main 10, over variants very complicated...
 //
 //goland:noinspection GoSnakeCaseUsage
 func (s *SPS) profile_tier_level(r *bits.Reader) bool {
@@ -83,7 +263,7 @@ func (s *SPS) profile_tier_level(r *bits.Reader) bool {
 	s.general_profile_compatibility_flags = r.ReadBits(32)
 	_ = r.ReadBits64(48) // other flags
 
-	if s.general_profile_idc != 1 {
+	if s.general_profile_idc != 1 && s.general_profile_idc != 2 {
 		return false
 	}
 
@@ -112,7 +292,7 @@ func (s *SPS) profile_tier_level(r *bits.Reader) bool {
 			_ = r.ReadBits(32)   // sub_layer_profile_compatibility_flag
 			_ = r.ReadBits64(48) // other flags
 
-			if sub_layer_profile_idc != 1 {
+			if sub_layer_profile_idc != 1 && sub_layer_profile_idc != 2 {
 				return false
 			}
 		}
@@ -0,0 +1,112 @@
+package opus
+
+// MimeTypeMultiOpus is the multichannel Opus of Chromium, one RTP packet
+// carries every stream of a multistream encoder
+const MimeTypeMultiOpus = "audio/multiopus"
+
+// Layout is how a surround encoder spreads channels over streams, the
+// coupled streams come first and the first one holds the front pair
+type Layout struct {
+	Streams, Coupled int
+	// Mapping is the decoded channel of each output channel in Vorbis
+	// order
+	Mapping []byte
+}
+
+// Layouts are the channel mapping family 1 layouts by channel count
+var Layouts = map[int]Layout{
+	// 5.1: front left, center, front right, rear left, rear right, LFE
+	6: {Streams: 4, Coupled: 2, Mapping: []byte{0, 4, 1, 2, 3, 5}},
+	// 7.1: front left, center, front right, side left, side right, rear
+	// left, rear right, LFE
+	8: {Streams: 5, Coupled: 3, Mapping: []byte{0, 6, 1, 2, 3, 4, 5, 7}},
+}
+
+// FirstStream is the first stream of a multistream packet as a packet of
+// its own, which is the front pair of a surround layout. Every stream but
+// the last carries the extra length of the self-delimiting framing in
+// RFC 6716 appendix B, it is stripped along with padding. ok is false for
+// malformed packets
+func FirstStream(packet []byte) (stream []byte, ok bool) {
+	if len(packet) == 0 {
+		return nil, false
+	}
+
+	i := 1
+	length := func() (n int, ok bool) {
+		if i < len(packet) && packet[i] < 252 {
+			n, i = int(packet[i]), i+1
+			return n, true
+		} else if i+1 < len(packet) {
+			n, i = int(packet[i])+4*int(packet[i+1]), i+2
+			return n, true
+		}
+		return 0, false
+	}
+
+	header, sizes, padding := []byte{packet[0]}, []int{}, 0
+	switch packet[0] & 0x3 {
+	case 0, 1:
+		n, ok := length()
+		if !ok {
+			return nil, false
+		}
+		sizes = append(sizes, n)
+		if packet[0]&0x3 == 1 {
+			sizes = append(sizes, n)
+		}
+	case 2:
+		n1, ok := length()
+		header = append(header, packet[1:i]...)
+		n2, found := length()
+		if !ok || !found {
+			return nil, false
+		}
+		sizes = append(sizes, n1, n2)
+	case 3:
+		if len(packet) < 2 || packet[1]&0x3F == 0 {
+			return nil, false
+		}
+		count, vbr, padded := int(packet[1]&0x3F), packet[1]&0x80 != 0, packet[1]&0x40 != 0
+		header, i = append(header, packet[1]&^0x40), 2
+		for padded {
+			if i >= len(packet) {
+				return nil, false
+			}
+			padded, padding, i = packet[i] == 255, padding+int(packet[i]), i+1
+			if padded {
+				padding--
+			}
+		}
+
+		if !vbr {
+			n, ok := length()
+			if !ok {
+				return nil, false
+			}
+			for range count {
+				sizes = append(sizes, n)
+			}
+			break
+		}
+		for frame := 0; frame < count; frame++ {
+			start := i
+			n, ok := length()
+			if !ok {
+				return nil, false
+			} else if frame < count-1 {
+				header = append(header, packet[start:i]...)
+			}
+			sizes = append(sizes, n)
+		}
+	}
+
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	if i+total+padding > len(packet) {
+		return nil, false
+	}
+	return append(header, packet[i:i+total]...), true
+}
@@ -0,0 +1,34 @@
+package opus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstStream(t *testing.T) {
+	long := bytes.Repeat([]byte{7}, 256)
+	for _, c := range []struct {
+		packet, stream []byte
+	}{
+		// code 0 followed by the last stream
+		{[]byte{0x78, 3, 1, 2, 3, 0x78, 9, 9}, []byte{0x78, 1, 2, 3}},
+		{append([]byte{0x78, 252, 1}, long...), append([]byte{0x78}, long...)},
+		{[]byte{0x79, 2, 1, 2, 3, 4, 0x78}, []byte{0x79, 1, 2, 3, 4}},
+		{[]byte{0x7A, 2, 1, 1, 2, 3, 0x78}, []byte{0x7A, 2, 1, 2, 3}},
+		// code 3 cbr, then vbr with padding
+		{[]byte{0x7B, 0x02, 2, 1, 2, 3, 4, 0x78}, []byte{0x7B, 0x02, 1, 2, 3, 4}},
+		{[]byte{0x7B, 0xC2, 2, 1, 2, 1, 2, 3, 0, 0, 0x78}, []byte{0x7B, 0x82, 1, 1, 2, 3}},
+	} {
+		stream, ok := FirstStream(c.packet)
+		require.True(t, ok)
+		require.Equal(t, c.stream, stream)
+		require.Equal(t, Duration(c.packet), Duration(stream))
+	}
+
+	for _, packet := range [][]byte{{}, {0x78, 5, 1}, {0x7B, 0}, {0x7B, 0xC2, 255}} {
+		_, ok := FirstStream(packet)
+		require.False(t, ok)
+	}
+}
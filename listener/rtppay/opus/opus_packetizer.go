@@ -1,9 +1,6 @@
 package opus
 
 import (
-	"time"
-
-	"github.com/pion/randutil"
 	"github.com/pion/rtp"
 )
 
@@ -22,11 +19,6 @@ type OPUSPayloader struct {
 	PayloadType      uint8
 	SSRC             uint32
 	Sequencer        rtp.Sequencer
-	Timestamp        uint32
-	extensionNumbers struct { // put extension numbers in here. If they're 0, the extension is disabled (0 is not a legal extension number)
-		AbsSendTime int // http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
-	}
-	timegen func() time.Time
 }
 
 func NewOpusPayloader() *OPUSPayloader {
@@ -35,9 +27,6 @@ func NewOpusPayloader() *OPUSPayloader {
 		PayloadType: 0,
 		SSRC: 0,
 		Sequencer: rtp.NewRandomSequencer(),
-		Timestamp: randutil.NewMathRandomGenerator().Uint32(),
-		extensionNumbers: struct{AbsSendTime int}{AbsSendTime: 22},
-		timegen: time.Now,
 	}
 }
 
@@ -54,7 +43,7 @@ func (p *OPUSPayloader) payload(mtu uint16, payload []byte) [][]byte {
 }
 
 // Packetize packetizes the payload of an RTP packet and returns one or more RTP packets
-func (p *OPUSPayloader) Packetize(payload []byte, samples uint32) []*rtp.Packet {
+func (p *OPUSPayloader) Packetize(payload []byte, timestamp uint32) []*rtp.Packet {
 	payloads := p.payload(p.MTU-12, payload)
 	packets := make([]*rtp.Packet, len(payloads))
 
@@ -67,26 +56,12 @@ func (p *OPUSPayloader) Packetize(payload []byte, samples uint32) []*rtp.Packet
 				Marker:         i == len(payloads)-1,
 				PayloadType:    p.PayloadType,
 				SequenceNumber: p.Sequencer.NextSequenceNumber(),
-				Timestamp:      p.Timestamp, // Figure out how to do timestamps
+				Timestamp:      timestamp,
 				SSRC:           p.SSRC,
 			},
 			Payload: pp,
 		}
 	}
-	p.Timestamp += samples
-
-	if len(packets) != 0 && p.extensionNumbers.AbsSendTime != 0 {
-		sendTime := rtp.NewAbsSendTimeExtension(p.timegen())
-		// apply http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time
-		b, err := sendTime.Marshal()
-		if err != nil {
-			return nil // never happens
-		}
-		err = packets[len(packets)-1].SetExtension(uint8(p.extensionNumbers.AbsSendTime), b)
-		if err != nil {
-			return nil // never happens
-		}
-	}
 
 	return packets
 }
\ No newline at end of file
@@ -0,0 +1,43 @@
+package opus
+
+import (
+	"time"
+)
+
+// frame_sizes are the frame durations of the TOC configurations in
+// RFC 6716 section 3.1, in units of 2.5ms
+var frame_sizes = [32]int{
+	4, 8, 16, 24, 4, 8, 16, 24, 4, 8, 16, 24, // SILK
+	4, 8, 4, 8, // Hybrid
+	1, 2, 4, 8, 1, 2, 4, 8, 1, 2, 4, 8, 1, 2, 4, 8, // CELT
+}
+
+// Duration is the audio length of an Opus packet read from its TOC byte,
+// zero when the packet is malformed
+func Duration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	frames := 1
+	switch packet[0] & 0x3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = int(packet[1] & 0x3F)
+	}
+
+	duration := time.Duration(frames*frame_sizes[packet[0]>>3]) * 2500 * time.Microsecond
+	if duration > 120*time.Millisecond {
+		return 0
+	}
+	return duration
+}
+
+// Samples is the length of an Opus packet in ticks of the 48kHz RTP clock
+func Samples(packet []byte) uint32 {
+	return uint32(Duration(packet) * 48000 / time.Second)
+}
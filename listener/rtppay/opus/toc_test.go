@@ -0,0 +1,26 @@
+package opus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration(t *testing.T) {
+	for _, c := range []struct {
+		packet   []byte
+		duration time.Duration
+	}{
+		{[]byte{}, 0},
+		{[]byte{31 << 3}, 20 * time.Millisecond},
+		{[]byte{16 << 3}, 2500 * time.Microsecond},
+		{[]byte{1<<3 | 1}, 40 * time.Millisecond},
+		{[]byte{3<<3 | 3, 2}, 120 * time.Millisecond},
+		{[]byte{3<<3 | 3, 3}, 0},
+		{[]byte{3}, 0},
+	} {
+		require.Equal(t, c.duration, Duration(c.packet))
+	}
+	require.Equal(t, uint32(960), Samples([]byte{31 << 3}))
+}
@@ -0,0 +1,161 @@
+package vp8
+
+import (
+	"errors"
+
+	"github.com/pion/randutil"
+	"github.com/pion/rtp"
+)
+
+const (
+	rtpOutboundMTU = 1200
+
+	// descriptor_size is the payload descriptor written by VP8Payloader,
+	// with the extension byte and a 15 bit picture id
+	descriptor_size = 4
+)
+
+var (
+	errShortPacket = errors.New("packet is not large enough")
+)
+
+// IsKeyframe reports whether frame starts a VP8 key frame, the inverse
+// key frame flag of the frame tag is cleared
+func IsKeyframe(frame []byte) bool {
+	return len(frame) > 0 && frame[0]&0x01 == 0
+}
+
+// VP8Payloader payloads VP8 frames as in RFC 7741
+type VP8Payloader struct {
+	MTU         uint16
+	PayloadType uint8
+	SSRC        uint32
+	Sequencer   rtp.Sequencer
+	PictureID   uint16
+}
+
+func NewVP8Payloader(mtu uint16) *VP8Payloader {
+	return &VP8Payloader{
+		MTU:       mtu,
+		Sequencer: rtp.NewRandomSequencer(),
+		PictureID: uint16(randutil.NewMathRandomGenerator().Intn(0x7FFF)),
+	}
+}
+
+// Packetize fragments a frame into packets stamped with timestamp, each
+// one starts with a payload descriptor carrying the picture id
+func (p *VP8Payloader) Packetize(frame []byte, timestamp uint32) []*rtp.Packet {
+	if len(frame) == 0 {
+		return nil
+	}
+	mtu := int(p.MTU)
+	if mtu == 0 {
+		mtu = rtpOutboundMTU
+	}
+	size := mtu - 12 - descriptor_size
+	if size <= 0 {
+		return nil
+	}
+
+	packets := []*rtp.Packet{}
+	for offset := 0; offset < len(frame); offset += size {
+		end := min(offset+size, len(frame))
+
+		payload := make([]byte, descriptor_size, descriptor_size+end-offset)
+		payload[0] = 0x80 // X
+		if offset == 0 {
+			payload[0] |= 0x10 // S, partition 0
+		}
+		payload[1] = 0x80 // I
+		payload[2] = 0x80 | byte(p.PictureID>>8)
+		payload[3] = byte(p.PictureID)
+		payload = append(payload, frame[offset:end]...)
+
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         end == len(frame),
+				PayloadType:    p.PayloadType,
+				SequenceNumber: p.Sequencer.NextSequenceNumber(),
+				Timestamp:      timestamp,
+				SSRC:           p.SSRC,
+			},
+			Payload: payload,
+		})
+	}
+	p.PictureID = (p.PictureID + 1) & 0x7FFF
+	return packets
+}
+
+// VP8Packet is the payload descriptor of a VP8 RTP packet
+type VP8Packet struct {
+	// S starts a partition, PID is its index
+	S   bool
+	PID uint8
+	// N marks frames no other frame references
+	N bool
+
+	PictureID uint16
+	TL0PICIDX uint8
+	TID       uint8
+	Y         bool
+	KEYIDX    uint8
+}
+
+// Unmarshal parses the payload descriptor and returns the frame data
+func (p *VP8Packet) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) < 1 {
+		return nil, errShortPacket
+	}
+
+	*p = VP8Packet{
+		N:   payload[0]&0x20 != 0,
+		S:   payload[0]&0x10 != 0,
+		PID: payload[0] & 0x07,
+	}
+	i := 1
+	if payload[0]&0x80 == 0 {
+		return payload[i:], nil
+	}
+
+	if len(payload) < i+1 {
+		return nil, errShortPacket
+	}
+	x := payload[i]
+	i++
+	if x&0x80 != 0 { // I
+		if len(payload) < i+1 {
+			return nil, errShortPacket
+		}
+		if payload[i]&0x80 != 0 {
+			if len(payload) < i+2 {
+				return nil, errShortPacket
+			}
+			p.PictureID = uint16(payload[i]&0x7F)<<8 | uint16(payload[i+1])
+			i += 2
+		} else {
+			p.PictureID = uint16(payload[i])
+			i++
+		}
+	}
+	if x&0x40 != 0 { // L
+		if len(payload) < i+1 {
+			return nil, errShortPacket
+		}
+		p.TL0PICIDX = payload[i]
+		i++
+	}
+	if x&0x30 != 0 { // T or K
+		if len(payload) < i+1 {
+			return nil, errShortPacket
+		}
+		p.TID = payload[i] >> 6
+		p.Y = payload[i]&0x20 != 0
+		p.KEYIDX = payload[i] & 0x1F
+		i++
+	}
+	if len(payload) <= i {
+		return nil, errShortPacket
+	}
+	return payload[i:], nil
+}
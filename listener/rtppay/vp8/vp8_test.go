@@ -0,0 +1,54 @@
+package vp8
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	p := NewVP8Payloader(1200)
+	p.PictureID = 0x7FFF
+	frame := make([]byte, 3000)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+
+	packets := p.Packetize(frame, 1234)
+	require.Len(t, packets, 3)
+
+	out := []byte{}
+	for i, pk := range packets {
+		require.Equal(t, uint32(1234), pk.Timestamp)
+		require.Equal(t, i == len(packets)-1, pk.Marker)
+		require.LessOrEqual(t, pk.MarshalSize(), 1200)
+
+		desc := VP8Packet{}
+		data, err := desc.Unmarshal(pk.Payload)
+		require.Nil(t, err)
+		require.Equal(t, i == 0, desc.S)
+		require.Equal(t, uint16(0x7FFF), desc.PictureID)
+		out = append(out, data...)
+	}
+	require.Equal(t, frame, out)
+
+	// picture ids wrap at 15 bits
+	desc := VP8Packet{}
+	_, err := desc.Unmarshal(p.Packetize([]byte{1}, 0)[0].Payload)
+	require.Nil(t, err)
+	require.Zero(t, desc.PictureID)
+}
+
+func TestUnmarshal(t *testing.T) {
+	desc := VP8Packet{}
+	data, err := desc.Unmarshal([]byte{0xB0, 0xF0, 0x05, 0x11, 0x6A, 0xFF})
+	require.Nil(t, err)
+	require.Equal(t, VP8Packet{S: true, N: true, PictureID: 5, TL0PICIDX: 0x11, TID: 1, Y: true, KEYIDX: 10}, desc)
+	require.Equal(t, []byte{0xFF}, data)
+
+	_, err = desc.Unmarshal([]byte{0x80, 0x80})
+	require.ErrorIs(t, err, errShortPacket)
+
+	require.True(t, IsKeyframe([]byte{0x10}))
+	require.False(t, IsKeyframe([]byte{0x11}))
+}
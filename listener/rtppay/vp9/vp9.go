@@ -0,0 +1,255 @@
+package vp9
+
+import (
+	"errors"
+
+	"github.com/pion/randutil"
+	"github.com/pion/rtp"
+)
+
+const (
+	rtpOutboundMTU = 1200
+
+	// descriptor_size is the largest payload descriptor written by
+	// VP9Payloader, flexible mode with a 15 bit picture id and one
+	// reference
+	descriptor_size = 4
+)
+
+var (
+	errShortPacket  = errors.New("packet is not large enough")
+	errTooManyPDiff = errors.New("too many reference indices")
+)
+
+// IsKeyframe reports whether frame, or the first frame of a superframe,
+// is a VP9 key frame, read from its uncompressed header
+func IsKeyframe(frame []byte) bool {
+	if len(frame) == 0 || frame[0]>>6 != 2 { // frame_marker
+		return false
+	}
+
+	bit := 2
+	profile := int(frame[0]>>5&1) | int(frame[0]>>4&1)<<1
+	if bit += 2; profile == 3 {
+		bit++ // reserved_zero
+	}
+	read := func() bool {
+		set := frame[bit/8]>>(7-bit%8)&1 != 0
+		bit++
+		return set
+	}
+	if read() { // show_existing_frame
+		return false
+	}
+	return !read() // frame_type
+}
+
+// VP9Payloader payloads VP9 frames as in RFC 9628 flexible mode, inter
+// frames reference the previous picture
+type VP9Payloader struct {
+	MTU         uint16
+	PayloadType uint8
+	SSRC        uint32
+	Sequencer   rtp.Sequencer
+	PictureID   uint16
+}
+
+func NewVP9Payloader(mtu uint16) *VP9Payloader {
+	return &VP9Payloader{
+		MTU:       mtu,
+		Sequencer: rtp.NewRandomSequencer(),
+		PictureID: uint16(randutil.NewMathRandomGenerator().Intn(0x7FFF)),
+	}
+}
+
+// Packetize fragments a frame into packets stamped with timestamp, each
+// one starts with a payload descriptor carrying the picture id
+func (p *VP9Payloader) Packetize(frame []byte, timestamp uint32) []*rtp.Packet {
+	if len(frame) == 0 {
+		return nil
+	}
+	mtu := int(p.MTU)
+	if mtu == 0 {
+		mtu = rtpOutboundMTU
+	}
+	size := mtu - 12 - descriptor_size
+	if size <= 0 {
+		return nil
+	}
+
+	predicted := !IsKeyframe(frame)
+	packets := []*rtp.Packet{}
+	for offset := 0; offset < len(frame); offset += size {
+		end := min(offset+size, len(frame))
+
+		payload := make([]byte, 3, descriptor_size+end-offset)
+		payload[0] = 0x90 // I, F
+		if predicted {
+			payload[0] |= 0x40 // P
+		}
+		if offset == 0 {
+			payload[0] |= 0x08 // B
+		}
+		if end == len(frame) {
+			payload[0] |= 0x04 // E
+		}
+		payload[1] = 0x80 | byte(p.PictureID>>8)
+		payload[2] = byte(p.PictureID)
+		if predicted {
+			payload = append(payload, 1<<1) // P_DIFF 1, N 0
+		}
+		payload = append(payload, frame[offset:end]...)
+
+		packets = append(packets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         end == len(frame),
+				PayloadType:    p.PayloadType,
+				SequenceNumber: p.Sequencer.NextSequenceNumber(),
+				Timestamp:      timestamp,
+				SSRC:           p.SSRC,
+			},
+			Payload: payload,
+		})
+	}
+	p.PictureID = (p.PictureID + 1) & 0x7FFF
+	return packets
+}
+
+// VP9Packet is the payload descriptor of a VP9 RTP packet
+type VP9Packet struct {
+	// P is set on inter frames, F selects flexible mode
+	P, F bool
+	// B and E begin and end a frame
+	B, E bool
+	// V carries the scalability structure, Z marks frames not used by
+	// upper spatial layers
+	V, Z bool
+
+	PictureID uint16
+	TID       uint8
+	U         bool
+	SID       uint8
+	D         bool
+	TL0PICIDX uint8
+	// PDiff are the picture id distances of the references in flexible
+	// mode
+	PDiff []uint8
+
+	// Width and Height of each spatial layer, when V is set
+	Width, Height []uint16
+}
+
+// Unmarshal parses the payload descriptor and returns the frame data
+func (p *VP9Packet) Unmarshal(payload []byte) ([]byte, error) {
+	if len(payload) < 1 {
+		return nil, errShortPacket
+	}
+
+	b := payload[0]
+	*p = VP9Packet{
+		P: b&0x40 != 0,
+		F: b&0x10 != 0,
+		B: b&0x08 != 0,
+		E: b&0x04 != 0,
+		V: b&0x02 != 0,
+		Z: b&0x01 != 0,
+	}
+	i := 1
+	need := func(n int) error {
+		if len(payload) < i+n {
+			return errShortPacket
+		}
+		return nil
+	}
+
+	if b&0x80 != 0 { // I
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		if payload[i]&0x80 != 0 {
+			if err := need(2); err != nil {
+				return nil, err
+			}
+			p.PictureID = uint16(payload[i]&0x7F)<<8 | uint16(payload[i+1])
+			i += 2
+		} else {
+			p.PictureID = uint16(payload[i])
+			i++
+		}
+	}
+
+	if b&0x20 != 0 { // L
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		p.TID = payload[i] >> 5
+		p.U = payload[i]&0x10 != 0
+		p.SID = payload[i] >> 1 & 0x07
+		p.D = payload[i]&0x01 != 0
+		i++
+		if !p.F {
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			p.TL0PICIDX = payload[i]
+			i++
+		}
+	}
+
+	if p.F && p.P {
+		for {
+			if err := need(1); err != nil {
+				return nil, err
+			} else if len(p.PDiff) == 3 {
+				return nil, errTooManyPDiff
+			}
+			p.PDiff = append(p.PDiff, payload[i]>>1)
+			i++
+			if payload[i-1]&0x01 == 0 {
+				break
+			}
+		}
+	}
+
+	if p.V {
+		if err := need(1); err != nil {
+			return nil, err
+		}
+		layers := int(payload[i]>>5) + 1
+		y, g := payload[i]&0x10 != 0, payload[i]&0x08 != 0
+		i++
+		if y {
+			if err := need(4 * layers); err != nil {
+				return nil, err
+			}
+			for layer := 0; layer < layers; layer++ {
+				p.Width = append(p.Width, uint16(payload[i])<<8|uint16(payload[i+1]))
+				p.Height = append(p.Height, uint16(payload[i+2])<<8|uint16(payload[i+3]))
+				i += 4
+			}
+		}
+		if g {
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			groups := int(payload[i])
+			i++
+			for group := 0; group < groups; group++ {
+				if err := need(1); err != nil {
+					return nil, err
+				}
+				refs := int(payload[i] >> 2 & 0x03)
+				if err := need(1 + refs); err != nil {
+					return nil, err
+				}
+				i += 1 + refs
+			}
+		}
+	}
+
+	if len(payload) <= i {
+		return nil, errShortPacket
+	}
+	return payload[i:], nil
+}
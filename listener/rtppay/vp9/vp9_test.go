@@ -0,0 +1,71 @@
+package vp9
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	p := NewVP9Payloader(1200)
+	p.PictureID = 42
+
+	for n, frame := range [][]byte{make([]byte, 3000), make([]byte, 100)} {
+		key := n == 0
+		if key {
+			frame[0] = 0x80
+		} else {
+			frame[0] = 0x84
+		}
+		for i := 1; i < len(frame); i++ {
+			frame[i] = byte(i)
+		}
+
+		out := []byte{}
+		packets := p.Packetize(frame, 90000)
+		for i, pk := range packets {
+			require.Equal(t, i == len(packets)-1, pk.Marker)
+			require.LessOrEqual(t, pk.MarshalSize(), 1200)
+
+			desc := VP9Packet{}
+			data, err := desc.Unmarshal(pk.Payload)
+			require.Nil(t, err)
+			require.True(t, desc.F)
+			require.Equal(t, i == 0, desc.B)
+			require.Equal(t, i == len(packets)-1, desc.E)
+			require.Equal(t, !key, desc.P)
+			if key {
+				require.Empty(t, desc.PDiff)
+				require.Equal(t, uint16(42), desc.PictureID)
+			} else {
+				require.Equal(t, []uint8{1}, desc.PDiff)
+				require.Equal(t, uint16(43), desc.PictureID)
+			}
+			out = append(out, data...)
+		}
+		require.Equal(t, frame, out)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	// non-flexible with layer indices and a scalability structure
+	desc := VP9Packet{}
+	data, err := desc.Unmarshal([]byte{0xAE, 0x05, 0x43, 0x07, 0x18, 0x05, 0x00, 0x02, 0xD0, 0x01, 0x04, 0x01, 0xFF})
+	require.Nil(t, err)
+	require.Equal(t, uint16(5), desc.PictureID)
+	require.Equal(t, uint8(2), desc.TID)
+	require.Equal(t, uint8(1), desc.SID)
+	require.True(t, desc.D)
+	require.Equal(t, uint8(7), desc.TL0PICIDX)
+	require.Equal(t, []uint16{1280}, desc.Width)
+	require.Equal(t, []uint16{720}, desc.Height)
+	require.Equal(t, []byte{0xFF}, data)
+
+	_, err = desc.Unmarshal([]byte{0x50, 0x03, 0x03, 0x03, 0x03, 0xFF})
+	require.ErrorIs(t, err, errTooManyPDiff)
+
+	require.True(t, IsKeyframe([]byte{0x80}))
+	require.False(t, IsKeyframe([]byte{0x84}))
+	require.False(t, IsKeyframe([]byte{0x88})) // show_existing_frame
+	require.True(t, IsKeyframe([]byte{0xB0}))  // profile 3
+}
@@ -6,19 +6,19 @@ import (
 )
 
 type PacketizerWrapper struct {
-	Fun       func(uint16, core.HandlerFunc) core.HandlerFunc
-	Timestamp uint32
-	MTU       uint16
+	Fun func(uint16, core.HandlerFunc) core.HandlerFunc
+	MTU uint16
 }
 
-func (wr *PacketizerWrapper) Packetize(buff []byte, samples uint32) []*rtp.Packet {
+func (wr *PacketizerWrapper) Packetize(buff []byte, timestamp uint32) []*rtp.Packet {
 	result := []*rtp.Packet{}
 	final := func(packet *core.Packet) {
 		result = append(result, packet)
 	}
 
-	wr.Timestamp += samples
+	// the payloaders copy the header of the access unit onto every packet
 	wr.Fun(wr.MTU, final)(&core.Packet{
+		Header:  rtp.Header{Timestamp: timestamp},
 		Payload: buff,
 	})
 
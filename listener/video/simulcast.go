@@ -0,0 +1,93 @@
+package video
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+	proxy "github.com/thinkonmay/thinkremote-rtchub"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+)
+
+// rids name the simulcast encodings, the high one is encoded into Video0
+// and the low one into Video1
+var rids = []struct {
+	rid   string
+	queue int
+}{
+	{"h", proxy.Video0},
+	{"l", proxy.Video1},
+}
+
+// fixed is a source that never switches queue
+type fixed struct {
+	queue *proxy.Queue
+	index int
+}
+
+func (f fixed) Current() *proxy.Queue { return f.queue }
+func (f fixed) Index() int            { return f.index }
+
+// SimulcastPipeline packetizes the encodings of one display, sessions
+// pick the one they forward. Listeners unaware of simulcast get the high
+// encoding
+type SimulcastPipeline struct {
+	codec     string
+	encodings []listener.Encoding
+	high      *VideoPipeline
+}
+
+func CreateSimulcastPipeline(memory *proxy.SharedMemory, codec string) (listener.Listener,
+	error) {
+	p := &SimulcastPipeline{codec: codec}
+	for _, encoding := range rids {
+		queue := memory.GetQueue(encoding.queue)
+		pipeline, err := create(fixed{queue, encoding.queue}, codec)
+		if err != nil {
+			p.Close()
+			return nil, err
+		} else if p.high == nil {
+			p.high = pipeline
+		}
+		p.encodings = append(p.encodings, listener.Encoding{
+			RID:      encoding.rid,
+			Listener: pipeline,
+			Keyframe: func() { queue.Raise(proxy.Idr, 1) },
+		})
+	}
+	return p, nil
+}
+
+func (p *SimulcastPipeline) Encodings() []listener.Encoding {
+	return p.encodings
+}
+
+func (p *SimulcastPipeline) ColorSpace() []byte {
+	return p.high.ColorSpace()
+}
+
+func (p *SimulcastPipeline) RTPTime(t time.Time) (uint32, bool) {
+	return p.high.RTPTime(t)
+}
+
+func (p *SimulcastPipeline) Frame(timestamp uint32) (clock.Frame, bool) {
+	return p.high.Frame(timestamp)
+}
+
+func (p *SimulcastPipeline) GetCodec() string {
+	return p.codec
+}
+
+func (p *SimulcastPipeline) Close() {
+	for _, encoding := range p.encodings {
+		encoding.Listener.Close()
+	}
+}
+
+func (p *SimulcastPipeline) RegisterRTPHandler(id string, fun func(pkt *rtp.Packet)) {
+	p.high.RegisterRTPHandler(id, fun)
+}
+
+func (p *SimulcastPipeline) DeregisterRTPHandler(id string) {
+	p.high.DeregisterRTPHandler(id)
+}
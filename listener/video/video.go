@@ -1,63 +1,147 @@
 package video
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
-	"github.com/pion/randutil"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 	proxy "github.com/thinkonmay/thinkremote-rtchub"
 	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/multiplexer"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/av1"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h265"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/vp8"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/vp9"
 	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/wrapper"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
+const (
+	// switch_timeout bounds how long frames are dropped after a display
+	// switch while waiting for the keyframe of the new display
+	switch_timeout = time.Second
+)
+
 type VideoPipelineC unsafe.Pointer
 type VideoPipeline struct {
-	closed   chan bool
+	ctx      context.Context
+	cancel   context.CancelFunc
 	pipeline unsafe.Pointer
 	mut      *sync.Mutex
 
-	clockRate float64
+	clock   *clock.Clock
+	history *clock.History
+	// frames counts the frames sent, for layer aware extensions
+	frames uint16
 
 	codec       string
 	Multiplexer *multiplexer.Multiplexer
+
+	// metadata is set while the display streams HDR
+	metadata atomic.Pointer[hdr.Metadata]
+	// unsignalled is set once an HDR sps without BT.2020 PQ was reported
+	unsignalled bool
+}
+
+// source is the queue a pipeline reads, the selected display or one
+// fixed queue
+type source interface {
+	Current() *proxy.Queue
+	Index() int
 }
 
-func CreatePipeline(queue *proxy.Queue) (listener.Listener,
+// CreatePipeline packetizes the selected display as codec, which has to
+// match what the encoder writes into the queue
+func CreatePipeline(displays *proxy.Displays, codec string) (listener.Listener,
 	error) {
+	pipeline, err := create(displays, codec)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func create(displays source, codec string) (*VideoPipeline, error) {
+	var packetizer rtppay.Packetizer
+	switch codec {
+	case webrtc.MimeTypeH264:
+		packetizer = &wrapper.PacketizerWrapper{
+			Fun: h264.RTPPay,
+			MTU: 1400,
+		}
+	case webrtc.MimeTypeH265:
+		packetizer = &wrapper.PacketizerWrapper{
+			Fun: h265.RTPPay,
+			MTU: 1400,
+		}
+	case webrtc.MimeTypeAV1:
+		packetizer = av1.NewAV1Payloader(1400, 0, 0, 90000)
+	case webrtc.MimeTypeVP8:
+		packetizer = vp8.NewVP8Payloader(1400)
+	case webrtc.MimeTypeVP9:
+		packetizer = vp9.NewVP9Payloader(1400)
+	default:
+		return nil, fmt.Errorf("unsupported video codec %s", codec)
+	}
 
 	pipeline := &VideoPipeline{
-		closed:   make(chan bool, 2),
 		pipeline: nil,
 		mut:      &sync.Mutex{},
-		codec:    webrtc.MimeTypeH264,
-
-		clockRate: 90000,
-		Multiplexer: multiplexer.NewMultiplexer("video", &wrapper.PacketizerWrapper{
-			Fun:       h264.RTPPay,
-			Timestamp: randutil.NewMathRandomGenerator().Uint32(),
-			MTU:       1400,
-		}),
+		codec:    codec,
+
+		clock:       clock.NewClock(90000),
+		history:     clock.NewHistory(),
+		Multiplexer: multiplexer.NewMultiplexer("video", packetizer),
 	}
 
 	buffer := make([]byte, 1024*1024) //1MB
+	queue := displays.Current()
 	local_index := queue.CurrentIndex()
+	switched := &switcher{now: time.Now}
 	firsttime := true
-	thread.HighPriorityLoop(pipeline.closed, func() {
+	pipeline.ctx, pipeline.cancel = context.WithCancel(context.Background())
+	thread.HighPriorityLoop(pipeline.ctx, func() {
+		if current := displays.Current(); current != queue {
+			queue, local_index = current, current.CurrentIndex()
+			switched.start()
+			fmt.Printf("streaming display %d\n", displays.Index())
+		}
 		for local_index >= queue.CurrentIndex() {
+			if pipeline.ctx.Err() != nil || displays.Current() != queue {
+				return
+			}
 			time.Sleep(time.Microsecond * 100)
 		}
 
 		local_index++
-		if size, duration := queue.Copy(buffer, local_index); size > len(buffer) {
+		if switched.drop(queue.IsIdr(local_index)) {
+			return
+		}
+
+		if size, _ := queue.Copy(buffer, local_index); size > len(buffer) {
 		} else {
-			pipeline.Multiplexer.Send(buffer[:size], uint32(time.Duration(duration).Seconds()*pipeline.clockRate))
+			captured, now := queue.Captured(local_index), time.Now()
+			timestamp := now
+			if !captured.IsZero() {
+				timestamp = captured
+			}
+			frame := pipeline.hdr(queue, buffer[:size])
+
+			info := clock.Frame{Timestamp: pipeline.clock.Timestamp(timestamp), Captured: captured, Packetized: now}
+			info.EncodeStart, info.EncodeFinish = queue.Encoded(local_index)
+			info.FrameNumber, info.TemporalID, info.Keyframe = pipeline.frames, temporalID(codec, frame), queue.IsIdr(local_index)
+			pipeline.frames++
+			pipeline.history.Add(info)
+			pipeline.Multiplexer.Send(frame, info.Timestamp)
 		}
 
 		if firsttime {
@@ -68,12 +152,86 @@ func CreatePipeline(queue *proxy.Queue) (listener.Listener,
 	return pipeline, nil
 }
 
+// switcher drops the frames of a newly selected display until its first
+// keyframe, frames before it reference the previous display. It gives up
+// waiting after switch_timeout
+type switcher struct {
+	now      func() time.Time
+	switched time.Time
+}
+
+func (s *switcher) start() {
+	s.switched = s.now()
+}
+
+func (s *switcher) drop(keyframe bool) bool {
+	if s.switched.IsZero() {
+		return false
+	} else if !keyframe && s.now().Sub(s.switched) < switch_timeout {
+		return true
+	}
+	s.switched = time.Time{}
+	return false
+}
+
+// temporalID is the temporal layer of frame, zero for codecs or streams
+// without layers
+func temporalID(codec string, frame []byte) int {
+	var tid int
+	switch codec {
+	case webrtc.MimeTypeAV1:
+		tid, _ = av1.TemporalID(frame)
+	case webrtc.MimeTypeH264:
+		tid, _ = h264.TemporalID(frame)
+	}
+	return tid
+}
+
+// hdr follows the HDR state of queue, HEVC keyframes lacking the mastering
+// display SEI get it injected
+func (p *VideoPipeline) hdr(queue *proxy.Queue, frame []byte) []byte {
+	metadata, ok := queue.HDR()
+	if !ok {
+		p.metadata.Store(nil)
+		return frame
+	} else if current := p.metadata.Load(); current == nil || *current != metadata {
+		p.metadata.Store(&metadata)
+	}
+
+	if p.codec != webrtc.MimeTypeH265 || !h265.IsKeyframe(frame) {
+		return frame
+	} else if signalled, found := h265.SignalsHDR(frame); found && !signalled && !p.unsignalled {
+		fmt.Println("hdr stream sps does not signal BT.2020 PQ or HLG")
+		p.unsignalled = true
+	}
+	return h265.InjectHDR(frame, metadata)
+}
+
+// ColorSpace is the color space header extension payload while the
+// display streams HDR
+func (p *VideoPipeline) ColorSpace() []byte {
+	if metadata := p.metadata.Load(); metadata != nil {
+		return metadata.ColorSpace()
+	}
+	return nil
+}
+
+// RTPTime maps wallclock t onto the RTP timestamps of the track
+func (p *VideoPipeline) RTPTime(t time.Time) (uint32, bool) {
+	return p.clock.RTPTime(t)
+}
+
+// Frame is what the host knows about the frame stamped with timestamp
+func (p *VideoPipeline) Frame(timestamp uint32) (clock.Frame, bool) {
+	return p.history.Get(timestamp)
+}
+
 func (p *VideoPipeline) GetCodec() string {
 	return p.codec
 }
 
 func (p *VideoPipeline) Close() {
-	thread.TriggerStop(p.closed)
+	p.cancel()
 }
 
 func (p *VideoPipeline) RegisterRTPHandler(id string, fun func(pkt *rtp.Packet)) {
@@ -0,0 +1,28 @@
+package video
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitchTimeout(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := &switcher{now: func() time.Time { return now }}
+	require.False(t, s.drop(false))
+
+	// frames of the new display wait for its keyframe
+	s.start()
+	require.True(t, s.drop(false))
+	require.False(t, s.drop(true))
+	require.False(t, s.drop(false))
+
+	// without one they pass once the switch timed out
+	s.start()
+	now = now.Add(switch_timeout / 2)
+	require.True(t, s.drop(false))
+	now = now.Add(switch_timeout)
+	require.False(t, s.drop(false))
+	require.False(t, s.drop(false))
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/signalling"
+	"github.com/thinkonmay/thinkremote-rtchub/util/auth"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+const (
+	policy_interval = time.Second
+	// end_flush is how long the reason of a closing session may take to
+	// reach the viewer
+	end_flush = time.Second
+)
+
+// RecordInput marks input of the session holding from reaching the host,
+// a session idles once its viewer sent no input for the idle timeout. The
+// other sessions of the same viewer, such as the audio connection of the
+// two connection layout, never carry input and follow its sessions
+func RecordInput(from *datachannel.Permissions) {
+	if from == nil {
+		return
+	}
+
+	sessions.mut.Lock()
+	defer sessions.mut.Unlock()
+	viewers := map[string]bool{}
+	for _, proxy := range sessions.proxies {
+		if proxy.perms == from && proxy.viewer != "" {
+			viewers[proxy.viewer] = true
+		}
+	}
+
+	now := time.Now().UnixNano()
+	for _, proxy := range sessions.proxies {
+		if proxy.perms == from || viewers[proxy.viewer] {
+			proxy.input.Store(now)
+		}
+	}
+}
+
+// viewerOf is the subject of the claims, or the token itself when it names
+// no subject, empty for anonymous sessions that share nothing
+func viewerOf(claims *auth.Claims, token string) string {
+	if claims.Subject != "" {
+		return "sub:" + claims.Subject
+	} else if token != "" {
+		return "token:" + token
+	}
+	return ""
+}
+
+// lastInput is zero until the first input of the session
+func (proxy *Proxy) lastInput() time.Time {
+	if input := proxy.input.Load(); input != 0 {
+		return time.Unix(0, input)
+	}
+	return time.Time{}
+}
+
+// limit is a point in time the session closes at
+type limit struct {
+	reason string
+	at     time.Time
+	// warned limits are announced to the viewer ahead of time
+	warned bool
+}
+
+// policy decides when a session is warned and when it closes, warnings
+// are sent once per deadline so input moving the idle deadline warns again
+type policy struct {
+	conf   config.PolicyConfig
+	warned map[string]time.Time
+}
+
+func newPolicy(conf config.PolicyConfig) *policy {
+	return &policy{
+		conf:   conf,
+		warned: map[string]time.Time{},
+	}
+}
+
+func (p *policy) limits(started, input, report time.Time) []limit {
+	ret := []limit{}
+	if p.conf.IdleTimeout > 0 {
+		ret = append(ret, limit{signalling.ReasonIdle, latest(started, input).Add(p.conf.IdleTimeout), true})
+	}
+	if p.conf.MaxDuration > 0 {
+		ret = append(ret, limit{signalling.ReasonMaxDuration, started.Add(p.conf.MaxDuration), true})
+	}
+	if p.conf.ReportTimeout > 0 {
+		ret = append(ret, limit{signalling.ReasonNoReceiver, latest(started, report).Add(p.conf.ReportTimeout), false})
+	}
+	return ret
+}
+
+// check returns the reason to close the session at now, empty while it
+// may run, and the limits to warn the viewer about
+func (p *policy) check(now, started, input, report time.Time) (reason string, warnings []limit) {
+	var closing *limit
+	for _, l := range p.limits(started, input, report) {
+		if !now.Before(l.at) {
+			if closing == nil || l.at.Before(closing.at) {
+				closing = &l
+			}
+		} else if l.warned && p.conf.Warning > 0 && l.at.Sub(now) <= p.conf.Warning && !p.warned[l.reason].Equal(l.at) {
+			p.warned[l.reason] = l.at
+			warnings = append(warnings, l)
+		}
+	}
+
+	if closing != nil {
+		return closing.reason, nil
+	}
+	return "", warnings
+}
+
+// warning is "sw|<reason>|<seconds left>", in the hid wire format
+func warning(l limit, now time.Time) datachannel.Message {
+	return datachannel.Text(fmt.Sprintf("sw|%s|%d", l.reason, int(l.at.Sub(now).Round(time.Second).Seconds())))
+}
+
+// ending is "se|<reason>", sent right before the session closes
+func ending(reason string) datachannel.Message {
+	return datachannel.Text(fmt.Sprintf("se|%s", reason))
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// enforce runs every policy interval while the session lives
+func (proxy *Proxy) enforce() {
+	now := time.Now()
+	reason, warnings := proxy.policy.check(now, proxy.started, proxy.lastInput(), proxy.webrtcClient.LastReport())
+	for _, l := range warnings {
+		if err := proxy.webrtcClient.Notify(proxy.policy.conf.Channel, warning(l, now)); err != nil {
+			fmt.Printf("failed to warn session %s %s\n", proxy.id, err.Error())
+		}
+	}
+	if reason != "" {
+		proxy.End(reason)
+	}
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/signalling"
+	"github.com/thinkonmay/thinkremote-rtchub/util/auth"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+func TestPolicyIdle(t *testing.T) {
+	started := time.Unix(1000, 0)
+	p := newPolicy(config.PolicyConfig{IdleTimeout: time.Minute, Warning: 10 * time.Second})
+
+	reason, warnings := p.check(started.Add(45*time.Second), started, time.Time{}, time.Time{})
+	require.Empty(t, reason)
+	require.Empty(t, warnings)
+
+	reason, warnings = p.check(started.Add(50*time.Second), started, time.Time{}, time.Time{})
+	require.Empty(t, reason)
+	require.Len(t, warnings, 1)
+	require.Equal(t, "sw|idle|10", string(warning(warnings[0], started.Add(50*time.Second)).Data))
+
+	// warned once per deadline
+	_, warnings = p.check(started.Add(55*time.Second), started, time.Time{}, time.Time{})
+	require.Empty(t, warnings)
+
+	// input moves the deadline and warns again
+	input := started.Add(55 * time.Second)
+	reason, warnings = p.check(started.Add(time.Minute), started, input, time.Time{})
+	require.Empty(t, reason)
+	require.Empty(t, warnings)
+	_, warnings = p.check(input.Add(51*time.Second), started, input, time.Time{})
+	require.Len(t, warnings, 1)
+
+	reason, _ = p.check(input.Add(time.Minute), started, input, time.Time{})
+	require.Equal(t, signalling.ReasonIdle, reason)
+}
+
+func TestPolicyLimits(t *testing.T) {
+	started := time.Unix(1000, 0)
+	p := newPolicy(config.PolicyConfig{
+		IdleTimeout:   time.Hour,
+		MaxDuration:   time.Minute,
+		ReportTimeout: 20 * time.Second,
+		Warning:       time.Minute,
+	})
+
+	// receiver reports are never warned about
+	reason, warnings := p.check(started.Add(time.Second), started, time.Time{}, started)
+	require.Empty(t, reason)
+	require.Len(t, warnings, 1)
+	require.Equal(t, signalling.ReasonMaxDuration, warnings[0].reason)
+
+	reason, _ = p.check(started.Add(25*time.Second), started, time.Time{}, started.Add(time.Second))
+	require.Equal(t, signalling.ReasonNoReceiver, reason)
+
+	report := started.Add(50 * time.Second)
+	reason, _ = p.check(started.Add(time.Minute), started, started.Add(time.Minute), report)
+	require.Equal(t, signalling.ReasonMaxDuration, reason)
+
+	disabled := newPolicy(config.PolicyConfig{Warning: time.Minute})
+	reason, warnings = disabled.check(started.Add(time.Hour*24), started, time.Time{}, time.Time{})
+	require.Empty(t, reason)
+	require.Empty(t, warnings)
+}
+
+func TestRecordInput(t *testing.T) {
+	viewer := &Proxy{id: "viewer", perms: datachannel.NewPermissions(datachannel.CapInput)}
+	other := &Proxy{id: "other", perms: datachannel.NewPermissions(datachannel.CapInput)}
+	sessions.mut.Lock()
+	sessions.proxies[viewer.id], sessions.proxies[other.id] = viewer, other
+	sessions.mut.Unlock()
+	t.Cleanup(func() {
+		sessions.mut.Lock()
+		delete(sessions.proxies, viewer.id)
+		delete(sessions.proxies, other.id)
+		sessions.mut.Unlock()
+	})
+
+	// input of one viewer keeps no other session from idling
+	RecordInput(viewer.perms)
+	RecordInput(nil)
+	require.False(t, viewer.lastInput().IsZero())
+	require.True(t, other.lastInput().IsZero())
+}
+
+func TestRecordInputPerViewer(t *testing.T) {
+	claims := &auth.Claims{Subject: "alice"}
+	video := &Proxy{id: "video", perms: claims.Permissions(), viewer: viewerOf(claims, "token")}
+	audio := &Proxy{id: "audio", perms: claims.Permissions(), viewer: viewerOf(claims, "token")}
+	other := &Proxy{id: "bob", perms: datachannel.NewPermissions(), viewer: viewerOf(&auth.Claims{Subject: "bob"}, "token")}
+	sessions.mut.Lock()
+	for _, proxy := range []*Proxy{video, audio, other} {
+		sessions.proxies[proxy.id] = proxy
+	}
+	sessions.mut.Unlock()
+	t.Cleanup(func() {
+		sessions.mut.Lock()
+		for _, proxy := range []*Proxy{video, audio, other} {
+			delete(sessions.proxies, proxy.id)
+		}
+		sessions.mut.Unlock()
+	})
+
+	// input on the video connection keeps the audio connection of the
+	// same viewer from idling
+	RecordInput(video.perms)
+	require.False(t, video.lastInput().IsZero())
+	require.Equal(t, video.lastInput(), audio.lastInput())
+	require.True(t, other.lastInput().IsZero())
+
+	require.Equal(t, "token:abc", viewerOf(&auth.Claims{}, "abc"))
+	require.Empty(t, viewerOf(&auth.Claims{}, ""))
+}
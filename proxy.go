@@ -1,48 +1,161 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	webrtclib "github.com/pion/webrtc/v4"
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
 	"github.com/thinkonmay/thinkremote-rtchub/listener"
 	"github.com/thinkonmay/thinkremote-rtchub/signalling"
+	"github.com/thinkonmay/thinkremote-rtchub/util/auth"
 	"github.com/thinkonmay/thinkremote-rtchub/util/config"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 	"github.com/thinkonmay/thinkremote-rtchub/webrtc"
 )
 
+const (
+	restart_interval = 5 * time.Second
+)
+
+// ResumeStats counts ICE restarts of every proxy in the process
+type ResumeStats struct {
+	Attempts uint64
+	Resumed  uint64
+	Expired  uint64
+}
+
+var resume_attempts, resume_resumed, resume_expired atomic.Uint64
+
+func GetResumeStats() ResumeStats {
+	return ResumeStats{
+		Attempts: resume_attempts.Load(),
+		Resumed:  resume_resumed.Load(),
+		Expired:  resume_expired.Load(),
+	}
+}
+
+// SessionInfo describes a running proxy for operators
+type SessionInfo struct {
+	ID           string                   `json:"id"`
+	Subject      string                   `json:"subject,omitempty"`
+	Started      time.Time                `json:"started"`
+	Capabilities []datachannel.Capability `json:"capabilities"`
+	webrtc.Stats
+}
+
+// sessions are the proxies currently running in the process
+var sessions = struct {
+	mut     *sync.Mutex
+	proxies map[string]*Proxy
+}{
+	mut:     &sync.Mutex{},
+	proxies: map[string]*Proxy{},
+}
+
+// session_ids numbers the proxies of the process
+var session_ids atomic.Uint64
+
+// Sessions lists running proxies, oldest first
+func Sessions() []*Proxy {
+	sessions.mut.Lock()
+	defer sessions.mut.Unlock()
+	ret := make([]*Proxy, 0, len(sessions.proxies))
+	for _, proxy := range sessions.proxies {
+		ret = append(ret, proxy)
+	}
+	slices.SortFunc(ret, func(a, b *Proxy) int { return a.started.Compare(b.started) })
+	return ret
+}
+
+// InjectFunc adds the ICE servers of session id to conf, such as
+// credentials of an embedded TURN server metered per session
+type InjectFunc func(conf config.WebRTCConfig, id string) (config.WebRTCConfig, error)
+
 type Proxy struct {
+	id      string
+	started time.Time
+	perms   *datachannel.Permissions
+
 	listeners []listener.Listener
 
 	chan_conf        datachannel.IDatachannel
 	signallingClient signalling.Signalling
 	webrtcClient     *webrtc.WebRTCClient
+	claims           *auth.Claims
+
+	resume_timeout time.Duration
+	policy         *policy
+	// viewer identifies who presented the token, the sessions of one
+	// viewer share the time of its last input
+	viewer string
+	// input is the unix nano time of the last input of the viewer
+	input atomic.Int64
+	mut   *sync.Mutex
+	// resumed is set while an ICE restart is in flight
+	resumed chan bool
+	// ended is set once the exchange of signallingClient ended, a restart
+	// reopens it to deliver its offer
+	ended bool
 
-	stop chan bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   *sync.Once
 }
 
 func InitWebRTCProxy(grpc_conf signalling.Signalling,
 	webrtc_conf *config.WebRTCConfig,
+	verifier auth.Verifier,
 	chan_conf datachannel.IDatachannel,
 	lis []listener.Listener,
 	onTrack webrtc.OnTrackFunc,
 	onIDR webrtc.OnIDRFunc,
+	inject InjectFunc,
 ) (err error) {
 	fmt.Printf("started proxy\n")
+	claims, err := verifier.Verify(grpc_conf.Token())
+	if err != nil {
+		grpc_conf.Stop()
+		return fmt.Errorf("session rejected: %w", err)
+	}
+
 	proxy := &Proxy{
+		id:               strconv.FormatUint(session_ids.Add(1), 10),
+		started:          time.Now(),
+		perms:            claims.Permissions(),
+		claims:           claims,
+		viewer:           viewerOf(claims, grpc_conf.Token()),
 		chan_conf:        chan_conf,
 		signallingClient: grpc_conf,
 		listeners:        lis,
-		stop:             make(chan bool, 2),
+		resume_timeout:   webrtc_conf.ResumeTimeout,
+		policy:           newPolicy(webrtc_conf.Policy),
+		mut:              &sync.Mutex{},
+		once:             &sync.Once{},
 	}
 
-	if proxy.webrtcClient, err = webrtc.InitWebRtcClient(onTrack, onIDR, *webrtc_conf); err != nil {
+	session := *webrtc_conf
+	if inject == nil {
+	} else if injected, err := inject(session, proxy.id); err != nil {
+		fmt.Printf("error issue ice servers for session %s %s\n", proxy.id, err.Error())
+	} else {
+		session = injected
+	}
+
+	if proxy.webrtcClient, err = webrtc.InitWebRtcClient(onTrack, onIDR, session); err != nil {
 		return
 	}
+	proxy.ctx, proxy.cancel = context.WithCancel(context.Background())
+	sessions.mut.Lock()
+	sessions.proxies[proxy.id] = proxy
+	sessions.mut.Unlock()
 
-	thread.SafeSelect(proxy.stop, proxy.webrtcClient.GatherStateChange(), func(_state interface{}) {
+	thread.SafeSelect(proxy.ctx, proxy.webrtcClient.GatherStateChange(), func(_state interface{}) {
 		state := _state.(webrtclib.ICEGatheringState)
 		switch state {
 		case webrtclib.ICEGatheringStateGathering:
@@ -50,60 +163,213 @@ func InitWebRTCProxy(grpc_conf signalling.Signalling,
 		case webrtclib.ICEGatheringStateUnknown:
 		}
 	})
-	thread.SafeSelect(proxy.stop, proxy.webrtcClient.ConnectionStateChange(), func(_state interface{}) {
+	thread.SafeSelect(proxy.ctx, proxy.webrtcClient.ConnectionStateChange(), func(_state interface{}) {
 		state := _state.(webrtclib.ICEConnectionState)
 		switch state {
 		case webrtclib.ICEConnectionStateConnected:
+			proxy.connected()
 		case webrtclib.ICEConnectionStateCompleted:
+			proxy.connected()
 		case webrtclib.ICEConnectionStateClosed:
 			proxy.Stop()
 		case webrtclib.ICEConnectionStateFailed:
-			proxy.Stop()
+			proxy.resume()
 		case webrtclib.ICEConnectionStateDisconnected:
-			proxy.Stop()
+			proxy.resume()
 		}
 	})
-	thread.SafeSelect(proxy.stop, proxy.webrtcClient.OnLocalICE(), func(ice interface{}) {
-		proxy.signallingClient.SendICE(ice.(*webrtclib.ICECandidateInit))
+	thread.SafeSelect(proxy.ctx, proxy.webrtcClient.OnLocalICE(), func(ice interface{}) {
+		proxy.signalling().SendICE(ice.(*webrtclib.ICECandidateInit))
 	})
-	thread.SafeSelect(proxy.stop, proxy.webrtcClient.OnLocalSDP(), func(sdp interface{}) {
-		proxy.signallingClient.SendSDP(sdp.(*webrtclib.SessionDescription))
-	})
-	proxy.signallingClient.OnICE(func(i *webrtclib.ICECandidateInit) {
-		proxy.webrtcClient.OnIncomingICE(i)
-	})
-	proxy.signallingClient.OnSDP(func(i *webrtclib.SessionDescription) {
-		proxy.webrtcClient.OnIncominSDP(i)
+	thread.SafeSelect(proxy.ctx, proxy.webrtcClient.OnLocalSDP(), func(sdp interface{}) {
+		proxy.signalling().SendSDP(sdp.(*webrtclib.SessionDescription))
 	})
+	proxy.wire(grpc_conf)
 
-	return proxy.start()
+	if err = proxy.start(); err != nil {
+		proxy.Stop()
+	}
+	return
 }
 
 func (proxy *Proxy) start() error {
-	proxy.webrtcClient.RegisterDataChannels(proxy.chan_conf)
+	proxy.webrtcClient.RegisterDataChannels(proxy.chan_conf, proxy.perms)
 	proxy.webrtcClient.Listen(proxy.listeners)
 	defer proxy.webrtcClient.StopSignaling()
 
-	success := make(chan bool, 2)
-	proxy.signallingClient.WaitForEnd(func() {
-		success <- true
-	})
-	thread.SafeThread(func() {
-		time.Sleep(time.Second * 60)
-		success <- false
+	if expiry := proxy.claims.ExpiresAt(); !expiry.IsZero() {
+		thread.SafeThread(func() {
+			select {
+			case <-proxy.ctx.Done():
+			case <-time.After(time.Until(expiry)):
+				proxy.End(signalling.ReasonExpired)
+			}
+		})
+	}
+	thread.SafeLoop(proxy.ctx, policy_interval, proxy.enforce)
+
+	ended := make(chan bool, 1)
+	proxy.signalling().WaitForEnd(func() {
+		ended <- true
 	})
 
-	if !<-success {
+	select {
+	case <-time.After(time.Second * 60):
 		return fmt.Errorf("application exchange signaling timeout, closing")
-	} else {
+	case <-ended:
 		fmt.Println("webrtc connection established successfully")
 		return nil
 	}
 }
 
+// wire exchanges descriptions and candidates through client, its end is
+// remembered so restarts know to reopen it
+func (proxy *Proxy) wire(client signalling.Signalling) {
+	client.OnICE(func(i *webrtclib.ICECandidateInit) {
+		proxy.webrtcClient.OnIncomingICE(i)
+	})
+	client.OnSDP(func(i *webrtclib.SessionDescription) {
+		proxy.webrtcClient.OnIncominSDP(i)
+	})
+	client.WaitForEnd(func() {
+		proxy.mut.Lock()
+		defer proxy.mut.Unlock()
+		if proxy.signallingClient == client {
+			proxy.ended = true
+		}
+	})
+}
+
+func (proxy *Proxy) signalling() signalling.Signalling {
+	proxy.mut.Lock()
+	defer proxy.mut.Unlock()
+	return proxy.signallingClient
+}
+
+// reopen connects signalling again once the exchange ended, so restart
+// offers reach the viewer
+func (proxy *Proxy) reopen() error {
+	proxy.mut.Lock()
+	if !proxy.ended {
+		proxy.mut.Unlock()
+		return nil
+	}
+
+	client, err := proxy.signallingClient.Reopen()
+	if err != nil {
+		proxy.mut.Unlock()
+		return err
+	}
+	proxy.signallingClient, proxy.ended = client, false
+	proxy.mut.Unlock()
+
+	proxy.wire(client)
+	return nil
+}
+
+// resume keeps a disconnected session alive while ICE restarts are
+// offered, reopening signalling when the exchange ended. It gives up once
+// the resume timeout expires
+func (proxy *Proxy) resume() {
+	proxy.mut.Lock()
+	if proxy.resumed != nil {
+		proxy.mut.Unlock()
+		return
+	} else if proxy.resume_timeout <= 0 {
+		proxy.mut.Unlock()
+		proxy.Stop()
+		return
+	}
+
+	resumed := make(chan bool, 1)
+	proxy.resumed = resumed
+	proxy.mut.Unlock()
+
+	resume_attempts.Add(1)
+	started := time.Now()
+	deadline := time.After(proxy.resume_timeout)
+	thread.SafeThread(func() {
+		for !proxy.webrtcClient.Closed {
+			if err := proxy.reopen(); err != nil {
+				fmt.Printf("failed to reopen signalling %s\n", err.Error())
+			} else if err := proxy.webrtcClient.RestartICE(); err != nil {
+				fmt.Printf("failed to restart ice %s\n", err.Error())
+			}
+
+			select {
+			case <-resumed:
+				resume_resumed.Add(1)
+				fmt.Printf("session resumed after %s\n", time.Since(started))
+				return
+			case <-deadline:
+				resume_expired.Add(1)
+				fmt.Printf("session not resumed after %s, closing\n", time.Since(started))
+				proxy.Stop()
+				return
+			case <-proxy.ctx.Done():
+				return
+			case <-time.After(restart_interval):
+			}
+		}
+	})
+}
+
+func (proxy *Proxy) connected() {
+	proxy.mut.Lock()
+	defer proxy.mut.Unlock()
+	if proxy.resumed != nil {
+		proxy.resumed <- true
+		proxy.resumed = nil
+	}
+}
+
+func (proxy *Proxy) ID() string {
+	return proxy.id
+}
+
+// Permissions can be changed while the session runs
+func (proxy *Proxy) Permissions() *datachannel.Permissions {
+	return proxy.perms
+}
+
+func (proxy *Proxy) Info() SessionInfo {
+	return SessionInfo{
+		ID:           proxy.id,
+		Subject:      proxy.claims.Subject,
+		Started:      proxy.started,
+		Capabilities: proxy.perms.Capabilities(),
+		Stats:        proxy.webrtcClient.Stats(),
+	}
+}
+
+// End closes the session and tells the viewer why, on the policy
+// datachannel and through signalling while the exchange still runs
+func (proxy *Proxy) End(reason string) {
+	fmt.Printf("closing session %s: %s\n", proxy.id, reason)
+	channel := proxy.policy.conf.Channel
+	if err := proxy.webrtcClient.Notify(channel, ending(reason)); err != nil {
+		fmt.Printf("failed to send end reason to session %s %s\n", proxy.id, err.Error())
+	} else {
+		proxy.webrtcClient.Flush(channel, end_flush)
+	}
+
+	proxy.mut.Lock()
+	ended := proxy.ended
+	proxy.mut.Unlock()
+	if !ended {
+		proxy.signalling().End(reason)
+	}
+	proxy.Stop()
+}
+
 func (prox *Proxy) Stop() {
-	fmt.Println("proxy stopped")
-	prox.webrtcClient.Close()
-	prox.signallingClient.Stop()
-	thread.TriggerStop(prox.stop)
+	prox.once.Do(func() {
+		fmt.Println("proxy stopped")
+		sessions.mut.Lock()
+		delete(sessions.proxies, prox.id)
+		sessions.mut.Unlock()
+		prox.webrtcClient.Close()
+		prox.signalling().Stop()
+		prox.cancel()
+	})
 }
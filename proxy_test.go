@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v4"
+	webrtclib "github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/signalling"
+	"github.com/thinkonmay/thinkremote-rtchub/util/auth"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread/leakcheck"
+)
+
+const (
+	recover_timeout = time.Second * 20
+)
+
+// exchange is the signalling server between the proxy and one viewer,
+// each open pipe is one exchange of the proxy with it
+type exchange struct {
+	// sdp and ice carry descriptions and candidates to the viewer
+	sdp, ice chan interface{}
+	current  atomic.Pointer[pipe]
+	opened   atomic.Int32
+}
+
+func newExchange() *exchange {
+	return &exchange{
+		sdp: make(chan interface{}, 8),
+		ice: make(chan interface{}, 64),
+	}
+}
+
+func (e *exchange) open() *pipe {
+	p := &pipe{
+		exchange: e,
+		sdp:      make(chan interface{}, 8),
+		ice:      make(chan interface{}, 64),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	e.current.Store(p)
+	e.opened.Add(1)
+	return p
+}
+
+type pipe struct {
+	exchange *exchange
+	sdp, ice chan interface{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// descriptions and candidates only pass while the exchange is open
+func (p *pipe) SendSDP(sdp *webrtclib.SessionDescription) {
+	if p.ctx.Err() == nil {
+		p.exchange.sdp <- sdp
+	}
+}
+
+func (p *pipe) SendICE(ice *webrtclib.ICECandidateInit) {
+	if p.ctx.Err() == nil {
+		p.exchange.ice <- ice
+	}
+}
+
+func (p *pipe) OnICE(fun signalling.OnIceFunc) {
+	thread.SafeSelect(p.ctx, p.ice, func(ice interface{}) {
+		fun(ice.(*webrtclib.ICECandidateInit))
+	})
+}
+
+func (p *pipe) OnSDP(fun signalling.OnSDPFunc) {
+	thread.SafeSelect(p.ctx, p.sdp, func(sdp interface{}) {
+		fun(sdp.(*webrtclib.SessionDescription))
+	})
+}
+
+func (p *pipe) WaitForStart(fun func())                { fun() }
+func (p *pipe) WaitForEnd(fun func())                  { thread.OnDone(p.ctx, fun) }
+func (p *pipe) Token() string                          { return "" }
+func (p *pipe) Reopen() (signalling.Signalling, error) { return p.exchange.open(), nil }
+func (p *pipe) End(string)                             { p.Stop() }
+func (p *pipe) Stop()                                  { p.cancel() }
+
+// lossy drops every packet in both directions while down is set
+type lossy struct {
+	net.PacketConn
+	down atomic.Bool
+}
+
+func (l *lossy) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := l.PacketConn.ReadFrom(p)
+		if err != nil || !l.down.Load() {
+			return n, addr, err
+		}
+	}
+}
+
+func (l *lossy) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if l.down.Load() {
+		return len(p), nil
+	}
+	return l.PacketConn.WriteTo(p, addr)
+}
+
+// viewer answers every offer of the exchange over a socket that can be
+// taken down
+func viewer(t *testing.T, e *exchange) (*webrtclib.PeerConnection, *lossy) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Nil(t, err)
+	socket := &lossy{PacketConn: conn}
+	mux := ice.NewUDPMuxDefault(ice.UDPMuxParams{UDPConn: socket})
+	t.Cleanup(func() { mux.Close() })
+
+	engine := webrtclib.SettingEngine{}
+	engine.SetIncludeLoopbackCandidate(true)
+	engine.SetNetworkTypes([]webrtclib.NetworkType{webrtclib.NetworkTypeUDP4})
+	engine.SetICEUDPMux(mux)
+	engine.SetICETimeouts(time.Second, time.Second*2, time.Millisecond*200)
+	peer, err := webrtclib.NewAPI(webrtclib.WithSettingEngine(engine)).NewPeerConnection(webrtclib.Configuration{})
+	require.Nil(t, err)
+	t.Cleanup(func() { peer.Close() })
+
+	peer.OnICECandidate(func(candidate *webrtclib.ICECandidate) {
+		if candidate != nil {
+			init := candidate.ToJSON()
+			e.current.Load().ice <- &init
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	thread.SafeSelect(ctx, e.sdp, func(_sdp interface{}) {
+		offer := _sdp.(*webrtclib.SessionDescription)
+		if err := peer.SetRemoteDescription(*offer); err != nil {
+		} else if answer, err := peer.CreateAnswer(nil); err != nil {
+		} else if err = peer.SetLocalDescription(answer); err != nil {
+		} else {
+			e.current.Load().sdp <- &answer
+		}
+	})
+	thread.SafeSelect(ctx, e.ice, func(ice interface{}) {
+		peer.AddICECandidate(*ice.(*webrtclib.ICECandidateInit))
+	})
+	return peer, socket
+}
+
+func TestResume(t *testing.T) {
+	leakcheck.Check(t)
+	e := newExchange()
+	first := e.open()
+	peer, socket := viewer(t, e)
+
+	connected := make(chan bool, 8)
+	peer.OnICEConnectionStateChange(func(state webrtclib.ICEConnectionState) {
+		if state == webrtclib.ICEConnectionStateConnected {
+			connected <- true
+		}
+	})
+	wait := func() {
+		select {
+		case <-connected:
+		case <-time.After(recover_timeout):
+			require.FailNow(t, "viewer did not connect")
+		}
+	}
+
+	verifier, err := auth.New("none", "", 0)
+	require.Nil(t, err)
+	conf := &config.WebRTCConfig{
+		ResumeTimeout: recover_timeout,
+		Network: config.NetworkConfig{
+			NetworkTypes:        []string{"udp4"},
+			IPs:                 []string{"127.0.0.1"},
+			Loopback:            true,
+			DisconnectedTimeout: time.Second,
+			FailedTimeout:       time.Second * 2,
+			KeepAliveInterval:   time.Millisecond * 200,
+		},
+	}
+	chans := datachannel.NewDatachannel(datachannel.Group{Name: "hid"})
+	thread.SafeThread(func() {
+		InitWebRTCProxy(first, conf, verifier, chans, nil, func(*webrtclib.TrackRemote) {}, func() {}, nil)
+	})
+
+	// the server ends the exchange once the viewer connected
+	wait()
+	first.Stop()
+	var session *Proxy
+	require.Eventually(t, func() bool {
+		if running := Sessions(); len(running) == 1 {
+			session = running[0]
+		}
+		return session != nil && session.signalling() == first
+	}, recover_timeout, time.Millisecond*50)
+	t.Cleanup(session.Stop)
+	resumed := GetResumeStats().Resumed
+
+	// dropping ICE makes the session offer a restart over a reopened
+	// exchange, which goes through once the network is back
+	socket.down.Store(true)
+	require.Eventually(t, func() bool { return e.opened.Load() == 2 }, recover_timeout, time.Millisecond*50)
+	socket.down.Store(false)
+	require.Eventually(t, func() bool { return GetResumeStats().Resumed == resumed+1 }, recover_timeout, time.Millisecond*50)
+	require.Contains(t, Sessions(), session)
+	require.Equal(t, webrtclib.ICEConnectionStateConnected, peer.ICEConnectionState())
+}
@@ -5,13 +5,35 @@ package proxy
 */
 import "C"
 import (
+	"fmt"
+	"sync"
+	"time"
 	"unsafe"
+
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
 )
 
 type SharedMemory C.SharedMemory
 type Queue C.Queue
+type Cursor C.Cursor
+
+// CursorState is a consistent copy of the cursor region
+type CursorState struct {
+	Serial  int
+	Visible bool
+	// X and Y locate the hotspot in desktop coordinates
+	X, Y               int
+	Shape              int
+	Width, Height      int
+	HotspotX, HotspotY int
+	// Bitmap holds Width*Height rgba pixels, it is only copied when
+	// the shape changed since the previous read
+	Bitmap []byte
+}
 
 const (
+	Video0     = C.Video0
+	Video1     = C.Video1
 	Audio      = C.Audio
 	Microphone = C.Microphone
 	Input      = C.Input
@@ -20,12 +42,104 @@ const (
 	Framerate  = C.Framerate
 	Bitrate    = C.Bitrate
 	Pointer    = C.Pointer
+	Hdr        = C.Hdr
 )
 
+// extension_size is the size of the extension region this build knows
+const extension_size = C.sizeof_SharedMemoryExtension
+
+// extensions holds the extension region of every attached memory, found
+// from the memory itself or from one of its queues
+var extensions = struct {
+	mut      *sync.RWMutex
+	memories map[*SharedMemory]*C.SharedMemoryExtension
+	queues   map[*Queue]*C.QueueExtension
+}{
+	mut:      &sync.RWMutex{},
+	memories: map[*SharedMemory]*C.SharedMemoryExtension{},
+	queues:   map[*Queue]*C.QueueExtension{},
+}
+
+// extension locates the region following SharedMemory in a mapping of
+// size bytes starting at pointer
+func extension(pointer unsafe.Pointer, size uintptr) (unsafe.Pointer, uintptr) {
+	if size <= unsafe.Sizeof(SharedMemory{}) {
+		return nil, 0
+	}
+	return unsafe.Add(pointer, unsafe.Sizeof(SharedMemory{})), size - unsafe.Sizeof(SharedMemory{})
+}
+
+// attach checks the extension header at region, size bytes of which are
+// mapped. Without a usable extension capture timestamps, audio channels,
+// HDR metadata and the cursor stay disabled
+func (mem *SharedMemory) attach(region unsafe.Pointer, size uintptr) bool {
+	if region == nil || size < unsafe.Sizeof(C.ExtensionHeader{}) {
+		fmt.Println("shared memory has no extension region, timestamps, channels, hdr and cursor are disabled")
+		return false
+	}
+
+	ext := (*C.SharedMemoryExtension)(region)
+	if ext.header.version < 1 || uintptr(ext.header.size) < unsafe.Sizeof(*ext) || uintptr(ext.header.size) > size {
+		fmt.Printf("shared memory extension version %d size %d is not usable, timestamps, channels, hdr and cursor are disabled\n",
+			int(ext.header.version), int(ext.header.size))
+		return false
+	}
+
+	extensions.mut.Lock()
+	defer extensions.mut.Unlock()
+	extensions.memories[mem] = ext
+	for i := range Max {
+		extensions.queues[mem.GetQueue(i)] = &ext.queues[i]
+	}
+	return true
+}
+
 func (mem *SharedMemory) GetQueue(id int) *Queue {
 	return (*Queue)(&mem.queues[id])
 }
 
+// GetCursor is nil when the writer has no extension region
+func (mem *SharedMemory) GetCursor() *Cursor {
+	extensions.mut.RLock()
+	defer extensions.mut.RUnlock()
+	if ext, found := extensions.memories[mem]; found {
+		return (*Cursor)(&ext.cursor)
+	}
+	return nil
+}
+
+// extension is nil when the memory of queue has no extension region
+func (queue *Queue) extension() *C.QueueExtension {
+	extensions.mut.RLock()
+	defer extensions.mut.RUnlock()
+	return extensions.queues[queue]
+}
+
+// Read copies the cursor region, it reports false while the writer is
+// updating it, the bitmap is copied when shape differs from the region
+func (cursor *Cursor) Read(shape int) (state CursorState, ok bool) {
+	serial := int(cursor.serial)
+	if serial%2 != 0 {
+		return state, false
+	}
+
+	state = CursorState{
+		Serial:   serial,
+		Visible:  cursor.visible != 0,
+		X:        int(cursor.x),
+		Y:        int(cursor.y),
+		Shape:    int(cursor.shape),
+		Width:    int(cursor.width),
+		Height:   int(cursor.height),
+		HotspotX: int(cursor.hotspot_x),
+		HotspotY: int(cursor.hotspot_y),
+	}
+	if size := state.Width * state.Height * 4; state.Shape != shape && size > 0 && size <= C.CURSOR_SIZE {
+		state.Bitmap = C.GoBytes(unsafe.Pointer(&cursor.bitmap[0]), C.int(size))
+	}
+	return state, int(cursor.serial) == serial
+}
+
 func (memory *Queue) Raise(event_id, value int) {
 	memory.events[event_id].value_number = C.int(value)
 	memory.events[event_id].read = 0
@@ -47,6 +161,44 @@ func (queue *Queue) GetDisplay() (name string, width,height,offsetX,offsetY,envX
 		int(queue.metadata.env_width),
 		int(queue.metadata.env_height)
 }
+// HDR is the static metadata the encoder publishes in the extension
+// region, ok is false while the queue streams SDR
+func (queue *Queue) HDR() (metadata hdr.Metadata, ok bool) {
+	ext := queue.extension()
+	if ext == nil || ext.hdr == 0 {
+		return metadata, false
+	}
+
+	raw := &ext.hdr_metadata
+	for i := range metadata.Primaries {
+		metadata.Primaries[i] = [2]uint16{uint16(raw.displayPrimaries[i].x), uint16(raw.displayPrimaries[i].y)}
+	}
+	metadata.WhitePoint = [2]uint16{uint16(raw.whitePoint.x), uint16(raw.whitePoint.y)}
+	metadata.MaxLuminance = uint16(raw.maxDisplayLuminance)
+	metadata.MinLuminance = uint16(raw.minDisplayLuminance)
+	metadata.MaxCLL = uint16(raw.maxContentLightLevel)
+	metadata.MaxFALL = uint16(raw.maxFrameAverageLightLevel)
+	return metadata, true
+}
+
+// Active is set while an encoder writes into the queue
+func (queue *Queue) Active() bool {
+	return queue.metadata.active != 0
+}
+
+// Channels is the channel count of an audio queue, zero when the encoder
+// does not tell
+func (queue *Queue) Channels() int {
+	if ext := queue.extension(); ext != nil {
+		return int(ext.channels)
+	}
+	return 0
+}
+
+func (queue *Queue) IsIdr(index int) bool {
+	return queue.array[index%int(C.QUEUE_SIZE)].metadata.is_idr != 0
+}
+
 func (queue *Queue) CurrentIndex() int {
 	return int(queue.index)
 }
@@ -57,6 +209,32 @@ func (queue *Queue) Copy(in []byte, index int) (size int, duration int64) {
 	return int(block.size),int64(block.metadata.duration)
 }
 
+// Captured is the capture time of the packet at index, zero when the
+// writer does not report it
+func (queue *Queue) Captured(index int) time.Time {
+	if ext := queue.extension(); ext != nil {
+		return unixNano(ext.timings[index%int(C.QUEUE_SIZE)].timestamp)
+	}
+	return time.Time{}
+}
+
+// Encoded is when the encoder started and finished the packet at index,
+// zero when the writer does not report it
+func (queue *Queue) Encoded(index int) (start, finish time.Time) {
+	if ext := queue.extension(); ext != nil {
+		timing := &ext.timings[index%int(C.QUEUE_SIZE)]
+		return unixNano(timing.encode_start), unixNano(timing.encode_finish)
+	}
+	return time.Time{}, time.Time{}
+}
+
+func unixNano(timestamp C.longlong) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(timestamp))
+}
+
 func (queue *Queue) Write(in []byte, size int) {
 	new_idx := queue.index + 1
 	block := &queue.array[new_idx%C.QUEUE_SIZE]
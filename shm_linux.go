@@ -28,6 +28,16 @@ func ObtainSharedMemory(token string) (*SharedMemory, error) {
 	var allocate func(unsafe.Pointer) unsafe.Pointer
 	purego.RegisterLibFunc(&allocate, libc, "obtain_shared_memory")
 	pointer := allocate(unsafe.Pointer(&[]byte(token)[0]))
+	memory := (*SharedMemory)(unsafe.Pointer(pointer))
+
+	// writers laying out the extension region export its mapped size
+	if symbol, err := purego.Dlsym(libc, "shared_memory_size"); err != nil {
+		memory.attach(nil, 0)
+	} else {
+		var size func() uintptr
+		purego.RegisterFunc(&size, symbol)
+		memory.attach(extension(pointer, size()))
+	}
 
-	return (*SharedMemory)(unsafe.Pointer(pointer)), nil
+	return memory, nil
 }
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+// region is an extension region with the given header
+func region(version, size int) []byte {
+	ret := make([]byte, extension_size)
+	binary.NativeEndian.PutUint32(ret[0:], uint32(version))
+	binary.NativeEndian.PutUint32(ret[4:], uint32(size))
+	return ret
+}
+
+func TestExtension(t *testing.T) {
+	// writers without the region leave every extended feature disabled
+	memory := new(SharedMemory)
+	require.False(t, memory.attach(nil, 0))
+	require.False(t, memory.attach(unsafe.Pointer(&region(0, extension_size)[0]), extension_size))
+	require.False(t, memory.attach(unsafe.Pointer(&region(1, 16)[0]), extension_size))
+	short := region(1, extension_size)
+	require.False(t, memory.attach(unsafe.Pointer(&short[0]), extension_size-1))
+	require.Nil(t, memory.GetCursor())
+	require.Zero(t, memory.GetQueue(Audio).Channels())
+	require.True(t, memory.GetQueue(Video0).Captured(0).IsZero())
+	_, ok := memory.GetQueue(Video0).HDR()
+	require.False(t, ok)
+
+	// a later version appending fields still carries this one
+	ext := region(2, extension_size)
+	require.True(t, memory.attach(unsafe.Pointer(&ext[0]), extension_size))
+	require.NotNil(t, memory.GetCursor())
+
+	audio, video := memory.GetQueue(Audio), memory.GetQueue(Video0)
+	audio.extension().channels = 6
+	require.Equal(t, 6, audio.Channels())
+	video.extension().timings[1].timestamp = 1_700_000_000_000_000_000
+	require.Equal(t, time.Unix(1_700_000_000, 0), video.Captured(1+128))
+	video.extension().hdr = 1
+	video.extension().hdr_metadata.maxDisplayLuminance = 1000
+	metadata, ok := video.HDR()
+	require.True(t, ok)
+	require.Equal(t, uint16(1000), metadata.MaxLuminance)
+}
+
+func TestExtensionLocation(t *testing.T) {
+	base := unsafe.Pointer(new(SharedMemory))
+	region, size := extension(base, unsafe.Sizeof(SharedMemory{}))
+	require.Nil(t, region)
+	require.Zero(t, size)
+
+	region, size = extension(base, unsafe.Sizeof(SharedMemory{})+extension_size)
+	require.Equal(t, uintptr(extension_size), size)
+	require.Equal(t, uintptr(base)+unsafe.Sizeof(SharedMemory{}), uintptr(region))
+}
@@ -39,5 +39,15 @@ func ObtainSharedMemory(token string) (*SharedMemory, error) {
 		return nil,err
 	}
 
-	return (*SharedMemory)(unsafe.Pointer(pointer)), nil
+	memory := (*SharedMemory)(unsafe.Pointer(pointer))
+
+	// writers laying out the extension region export its mapped size
+	if size, err := mod.FindProc("shared_memory_size"); err != nil {
+		memory.attach(nil, 0)
+	} else {
+		mapped, _, _ := size.Call()
+		memory.attach(extension(unsafe.Pointer(pointer), mapped))
+	}
+
+	return memory, nil
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,15 +17,29 @@ import (
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
+const (
+	end_timeout = time.Second * 5
+)
+
+// message is the json wire format, the server attaches the viewer
+// credential to tSTART and the proxy a reason to tEND
+type message struct {
+	*packet.SignalingMessage
+	Token  string `json:"token,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
 type WebsocketClient struct {
 	sdpChan chan interface{}
 	iceChan chan interface{}
 
 	incoming, outcoming chan interface{}
 
-	done      bool
 	connected bool
-	stop      chan bool
+	token     string
+	url       string
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 func InitHttpClient(AddressStr string) (_ signalling.Signalling, err error) {
@@ -36,21 +51,25 @@ func InitHttpClient(AddressStr string) (_ signalling.Signalling, err error) {
 		outcoming: make(chan interface{}, 8),
 
 		connected: false,
-		done:      false,
-		stop:      make(chan bool, 2),
 	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
 
 	u, err := url.Parse(AddressStr)
 	if err != nil {
+		client.cancel()
 		return nil, err
 	} else {
+		// a reopened client keeps the id the server paired the viewer with
 		q := u.Query()
-		q.Add("uniqueid", uuid.New().String())
+		if q.Get("uniqueid") == "" {
+			q.Set("uniqueid", uuid.New().String())
+		}
 		u.RawQuery = q.Encode()
+		client.url = u.String()
 	}
 
-	thread.SafeSelect(client.stop, client.incoming, func(_res interface{}) {
-		res := _res.(*packet.SignalingMessage)
+	thread.SafeSelect(client.ctx, client.incoming, func(_res interface{}) {
+		res := _res.(*message)
 		switch res.Type {
 		case packet.SignalingType_tSDP:
 			client.sdpChan <- &webrtc.SessionDescription{
@@ -66,6 +85,7 @@ func InitHttpClient(AddressStr string) (_ signalling.Signalling, err error) {
 				SDPMLineIndex: &LineIndex,
 			}
 		case packet.SignalingType_tSTART:
+			client.token = res.Token
 			client.connected = true
 		case packet.SignalingType_tEND:
 			client.Stop()
@@ -74,34 +94,37 @@ func InitHttpClient(AddressStr string) (_ signalling.Signalling, err error) {
 		}
 	})
 
-	thread.SafeLoop(client.stop, time.Millisecond*300, func() {
+	thread.SafeLoop(client.ctx, time.Millisecond*300, func() {
 		pkt := []*packet.SignalingMessage{}
 		for len(client.outcoming) > 0 {
 			pkt = append(pkt, (<-client.outcoming).(*packet.SignalingMessage))
 		}
 
+		incoming := []*message{}
 		if b, err := json.Marshal(pkt); err != nil {
-		} else if resp, err := http.DefaultClient.Post(
-			u.String(), "application/json", strings.NewReader(string(b)),
+		} else if req, err := http.NewRequestWithContext(client.ctx,
+			http.MethodPost, u.String(), strings.NewReader(string(b)),
 		); err != nil {
-		} else if b, err := io.ReadAll(resp.Body); err != nil {
-		} else if err = json.Unmarshal(b, &pkt); err != nil {
+		} else if resp, err := http.DefaultClient.Do(req); err != nil {
+		} else if b, err := func() ([]byte, error) {
+			defer resp.Body.Close()
+			return io.ReadAll(resp.Body)
+		}(); err != nil {
+		} else if err = json.Unmarshal(b, &incoming); err != nil {
 		} else {
-			for _, sm := range pkt {
-				client.incoming <- sm
+			for _, sm := range incoming {
+				if sm.SignalingMessage != nil {
+					client.incoming <- sm
+				}
 			}
 		}
 	})
 
-	client.WaitForEnd(func() {
-		thread.TriggerStop(client.stop)
-	})
-
 	return client, nil
 }
 
 func (client *WebsocketClient) SendSDP(desc *webrtc.SessionDescription) {
-	thread.SafeWait(func() bool {
+	thread.SafeWait(client.ctx, func() bool {
 		return client.connected
 	}, func() {
 		client.outcoming <- &packet.SignalingMessage{
@@ -115,7 +138,7 @@ func (client *WebsocketClient) SendSDP(desc *webrtc.SessionDescription) {
 }
 
 func (client *WebsocketClient) SendICE(ice *webrtc.ICECandidateInit) {
-	thread.SafeWait(func() bool {
+	thread.SafeWait(client.ctx, func() bool {
 		return client.connected
 	}, func() {
 		client.outcoming <- &packet.SignalingMessage{
@@ -130,26 +153,62 @@ func (client *WebsocketClient) SendICE(ice *webrtc.ICECandidateInit) {
 }
 
 func (client *WebsocketClient) OnICE(fun signalling.OnIceFunc) {
-	thread.SafeSelect(client.stop, client.iceChan, func(ice interface{}) {
+	thread.SafeSelect(client.ctx, client.iceChan, func(ice interface{}) {
 		fun(ice.(*webrtc.ICECandidateInit))
 	})
 }
 
 func (client *WebsocketClient) OnSDP(fun signalling.OnSDPFunc) {
-	thread.SafeSelect(client.stop, client.sdpChan, func(sdp interface{}) {
+	thread.SafeSelect(client.ctx, client.sdpChan, func(sdp interface{}) {
 		fun(sdp.(*webrtc.SessionDescription))
 	})
 }
 
 func (client *WebsocketClient) WaitForStart(fun func()) {
-	thread.SafeWait(func() bool { return client.connected }, fun)
+	thread.SafeWait(client.ctx, func() bool { return client.connected }, fun)
 }
 
 func (client *WebsocketClient) WaitForEnd(fun func()) {
-	thread.SafeWait(func() bool { return client.done }, fun)
+	thread.OnDone(client.ctx, fun)
+}
+
+func (client *WebsocketClient) Token() string {
+	return client.token
+}
+
+// Reopen polls the same pairing again, it is started right away as the
+// viewer presented its token already
+func (client *WebsocketClient) Reopen() (signalling.Signalling, error) {
+	reopened, err := InitHttpClient(client.url)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := reopened.(*WebsocketClient)
+	ret.token, ret.connected = client.token, true
+	return ret, nil
+}
+
+func (client *WebsocketClient) End(reason string) {
+	defer client.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), end_timeout)
+	defer cancel()
+	if b, err := json.Marshal([]*message{{
+		SignalingMessage: &packet.SignalingMessage{Type: packet.SignalingType_tEND},
+		Reason:           reason,
+	}}); err != nil {
+	} else if req, err := http.NewRequestWithContext(ctx,
+		http.MethodPost, client.url, strings.NewReader(string(b)),
+	); err != nil {
+	} else if resp, err := http.DefaultClient.Do(req); err != nil {
+		fmt.Printf("failed to send end reason %s\n", err.Error())
+	} else {
+		resp.Body.Close()
+	}
 }
 
 func (client *WebsocketClient) Stop() {
 	client.connected = false
-	client.done = true
+	client.cancel()
 }
@@ -11,6 +11,14 @@ type DeviceSelection struct {
 	Framerate int    `json:"framerate"`
 }
 
+// reasons sent with tEND when the proxy closes a session
+const (
+	ReasonIdle        = "idle"
+	ReasonMaxDuration = "max-duration"
+	ReasonNoReceiver  = "no-receiver"
+	ReasonExpired     = "expired"
+)
+
 type OnIceFunc func(*webrtc.ICECandidateInit)
 type OnSDPFunc func(*webrtc.SessionDescription)
 
@@ -24,5 +32,16 @@ type Signalling interface {
 	WaitForStart(func())
 	WaitForEnd(func())
 
+	// Token is the credential the viewer presented on start
+	Token() string
+
+	// Reopen connects again to the same viewer once the exchange ended,
+	// so a resumed session can send its restart offer
+	Reopen() (Signalling, error)
+
+	// End tells the server why the session closes while the exchange
+	// runs, then stops
+	End(reason string)
+
 	Stop()
 }
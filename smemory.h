@@ -73,4 +73,73 @@ typedef struct _Queue{
 
 typedef struct {
     Queue queues[QueueMax];
-}SharedMemory;
\ No newline at end of file
+}SharedMemory;
+
+// Everything below lives in the extension region right after
+// SharedMemory. Writers that predate it do not allocate it, readers find
+// its size through shared_memory_size and check the header before use.
+// Fields are only ever appended, together with a version bump
+
+#define EXTENSION_VERSION 1
+
+typedef struct {
+    // version of the layout the writer knows, zero when not set up
+    int version;
+    // size in bytes of the extension as the writer laid it out
+    int size;
+} ExtensionHeader;
+
+// HdrMetadata is the static metadata of a queue streaming HDR,
+// chromaticities in 0.00002 units
+typedef struct {
+    // red, green, blue
+    struct { unsigned short x, y; } displayPrimaries[3];
+    struct { unsigned short x, y; } whitePoint;
+    // nits
+    unsigned short maxDisplayLuminance;
+    // 0.0001 nits
+    unsigned short minDisplayLuminance;
+    unsigned short maxContentLightLevel;
+    unsigned short maxFrameAverageLightLevel;
+    unsigned short maxFullFrameLuminance;
+} HdrMetadata;
+
+typedef struct {
+    // capture time in unix nanoseconds, zero when unknown
+    long long timestamp;
+    // encoder timings in unix nanoseconds, zero when unknown
+    long long encode_start;
+    long long encode_finish;
+} PacketTiming;
+
+typedef struct {
+    // audio channels the encoder writes, zero when unknown
+    int channels;
+    // hdr is set while the queue streams HDR described by hdr_metadata
+    int hdr;
+    HdrMetadata hdr_metadata;
+    // timings of the packet in the same slot of Queue.array
+    PacketTiming timings[QUEUE_SIZE];
+} QueueExtension;
+
+#define CURSOR_SIZE 256 * 256 * 4
+
+typedef struct {
+    // serial is odd while the writer updates the region
+    int serial;
+    int visible;
+    // hotspot position in desktop coordinates
+    int x, y;
+    // shape changes whenever the bitmap does
+    int shape;
+    int width, height;
+    int hotspot_x, hotspot_y;
+    // rgba rows of width pixels
+    char bitmap[CURSOR_SIZE];
+} Cursor;
+
+typedef struct {
+    ExtensionHeader header;
+    QueueExtension queues[QueueMax];
+    Cursor cursor;
+} SharedMemoryExtension;
\ No newline at end of file
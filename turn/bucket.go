@@ -0,0 +1,33 @@
+package turn
+
+import "time"
+
+// bucket admits bytes at rate per second with a burst of one second,
+// a nil bucket admits everything
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate int64, now time.Time) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &bucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+func (b *bucket) allow(size int, now time.Time) bool {
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < float64(size) {
+		return false
+	}
+
+	b.tokens -= float64(size)
+	return true
+}
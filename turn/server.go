@@ -0,0 +1,249 @@
+package turn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	pion "github.com/pion/turn/v4"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+const (
+	secret_size = 32
+
+	// stun message types of send and data indications, the only stun
+	// messages carrying relayed payload
+	send_indication = 0x0016
+	data_indication = 0x0017
+)
+
+type Usage struct {
+	Bytes   int64
+	Dropped int64
+}
+
+type session struct {
+	usage   Usage
+	bucket  *bucket
+	expires time.Time
+}
+
+// Server is an embedded TURN server handing out TURN REST credentials,
+// the username is "<expiry unix>:<session>" and the password is the
+// base64 HMAC-SHA1 of the username keyed by the shared secret
+type Server struct {
+	conf   config.TurnConfig
+	secret string
+	server *pion.Server
+	addr   net.Addr
+
+	mut *sync.Mutex
+	// sessions are keyed by session id, credentials issued again for the
+	// same session keep its usage and quota
+	sessions map[string]*session
+	// clients maps the addresses that authenticated to their session id
+	clients map[string]string
+
+	now func() time.Time
+}
+
+func NewServer(conf config.TurnConfig) (*Server, error) {
+	s := &Server{
+		conf:     conf,
+		secret:   conf.Secret,
+		mut:      &sync.Mutex{},
+		sessions: map[string]*session{},
+		clients:  map[string]string{},
+		now:      time.Now,
+	}
+	if s.secret == "" {
+		random := make([]byte, secret_size)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		s.secret = hex.EncodeToString(random)
+	}
+
+	conn, err := net.ListenPacket("udp4", conf.Listen)
+	if err != nil {
+		return nil, err
+	}
+	s.addr = conn.LocalAddr()
+
+	public := net.ParseIP(conf.PublicIP)
+	var generator pion.RelayAddressGenerator = &pion.RelayAddressGeneratorStatic{
+		RelayAddress: public,
+		Address:      "0.0.0.0",
+	}
+	if conf.PortMin != 0 {
+		generator = &pion.RelayAddressGeneratorPortRange{
+			RelayAddress: public,
+			Address:      "0.0.0.0",
+			MinPort:      conf.PortMin,
+			MaxPort:      conf.PortMax,
+		}
+	}
+
+	auth := pion.LongTermTURNRESTAuthHandler(s.secret, nil)
+	if s.server, err = pion.NewServer(pion.ServerConfig{
+		Realm: conf.Realm,
+		AuthHandler: func(username, realm string, addr net.Addr) ([]byte, bool) {
+			key, ok := auth(username, realm, addr)
+			if ok {
+				_, id, _ := strings.Cut(username, ":")
+				s.mut.Lock()
+				s.clients[addr.String()] = id
+				s.mut.Unlock()
+			}
+			return key, ok
+		},
+		PacketConnConfigs: []pion.PacketConnConfig{{
+			PacketConn:            &quotaConn{PacketConn: conn, server: s},
+			RelayAddressGenerator: generator,
+		}},
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// URL is the address sessions reach the server at
+func (s *Server) URL() string {
+	_, port, _ := net.SplitHostPort(s.addr.String())
+	return fmt.Sprintf("turn:%s?transport=udp", net.JoinHostPort(s.conf.PublicIP, port))
+}
+
+// Credentials issues an ICE server entry valid for the configured ttl,
+// relayed traffic of the session is metered against its bandwidth until
+// its last credentials expire
+func (s *Server) Credentials(id string) (webrtc.ICEServer, error) {
+	username, password, err := pion.GenerateLongTermTURNRESTCredentials(s.secret, id, s.conf.TTL)
+	if err != nil {
+		return webrtc.ICEServer{}, err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.prune()
+	if existing, found := s.sessions[id]; found {
+		existing.expires = s.now().Add(s.conf.TTL)
+	} else {
+		s.sessions[id] = &session{
+			bucket:  newBucket(s.conf.Bandwidth/8, s.now()),
+			expires: s.now().Add(s.conf.TTL),
+		}
+	}
+
+	return webrtc.ICEServer{
+		URLs:       []string{s.URL()},
+		Username:   username,
+		Credential: password,
+	}, nil
+}
+
+// Inject returns a copy of conf with fresh credentials for the session
+// appended to its ICE servers
+func (s *Server) Inject(conf config.WebRTCConfig, id string) (config.WebRTCConfig, error) {
+	server, err := s.Credentials(id)
+	if err != nil {
+		return conf, err
+	}
+
+	conf.Ices = append(append([]webrtc.ICEServer{}, conf.Ices...), server)
+	return conf, nil
+}
+
+// Usage reports relayed and dropped bytes per session id
+func (s *Server) Usage() map[string]Usage {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	ret := map[string]Usage{}
+	for id, session := range s.sessions {
+		ret[id] = session.usage
+	}
+	return ret
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// prune forgets sessions whose last credentials expired, callers hold mut
+func (s *Server) prune() {
+	now := s.now()
+	for id, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	for addr, id := range s.clients {
+		if _, found := s.sessions[id]; !found {
+			delete(s.clients, addr)
+		}
+	}
+}
+
+// allow meters relayed payload exchanged with addr, turn signalling
+// always passes so allocations are refreshed even when over quota.
+// Allocations outliving the credentials of their session relay nothing
+func (s *Server) allow(addr net.Addr, packet []byte) bool {
+	if !relayed(packet) {
+		return true
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	session, found := s.sessions[s.clients[addr.String()]]
+	if !found || s.now().After(session.expires) {
+		return false
+	} else if session.bucket != nil && !session.bucket.allow(len(packet), s.now()) {
+		session.usage.Dropped += int64(len(packet))
+		return false
+	}
+
+	session.usage.Bytes += int64(len(packet))
+	return true
+}
+
+// relayed reports channel data and send or data indications
+func relayed(packet []byte) bool {
+	if len(packet) < 4 {
+		return false
+	} else if packet[0]&0xC0 == 0x40 {
+		return true
+	}
+
+	kind := binary.BigEndian.Uint16(packet)
+	return kind == send_indication || kind == data_indication
+}
+
+// quotaConn is the client facing socket, packets of sessions over their
+// bandwidth are dropped in both directions
+type quotaConn struct {
+	net.PacketConn
+	server *Server
+}
+
+func (conn *quotaConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	for {
+		if n, addr, err = conn.PacketConn.ReadFrom(p); err != nil || conn.server.allow(addr, p[:n]) {
+			return
+		}
+	}
+}
+
+func (conn *quotaConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !conn.server.allow(addr, p) {
+		return len(p), nil
+	}
+	return conn.PacketConn.WriteTo(p, addr)
+}
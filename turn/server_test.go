@@ -0,0 +1,157 @@
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pion "github.com/pion/turn/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBucket(1000, now)
+	require.True(t, b.allow(600, now))
+	require.False(t, b.allow(600, now))
+	require.True(t, b.allow(600, now.Add(time.Millisecond*200)))
+	require.False(t, b.allow(1001, now.Add(time.Hour)))
+	require.Nil(t, newBucket(0, now))
+}
+
+func TestRelayed(t *testing.T) {
+	require.True(t, relayed([]byte{0x40, 0x00, 0x00, 0x04}))
+	require.True(t, relayed([]byte{0x00, 0x16, 0x00, 0x00}))
+	require.True(t, relayed([]byte{0x00, 0x17, 0x00, 0x00}))
+	require.False(t, relayed([]byte{0x00, 0x03, 0x00, 0x00})) // allocate
+	require.False(t, relayed([]byte{0x00, 0x04, 0x00, 0x00})) // refresh
+}
+
+func newServer(t *testing.T, bandwidth int64) *Server {
+	server, err := NewServer(config.TurnConfig{
+		Listen:    "127.0.0.1:0",
+		PublicIP:  "127.0.0.1",
+		Realm:     "rtchub",
+		TTL:       time.Minute,
+		Bandwidth: bandwidth,
+	})
+	require.Nil(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// relay allocates through the server with credentials of session id and
+// sends count packets of size bytes to a local peer, it returns how many
+// the peer received
+func relay(t *testing.T, server *Server, id string, count, size int) int {
+	creds, err := server.Credentials(id)
+	require.Nil(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer conn.Close()
+	client, err := pion.NewClient(&pion.ClientConfig{
+		TURNServerAddr: server.addr.String(),
+		STUNServerAddr: server.addr.String(),
+		Username:       creds.Username,
+		Password:       creds.Credential.(string),
+		Realm:          "rtchub",
+		Conn:           conn,
+	})
+	require.Nil(t, err)
+	defer client.Close()
+	require.Nil(t, client.Listen())
+	allocation, err := client.Allocate()
+	require.Nil(t, err)
+	defer allocation.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer peer.Close()
+
+	received := 0
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for {
+			peer.SetReadDeadline(time.Now().Add(time.Millisecond * 500))
+			if _, _, err := peer.ReadFrom(buf); err != nil {
+				return
+			}
+			received++
+		}
+	}()
+
+	payload := make([]byte, size)
+	for i := 0; i < count; i++ {
+		_, err := allocation.WriteTo(payload, peer.LocalAddr())
+		require.Nil(t, err)
+	}
+	<-done
+	return received
+}
+
+func TestCredentials(t *testing.T) {
+	server := newServer(t, 0)
+	creds, err := server.Credentials("session")
+	require.Nil(t, err)
+	require.Equal(t, server.URL(), creds.URLs[0])
+
+	auth := pion.LongTermTURNRESTAuthHandler(server.secret, nil)
+	_, ok := auth(creds.Username, "rtchub", nil)
+	require.True(t, ok)
+
+	expired, _, err := pion.GenerateLongTermTURNRESTCredentials(server.secret, "session", -time.Minute)
+	require.Nil(t, err)
+	_, ok = auth(expired, "rtchub", nil)
+	require.False(t, ok)
+
+	conf, err := server.Inject(config.WebRTCConfig{}, "other")
+	require.Nil(t, err)
+	require.Len(t, conf.Ices, 1)
+	require.Contains(t, conf.Ices[0].Username, ":other")
+}
+
+func TestQuota(t *testing.T) {
+	unlimited := newServer(t, 0)
+	require.Equal(t, 50, relay(t, unlimited, "free", 50, 1000))
+	require.Equal(t, int64(0), unlimited.Usage()["free"].Dropped)
+	require.GreaterOrEqual(t, unlimited.Usage()["free"].Bytes, int64(50*1000))
+
+	// 80kbit/s admits a burst of 10kB, well short of 50 packets
+	limited := newServer(t, 80_000)
+	received := relay(t, limited, "capped", 50, 1000)
+	require.Less(t, received, 15)
+	require.Greater(t, limited.Usage()["capped"].Dropped, int64(0))
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	server := newServer(t, 80_000)
+	server.now = func() time.Time { return now }
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+	data := make([]byte, 1000)
+	data[0] = 0x40
+
+	_, err := server.Credentials("session")
+	require.Nil(t, err)
+	server.clients[client.String()] = "session"
+	for range 20 {
+		server.allow(client, data)
+	}
+	used := server.Usage()["session"]
+	require.Greater(t, used.Dropped, int64(0))
+
+	// credentials issued again for the session keep its usage
+	now = now.Add(time.Second * 30)
+	_, err = server.Credentials("session")
+	require.Nil(t, err)
+	require.Equal(t, used, server.Usage()["session"])
+
+	// nothing is relayed once the last credentials expired
+	now = now.Add(time.Minute * 2)
+	require.False(t, server.allow(client, data))
+	require.False(t, server.allow(client, data))
+}
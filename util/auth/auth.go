@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+)
+
+var (
+	ErrMissing   = errors.New("no session token presented")
+	ErrMalformed = errors.New("malformed session token")
+	ErrSignature = errors.New("invalid session token signature")
+	ErrExpired   = errors.New("session token expired")
+	ErrNotYet    = errors.New("session token not valid yet")
+
+	encoding = base64.RawURLEncoding
+	// jwt_header is the only header accepted, alg none and asymmetric
+	// algorithms are refused
+	jwt_header = header{Algorithm: "HS256", Type: "JWT"}
+)
+
+// Claims describe a session, Capabilities lists what it may do beyond
+// viewing, any of input, gamepad, clipboard, file and control
+type Claims struct {
+	Subject      string   `json:"sub,omitempty"`
+	Expiry       int64    `json:"exp"`
+	NotBefore    int64    `json:"nbf,omitempty"`
+	Capabilities []string `json:"caps,omitempty"`
+}
+
+func (claims *Claims) Permissions() *datachannel.Permissions {
+	caps := []datachannel.Capability{}
+	for _, c := range claims.Capabilities {
+		caps = append(caps, datachannel.Capability(c))
+	}
+	return datachannel.NewPermissions(caps...)
+}
+
+// ExpiresAt is the end of the session, zero when it never expires
+func (claims *Claims) ExpiresAt() time.Time {
+	if claims.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expiry, 0)
+}
+
+// Verifier checks the token a viewer presents when signalling starts
+type Verifier interface {
+	Verify(token string) (*Claims, error)
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// New builds the verifier of scheme, none trusts every viewer with full
+// control, hmac and jwt check HS256 signatures keyed by secret
+func New(scheme, secret string, leeway time.Duration) (Verifier, error) {
+	switch scheme {
+	case "", "none":
+		return open{}, nil
+	case "hmac":
+		return &signed{secret: []byte(secret), leeway: leeway}, nil
+	case "jwt":
+		return &signed{secret: []byte(secret), leeway: leeway, jwt: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth scheme %s", scheme)
+	}
+}
+
+type open struct{}
+
+func (open) Verify(string) (*Claims, error) {
+	return &Claims{Capabilities: []string{
+		string(datachannel.CapInput),
+		string(datachannel.CapGamepad),
+		string(datachannel.CapClipboard),
+		string(datachannel.CapFile),
+		string(datachannel.CapControl),
+	}}, nil
+}
+
+// signed verifies "<claims>.<signature>" hmac tokens, or compact
+// "<header>.<claims>.<signature>" jwts, every part base64url encoded
+type signed struct {
+	secret []byte
+	leeway time.Duration
+	jwt    bool
+	now    func() time.Time
+}
+
+func (s *signed) Verify(token string) (*Claims, error) {
+	if token == "" {
+		return nil, ErrMissing
+	}
+
+	parts := strings.Split(token, ".")
+	if s.jwt && len(parts) == 3 {
+		hdr := header{}
+		if err := decode(parts[0], &hdr); err != nil {
+			return nil, err
+		} else if hdr.Algorithm != jwt_header.Algorithm {
+			return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrMalformed, hdr.Algorithm)
+		}
+	} else if s.jwt || len(parts) != 2 {
+		return nil, ErrMalformed
+	}
+
+	payload := strings.Join(parts[:len(parts)-1], ".")
+	signature, err := encoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return nil, ErrMalformed
+	} else if !hmac.Equal(signature, sign(s.secret, payload)) {
+		return nil, ErrSignature
+	}
+
+	claims := &Claims{}
+	if err := decode(parts[len(parts)-2], claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	if claims.Expiry == 0 {
+		return nil, fmt.Errorf("%w: no expiry", ErrMalformed)
+	} else if now.Add(-s.leeway).After(claims.ExpiresAt()) {
+		return nil, ErrExpired
+	} else if claims.NotBefore != 0 && now.Add(s.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrNotYet
+	}
+
+	return claims, nil
+}
+
+// Sign issues a token for claims under scheme, for tooling and tests
+func Sign(scheme, secret string, claims Claims) (string, error) {
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := encoding.EncodeToString(body)
+	switch scheme {
+	case "hmac":
+	case "jwt":
+		hdr, _ := json.Marshal(jwt_header)
+		payload = encoding.EncodeToString(hdr) + "." + payload
+	default:
+		return "", fmt.Errorf("unknown auth scheme %s", scheme)
+	}
+
+	return payload + "." + encoding.EncodeToString(sign([]byte(secret), payload)), nil
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func decode(part string, out interface{}) error {
+	data, err := encoding.DecodeString(part)
+	if err != nil {
+		return ErrMalformed
+	} else if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return nil
+}
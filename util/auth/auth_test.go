@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func TestRoundTrip(t *testing.T) {
+	for _, scheme := range []string{"hmac", "jwt"} {
+		verifier, err := New(scheme, secret, 0)
+		require.Nil(t, err)
+
+		token, err := Sign(scheme, secret, Claims{
+			Subject:      "viewer",
+			Expiry:       time.Now().Add(time.Minute).Unix(),
+			Capabilities: []string{"input", "clipboard"},
+		})
+		require.Nil(t, err)
+		require.Equal(t, map[string]int{"hmac": 1, "jwt": 2}[scheme], strings.Count(token, "."))
+
+		claims, err := verifier.Verify(token)
+		require.Nil(t, err, scheme)
+		require.Equal(t, "viewer", claims.Subject)
+		perms := claims.Permissions()
+		require.True(t, perms.Allows(datachannel.CapInput))
+		require.True(t, perms.Allows(datachannel.CapClipboard))
+		require.False(t, perms.Allows(datachannel.CapGamepad))
+		require.False(t, perms.Allows(datachannel.CapFile))
+
+		_, err = verifier.Verify(token[:len(token)-2] + "AA")
+		require.ErrorIs(t, err, ErrSignature)
+		other, _ := New(scheme, "another secret of enough length", 0)
+		_, err = other.Verify(token)
+		require.ErrorIs(t, err, ErrSignature)
+		_, err = verifier.Verify("")
+		require.ErrorIs(t, err, ErrMissing)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	verifier := &signed{secret: []byte(secret), leeway: time.Second * 30, jwt: true, now: func() time.Time { return now }}
+
+	token, _ := Sign("jwt", secret, Claims{Expiry: now.Add(-time.Second * 10).Unix()})
+	_, err := verifier.Verify(token)
+	require.Nil(t, err, "within leeway")
+
+	token, _ = Sign("jwt", secret, Claims{Expiry: now.Add(-time.Minute).Unix()})
+	_, err = verifier.Verify(token)
+	require.ErrorIs(t, err, ErrExpired)
+
+	token, _ = Sign("jwt", secret, Claims{Expiry: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()})
+	_, err = verifier.Verify(token)
+	require.ErrorIs(t, err, ErrNotYet)
+
+	token, _ = Sign("jwt", secret, Claims{})
+	_, err = verifier.Verify(token)
+	require.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestRejectAlgorithm(t *testing.T) {
+	verifier, _ := New("jwt", secret, 0)
+	// {"alg":"none","typ":"JWT"}
+	_, err := verifier.Verify("eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0.eyJleHAiOjk5OTk5OTk5OTl9.")
+	require.ErrorIs(t, err, ErrMalformed)
+
+	hmac, _ := New("hmac", secret, 0)
+	jwt, _ := Sign("jwt", secret, Claims{Expiry: time.Now().Add(time.Minute).Unix()})
+	_, err = hmac.Verify(jwt)
+	require.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestOpen(t *testing.T) {
+	verifier, err := New("none", "", 0)
+	require.Nil(t, err)
+	claims, err := verifier.Verify("")
+	require.Nil(t, err)
+	require.True(t, claims.ExpiresAt().IsZero())
+	require.True(t, claims.Permissions().Allows(datachannel.CapFile))
+
+	_, err = New("basic", "", 0)
+	require.NotNil(t, err)
+}
@@ -1,11 +1,124 @@
 package config
 
 import (
+	"time"
+
 	"github.com/pion/webrtc/v4"
 )
 
 type WebRTCConfig struct {
-	Ices []webrtc.ICEServer `json:"iceServers"`
+	Ices []webrtc.ICEServer `json:"iceServers" yaml:"iceServers"`
+
+	// ResumeTimeout is how long a disconnected session keeps attempting
+	// ICE restarts before it is torn down, zero tears down right away
+	ResumeTimeout time.Duration `json:"resumeTimeout" yaml:"resumeTimeout"`
+
+	Network NetworkConfig `json:"network" yaml:"network"`
+
+	Policy PolicyConfig `json:"policy" yaml:"policy"`
+
+	Extensions ExtensionConfig `json:"extensions" yaml:"extensions"`
+
+	Pacer PacerConfig `json:"pacer" yaml:"pacer"`
+
+	Opus OpusConfig `json:"opus" yaml:"opus"`
+}
+
+// OpusConfig shapes the audio track, its fmtp parameters tell the viewer
+// how the encoder is set up
+type OpusConfig struct {
+	// Channels is what the encoder writes, 1, 2, 6 or 8, zero follows the
+	// audio queue. Above two the track is multiopus, viewers without it
+	// get the front pair
+	Channels int `json:"channels" yaml:"channels"`
+	// Stereo signals stereo and sprop-stereo on two channel tracks
+	Stereo    bool `json:"stereo" yaml:"stereo"`
+	InbandFEC bool `json:"inbandFec" yaml:"inbandFec"`
+	DTX       bool `json:"dtx" yaml:"dtx"`
+	// MaxAverageBitrate is in bits per second, zero leaves it unsignalled
+	MaxAverageBitrate int `json:"maxAverageBitrate" yaml:"maxAverageBitrate"`
+}
+
+// PacerConfig spreads bursts of packets such as keyframes over time, a
+// zero Bitrate sends packets as soon as they are produced
+type PacerConfig struct {
+	// Bitrate is the pacing rate in bits per second, it has to stay well
+	// above the encoder bitrate so a frame drains before the next one
+	Bitrate int64 `json:"bitrate" yaml:"bitrate"`
+	// Burst is how much may leave back to back, as a duration at Bitrate
+	Burst time.Duration `json:"burst" yaml:"burst"`
+	// MaxDelay bounds how long a packet waits before it is sent regardless
+	MaxDelay time.Duration `json:"maxDelay" yaml:"maxDelay"`
+	// Estimate lowers the rate to follow the viewer bandwidth estimate
+	Estimate bool `json:"estimate" yaml:"estimate"`
+}
+
+// ExtensionConfig tunes the low latency RTP header extensions, each one
+// is only written once the viewer negotiated it
+type ExtensionConfig struct {
+	// PlayoutDelay asks the browser to render video between MinDelay and
+	// MaxDelay after capture, in steps of 10ms. Zero for both keeps its
+	// jitter buffer empty
+	PlayoutDelay bool          `json:"playoutDelay" yaml:"playoutDelay"`
+	MinDelay     time.Duration `json:"minDelay" yaml:"minDelay"`
+	MaxDelay     time.Duration `json:"maxDelay" yaml:"maxDelay"`
+
+	// VideoTiming is how often a frame carries its encode and send
+	// timings, zero disables
+	VideoTiming time.Duration `json:"videoTiming" yaml:"videoTiming"`
+}
+
+// PolicyConfig closes sessions that idle or run too long, a zero limit
+// is disabled
+type PolicyConfig struct {
+	// IdleTimeout closes sessions once the host saw no input for this long
+	IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	// MaxDuration closes sessions running for this long
+	MaxDuration time.Duration `json:"maxDuration" yaml:"maxDuration"`
+	// ReportTimeout closes sessions whose viewer sent no RTCP receiver
+	// report for this long, it stopped playing media
+	ReportTimeout time.Duration `json:"reportTimeout" yaml:"reportTimeout"`
+
+	// Warning is how long before an idle or max duration close the
+	// viewer is warned on the Channel datachannel
+	Warning time.Duration `json:"warning" yaml:"warning"`
+	Channel string        `json:"channel" yaml:"channel"`
+}
+
+// NetworkConfig tunes ICE gathering, zero values keep pion defaults
+type NetworkConfig struct {
+	// PortMin and PortMax bound the ephemeral udp ports of host candidates
+	PortMin uint16 `json:"portMin" yaml:"portMin"`
+	PortMax uint16 `json:"portMax" yaml:"portMax"`
+
+	// UDPPort and TCPPort serve every session from a single port,
+	// TCPPort also enables passive ICE-TCP candidates
+	UDPPort int `json:"udpPort" yaml:"udpPort"`
+	TCPPort int `json:"tcpPort" yaml:"tcpPort"`
+
+	// NAT1To1IPs are advertised in place of local addresses, as host
+	// candidates or as additional srflx candidates depending on NAT1To1Type
+	NAT1To1IPs  []string `json:"nat1To1IPs" yaml:"nat1To1IPs"`
+	NAT1To1Type string   `json:"nat1To1Type" yaml:"nat1To1Type"`
+
+	// NetworkTypes picks from udp4, udp6, tcp4 and tcp6
+	NetworkTypes []string `json:"networkTypes" yaml:"networkTypes"`
+
+	// Interfaces and IPs restrict gathering to the listed interface
+	// names and to addresses inside the listed ips or cidrs
+	Interfaces []string `json:"interfaces" yaml:"interfaces"`
+	IPs        []string `json:"ips" yaml:"ips"`
+
+	// Loopback also gathers candidates on loopback addresses, for
+	// clients running on the same host
+	Loopback bool `json:"loopback" yaml:"loopback"`
+
+	// MDNS is disabled, query or gather
+	MDNS string `json:"mdns" yaml:"mdns"`
+
+	DisconnectedTimeout time.Duration `json:"disconnectedTimeout" yaml:"disconnectedTimeout"`
+	FailedTimeout       time.Duration `json:"failedTimeout" yaml:"failedTimeout"`
+	KeepAliveInterval   time.Duration `json:"keepAliveInterval" yaml:"keepAliveInterval"`
 }
 
 type WebsocketConfig struct {
@@ -0,0 +1,787 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel/clipboard"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	env_prefix = "RTCHUB_"
+
+	// max_playout_delay is the largest delay the 12 bit playout-delay
+	// fields carry in 10ms units
+	max_playout_delay = 4095 * 10 * time.Millisecond
+
+	// min_opus_bitrate and max_opus_bitrate bound maxaveragebitrate as
+	// RFC 7587 does
+	min_opus_bitrate = 6000
+	max_opus_bitrate = 510000
+)
+
+var (
+	video_codecs = map[string]string{
+		"h264": webrtc.MimeTypeH264,
+		"h265": webrtc.MimeTypeH265,
+		"av1":  webrtc.MimeTypeAV1,
+		"vp8":  webrtc.MimeTypeVP8,
+		"vp9":  webrtc.MimeTypeVP9,
+	}
+	audio_codecs = map[string]string{
+		"opus": webrtc.MimeTypeOpus,
+	}
+	opus_channels  = []int{0, 1, 2, 6, 8}
+	overflows      = []string{"drop-newest", "drop-oldest", "coalesce"}
+	clipboards     = []string{"both", "inbound", "outbound", "none"}
+	nat_types      = []string{"host", "srflx"}
+	network_types  = []string{"udp4", "udp6", "tcp4", "tcp6"}
+	mdns_modes     = []string{"disabled", "query", "gather"}
+	auth_schemes   = []string{"none", "hmac", "jwt"}
+	log_levels     = []string{"disabled", "error", "warn", "info", "debug", "trace"}
+	ice_schemes    = []string{"stun:", "stuns:", "turn:", "turns:"}
+	signal_schemes = []string{"http", "https"}
+)
+
+type SignallingConfig struct {
+	Video  string `json:"video" yaml:"video"`
+	Audio  string `json:"audio" yaml:"audio"`
+	Bundle string `json:"bundle" yaml:"bundle"`
+}
+
+type CodecConfig struct {
+	Video string `json:"video" yaml:"video"`
+	Audio string `json:"audio" yaml:"audio"`
+	// Simulcast publishes both video queues as the high and low encoding
+	// of one display, each viewer is sent the one its bandwidth fits.
+	// Queue 1 then holds no second display, so simulcast and display
+	// switching are mutually exclusive
+	Simulcast bool `json:"simulcast" yaml:"simulcast"`
+}
+
+func (codec CodecConfig) VideoMimeType() string { return video_codecs[codec.Video] }
+func (codec CodecConfig) AudioMimeType() string { return audio_codecs[codec.Audio] }
+
+type DatachannelConfig struct {
+	Name              string  `json:"name" yaml:"name"`
+	Unordered         bool    `json:"unordered" yaml:"unordered"`
+	MaxRetransmits    *uint16 `json:"maxRetransmits" yaml:"maxRetransmits"`
+	MaxPacketLifeTime *uint16 `json:"maxPacketLifeTime" yaml:"maxPacketLifeTime"`
+	ID                *uint16 `json:"id" yaml:"id"`
+	Protocol          string  `json:"protocol" yaml:"protocol"`
+	QueueSize         int     `json:"queueSize" yaml:"queueSize"`
+	Overflow          string  `json:"overflow" yaml:"overflow"`
+}
+
+// TurnConfig runs an embedded TURN server when Listen is set, sessions
+// receive ephemeral TURN REST credentials for it
+type TurnConfig struct {
+	Listen   string `json:"listen" yaml:"listen"`
+	PublicIP string `json:"publicIP" yaml:"publicIP"`
+	Realm    string `json:"realm" yaml:"realm"`
+
+	// Secret signs the credentials, a random one is generated when empty
+	Secret string        `json:"secret" yaml:"secret"`
+	TTL    time.Duration `json:"ttl" yaml:"ttl"`
+
+	// PortMin and PortMax bound the relay ports, zero picks any port
+	PortMin uint16 `json:"portMin" yaml:"portMin"`
+	PortMax uint16 `json:"portMax" yaml:"portMax"`
+
+	// Bandwidth caps the relayed bits per second of each session,
+	// zero leaves sessions unlimited
+	Bandwidth int64 `json:"bandwidth" yaml:"bandwidth"`
+}
+
+// SessionAuthConfig verifies the token viewers present on signalling
+// start, scheme none grants every session full control
+type SessionAuthConfig struct {
+	Scheme string        `json:"scheme" yaml:"scheme"`
+	Secret string        `json:"secret" yaml:"secret"`
+	Leeway time.Duration `json:"leeway" yaml:"leeway"`
+}
+
+// AdminConfig serves the admin api on a loopback address when Listen is
+// set, requests carry Token as a bearer credential
+type AdminConfig struct {
+	Listen string `json:"listen" yaml:"listen"`
+	Token  string `json:"token" yaml:"token"`
+}
+
+type LimitConfig struct {
+	ClipboardSize   int   `json:"clipboardSize" yaml:"clipboardSize"`
+	FileSize        int64 `json:"fileSize" yaml:"fileSize"`
+	FileConcurrency int   `json:"fileConcurrency" yaml:"fileConcurrency"`
+}
+
+type LogConfig struct {
+	Level string `json:"level" yaml:"level"`
+	File  string `json:"file" yaml:"file"`
+}
+
+// Config is everything cmd/main.go needs to start, loaded by Load
+type Config struct {
+	Token        string              `json:"token" yaml:"token"`
+	Auth         SessionAuthConfig   `json:"auth" yaml:"auth"`
+	Admin        AdminConfig         `json:"admin" yaml:"admin"`
+	VideoChannel int                 `json:"videoChannel" yaml:"videoChannel"`
+	Signalling   SignallingConfig    `json:"signalling" yaml:"signalling"`
+	WebRTC       WebRTCConfig        `json:"webrtc" yaml:"webrtc"`
+	Turn         TurnConfig          `json:"turn" yaml:"turn"`
+	Codecs       CodecConfig         `json:"codecs" yaml:"codecs"`
+	Datachannels []DatachannelConfig `json:"datachannels" yaml:"datachannels"`
+	Clipboard    string              `json:"clipboard" yaml:"clipboard"`
+	UnicodeInput bool                `json:"unicodeInput" yaml:"unicodeInput"`
+	FileDir      string              `json:"fileDirectory" yaml:"fileDirectory"`
+	Limits       LimitConfig         `json:"limits" yaml:"limits"`
+	Logging      LogConfig           `json:"logging" yaml:"logging"`
+}
+
+func Default() *Config {
+	no_retransmit := uint16(0)
+	return &Config{
+		Signalling: SignallingConfig{
+			Video: "http://localhost:60000/handshake/server?token=video",
+			Audio: "http://localhost:60000/handshake/server?token=audio",
+		},
+		WebRTC: WebRTCConfig{
+			Ices:          []webrtc.ICEServer{},
+			ResumeTimeout: time.Second * 15,
+			Policy: PolicyConfig{
+				Warning: time.Minute,
+				Channel: "hid",
+			},
+			Extensions: ExtensionConfig{
+				PlayoutDelay: true,
+				VideoTiming:  time.Millisecond * 200,
+			},
+			Pacer: PacerConfig{
+				Bitrate:  50_000_000,
+				Burst:    time.Millisecond * 5,
+				MaxDelay: time.Millisecond * 100,
+				Estimate: true,
+			},
+			Opus: OpusConfig{
+				Stereo:    true,
+				InbandFEC: true,
+			},
+		},
+		Auth: SessionAuthConfig{
+			Scheme: "none",
+			Leeway: time.Second * 30,
+		},
+		Turn: TurnConfig{
+			Realm: "rtchub",
+			TTL:   time.Hour * 24,
+		},
+		Codecs: CodecConfig{Video: "h264", Audio: "opus"},
+		Datachannels: []DatachannelConfig{
+			{Name: "hid", QueueSize: 128, Overflow: "coalesce"},
+			{Name: "manual"},
+			{Name: "mouse", Unordered: true, MaxRetransmits: &no_retransmit, Overflow: "coalesce"},
+			{Name: "cursor", Unordered: true, MaxRetransmits: &no_retransmit, Overflow: "coalesce"},
+			{Name: "cursor-shape"},
+		},
+		Clipboard: "both",
+		Limits: LimitConfig{
+			ClipboardSize:   32 * 1024,
+			FileSize:        4 * 1024 * 1024 * 1024,
+			FileConcurrency: 4,
+		},
+		Logging: LogConfig{Level: "info"},
+	}
+}
+
+// setting is a single value reachable from both the environment and the
+// command line, env is the variable name without RTCHUB_
+type setting struct {
+	name  string
+	env   string
+	usage string
+	set   func(conf *Config, value string) error
+}
+
+var settings = []setting{
+	{"token", "TOKEN", "shared memory token", func(c *Config, v string) error {
+		c.Token = v
+		return nil
+	}},
+	{"auth", "AUTH", "session token scheme, none, hmac or jwt", func(c *Config, v string) error {
+		c.Auth.Scheme = v
+		return nil
+	}},
+	{"auth_secret", "AUTH_SECRET", "secret verifying session tokens", func(c *Config, v string) error {
+		c.Auth.Secret = v
+		return nil
+	}},
+	{"auth_leeway", "AUTH_LEEWAY", "seconds or duration of clock skew tolerated on token expiry", func(c *Config, v string) (err error) {
+		c.Auth.Leeway, err = parseDuration(v)
+		return
+	}},
+	{"admin_listen", "ADMIN_LISTEN", "serve the admin api on this loopback address", func(c *Config, v string) error {
+		c.Admin.Listen = v
+		return nil
+	}},
+	{"admin_token", "ADMIN_TOKEN", "bearer token of the admin api", func(c *Config, v string) error {
+		c.Admin.Token = v
+		return nil
+	}},
+	{"video_channel", "VIDEO_CHANNEL", "video queue streamed until a viewer switches display, 0 or 1", func(c *Config, v string) (err error) {
+		c.VideoChannel, err = strconv.Atoi(v)
+		return
+	}},
+	{"video", "VIDEO_URL", "signalling url of the video connection", func(c *Config, v string) error {
+		c.Signalling.Video = v
+		return nil
+	}},
+	{"audio", "AUDIO_URL", "signalling url of the audio connection", func(c *Config, v string) error {
+		c.Signalling.Audio = v
+		return nil
+	}},
+	{"bundle", "BUNDLE_URL", "signalling url of a single connection carrying everything", func(c *Config, v string) error {
+		c.Signalling.Bundle = v
+		return nil
+	}},
+	{"stun", "STUN", "comma separated stun urls, replacing configured ones", func(c *Config, v string) error {
+		replaceServers(c, "stun", split(v))
+		return nil
+	}},
+	{"turn", "TURN", "comma separated turn urls, replacing configured ones", func(c *Config, v string) error {
+		replaceServers(c, "turn", split(v))
+		return nil
+	}},
+	{"turn_username", "TURN_USERNAME", "username of every turn server", func(c *Config, v string) error {
+		forTurn(c, func(server *webrtc.ICEServer) { server.Username = v })
+		return nil
+	}},
+	{"turn_password", "TURN_PASSWORD", "credential of every turn server", func(c *Config, v string) error {
+		forTurn(c, func(server *webrtc.ICEServer) { server.Credential = v })
+		return nil
+	}},
+	{"idle_timeout", "IDLE_TIMEOUT", "seconds or duration without input before sessions close, 0 disables", func(c *Config, v string) (err error) {
+		c.WebRTC.Policy.IdleTimeout, err = parseDuration(v)
+		return
+	}},
+	{"max_duration", "MAX_DURATION", "seconds or duration a session may run, 0 disables", func(c *Config, v string) (err error) {
+		c.WebRTC.Policy.MaxDuration, err = parseDuration(v)
+		return
+	}},
+	{"report_timeout", "REPORT_TIMEOUT", "seconds or duration without rtcp receiver reports before sessions close, 0 disables", func(c *Config, v string) (err error) {
+		c.WebRTC.Policy.ReportTimeout, err = parseDuration(v)
+		return
+	}},
+	{"policy_warning", "POLICY_WARNING", "seconds or duration viewers are warned before idle or max duration closes", func(c *Config, v string) (err error) {
+		c.WebRTC.Policy.Warning, err = parseDuration(v)
+		return
+	}},
+	{"policy_channel", "POLICY_CHANNEL", "datachannel carrying session warnings", func(c *Config, v string) error {
+		c.WebRTC.Policy.Channel = v
+		return nil
+	}},
+	{"playout_delay", "PLAYOUT_DELAY", "write the playout-delay extension, true or false", func(c *Config, v string) (err error) {
+		c.WebRTC.Extensions.PlayoutDelay, err = strconv.ParseBool(v)
+		return
+	}},
+	{"playout_delay_min", "PLAYOUT_DELAY_MIN", "seconds or duration of the minimum playout delay", func(c *Config, v string) (err error) {
+		c.WebRTC.Extensions.MinDelay, err = parseDuration(v)
+		return
+	}},
+	{"playout_delay_max", "PLAYOUT_DELAY_MAX", "seconds or duration of the maximum playout delay", func(c *Config, v string) (err error) {
+		c.WebRTC.Extensions.MaxDelay, err = parseDuration(v)
+		return
+	}},
+	{"video_timing", "VIDEO_TIMING", "seconds or duration between frames carrying video-timing, 0 disables", func(c *Config, v string) (err error) {
+		c.WebRTC.Extensions.VideoTiming, err = parseDuration(v)
+		return
+	}},
+	{"opus_channels", "OPUS_CHANNELS", "audio channels of the encoder, 1, 2, 6 or 8, 0 follows the audio queue", func(c *Config, v string) (err error) {
+		c.WebRTC.Opus.Channels, err = strconv.Atoi(v)
+		return
+	}},
+	{"opus_stereo", "OPUS_STEREO", "signal stereo on two channel audio, true or false", func(c *Config, v string) (err error) {
+		c.WebRTC.Opus.Stereo, err = strconv.ParseBool(v)
+		return
+	}},
+	{"opus_fec", "OPUS_FEC", "signal opus inband forward error correction, true or false", func(c *Config, v string) (err error) {
+		c.WebRTC.Opus.InbandFEC, err = strconv.ParseBool(v)
+		return
+	}},
+	{"opus_dtx", "OPUS_DTX", "signal opus discontinuous transmission, true or false", func(c *Config, v string) (err error) {
+		c.WebRTC.Opus.DTX, err = strconv.ParseBool(v)
+		return
+	}},
+	{"opus_max_bitrate", "OPUS_MAX_BITRATE", "opus maxaveragebitrate in bits per second, 0 leaves it unsignalled", func(c *Config, v string) (err error) {
+		c.WebRTC.Opus.MaxAverageBitrate, err = strconv.Atoi(v)
+		return
+	}},
+	{"pacer_bitrate", "PACER_BITRATE", "bits per second packets are paced at, 0 disables pacing", func(c *Config, v string) (err error) {
+		c.WebRTC.Pacer.Bitrate, err = strconv.ParseInt(v, 10, 64)
+		return
+	}},
+	{"pacer_burst", "PACER_BURST", "seconds or duration at the pacing rate sent back to back", func(c *Config, v string) (err error) {
+		c.WebRTC.Pacer.Burst, err = parseDuration(v)
+		return
+	}},
+	{"pacer_max_delay", "PACER_MAX_DELAY", "seconds or duration a paced packet may wait", func(c *Config, v string) (err error) {
+		c.WebRTC.Pacer.MaxDelay, err = parseDuration(v)
+		return
+	}},
+	{"pacer_estimate", "PACER_ESTIMATE", "pace at the viewer bandwidth estimate when lower, true or false", func(c *Config, v string) (err error) {
+		c.WebRTC.Pacer.Estimate, err = strconv.ParseBool(v)
+		return
+	}},
+	{"resume_timeout", "RESUME_TIMEOUT", "seconds or duration a disconnected session may take to resume", func(c *Config, v string) (err error) {
+		c.WebRTC.ResumeTimeout, err = parseDuration(v)
+		return
+	}},
+	{"turn_listen", "TURN_LISTEN", "run an embedded turn server on this udp address", func(c *Config, v string) error {
+		c.Turn.Listen = v
+		return nil
+	}},
+	{"turn_public_ip", "TURN_PUBLIC_IP", "ip advertised for the embedded turn server and its relays", func(c *Config, v string) error {
+		c.Turn.PublicIP = v
+		return nil
+	}},
+	{"turn_realm", "TURN_REALM", "realm of the embedded turn server", func(c *Config, v string) error {
+		c.Turn.Realm = v
+		return nil
+	}},
+	{"turn_secret", "TURN_SECRET", "shared secret signing embedded turn credentials", func(c *Config, v string) error {
+		c.Turn.Secret = v
+		return nil
+	}},
+	{"turn_ttl", "TURN_TTL", "seconds or duration embedded turn credentials stay valid", func(c *Config, v string) (err error) {
+		c.Turn.TTL, err = parseDuration(v)
+		return
+	}},
+	{"turn_port_range", "TURN_PORT_RANGE", "relay port range of the embedded turn server, as min-max", func(c *Config, v string) (err error) {
+		c.Turn.PortMin, c.Turn.PortMax, err = parseRange(v)
+		return
+	}},
+	{"turn_bandwidth", "TURN_BANDWIDTH", "relayed bits per second allowed per session", func(c *Config, v string) (err error) {
+		c.Turn.Bandwidth, err = strconv.ParseInt(v, 10, 64)
+		return
+	}},
+	{"port_range", "PORT_RANGE", "udp port range of host candidates, as min-max", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.PortMin, c.WebRTC.Network.PortMax, err = parseRange(v)
+		return
+	}},
+	{"udp_port", "UDP_PORT", "serve every session from this udp port", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.UDPPort, err = strconv.Atoi(v)
+		return
+	}},
+	{"tcp_port", "TCP_PORT", "serve ICE-TCP for every session from this tcp port", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.TCPPort, err = strconv.Atoi(v)
+		return
+	}},
+	{"nat_ips", "NAT_IPS", "comma separated public ips advertised for this host", func(c *Config, v string) error {
+		c.WebRTC.Network.NAT1To1IPs = split(v)
+		return nil
+	}},
+	{"nat_type", "NAT_TYPE", "candidate type of the public ips, host or srflx", func(c *Config, v string) error {
+		c.WebRTC.Network.NAT1To1Type = v
+		return nil
+	}},
+	{"network_types", "NETWORK_TYPES", "comma separated udp4, udp6, tcp4 or tcp6", func(c *Config, v string) error {
+		c.WebRTC.Network.NetworkTypes = split(v)
+		return nil
+	}},
+	{"interfaces", "INTERFACES", "comma separated interfaces to gather candidates on", func(c *Config, v string) error {
+		c.WebRTC.Network.Interfaces = split(v)
+		return nil
+	}},
+	{"ips", "IPS", "comma separated ips or cidrs to gather candidates on", func(c *Config, v string) error {
+		c.WebRTC.Network.IPs = split(v)
+		return nil
+	}},
+	{"loopback", "LOOPBACK", "gather candidates on loopback addresses too", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.Loopback, err = strconv.ParseBool(v)
+		return
+	}},
+	{"mdns", "MDNS", "mdns candidates, disabled, query or gather", func(c *Config, v string) error {
+		c.WebRTC.Network.MDNS = v
+		return nil
+	}},
+	{"ice_disconnected_timeout", "ICE_DISCONNECTED_TIMEOUT", "seconds or duration without traffic before ICE reports disconnected", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.DisconnectedTimeout, err = parseDuration(v)
+		return
+	}},
+	{"ice_failed_timeout", "ICE_FAILED_TIMEOUT", "seconds or duration after disconnected before ICE reports failed", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.FailedTimeout, err = parseDuration(v)
+		return
+	}},
+	{"ice_keepalive", "ICE_KEEPALIVE", "seconds or duration between ICE keepalives", func(c *Config, v string) (err error) {
+		c.WebRTC.Network.KeepAliveInterval, err = parseDuration(v)
+		return
+	}},
+	{"video_codec", "VIDEO_CODEC", "h264, h265, av1, vp8 or vp9", func(c *Config, v string) error {
+		c.Codecs.Video = v
+		return nil
+	}},
+	{"audio_codec", "AUDIO_CODEC", "opus", func(c *Config, v string) error {
+		c.Codecs.Audio = v
+		return nil
+	}},
+	{"simulcast", "SIMULCAST", "publish video queue 0 and 1 as high and low encodings of one display, true or false", func(c *Config, v string) (err error) {
+		c.Codecs.Simulcast, err = strconv.ParseBool(v)
+		return
+	}},
+	{"clipboard", "CLIPBOARD", "clipboard sync direction, both, inbound, outbound or none", func(c *Config, v string) error {
+		c.Clipboard = v
+		return nil
+	}},
+	{"clipboard_max_size", "CLIPBOARD_MAX_SIZE", "largest clipboard payload in bytes", func(c *Config, v string) (err error) {
+		c.Limits.ClipboardSize, err = strconv.Atoi(v)
+		return
+	}},
+	{"unicode_input", "UNICODE_INPUT", "type text missing from the US layout with ctrl+shift+u on linux, needs ibus or gtk, true or false", func(c *Config, v string) (err error) {
+		c.UnicodeInput, err = strconv.ParseBool(v)
+		return
+	}},
+	{"file_directory", "FILE_DIRECTORY", "directory served by file transfer, empty disables it", func(c *Config, v string) error {
+		c.FileDir = v
+		return nil
+	}},
+	{"file_concurrency", "FILE_CONCURRENCY", "concurrent file transfers", func(c *Config, v string) (err error) {
+		c.Limits.FileConcurrency, err = strconv.Atoi(v)
+		return
+	}},
+	{"file_max_size", "FILE_MAX_SIZE", "largest uploaded file in bytes", func(c *Config, v string) (err error) {
+		c.Limits.FileSize, err = strconv.ParseInt(v, 10, 64)
+		return
+	}},
+	{"log_level", "LOG_LEVEL", "disabled, error, warn, info, debug or trace", func(c *Config, v string) error {
+		c.Logging.Level = v
+		return nil
+	}},
+	{"log_file", "LOG_FILE", "append logs to this file instead of stdout", func(c *Config, v string) error {
+		c.Logging.File = v
+		return nil
+	}},
+}
+
+// Load builds the configuration from defaults, then the file named by
+// --config or RTCHUB_CONFIG, then RTCHUB_ environment variables, then
+// the remaining flags, each layer overriding the previous one
+func Load(args []string) (*Config, error) {
+	conf := Default()
+	flags := flag.NewFlagSet("rtchub", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv(env_prefix+"CONFIG"), "yaml or json configuration file")
+	values := map[string]*string{}
+	for _, s := range settings {
+		values[s.name] = flags.String(s.name, "", fmt.Sprintf("%s (env %s%s)", s.usage, env_prefix, s.env))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	} else if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	if *file != "" {
+		if err := conf.readFile(*file); err != nil {
+			return nil, err
+		}
+	}
+
+	errs := []error{}
+	for _, s := range settings {
+		if value, found := os.LookupEnv(env_prefix + s.env); found {
+			if err := s.set(conf, value); err != nil {
+				errs = append(errs, fmt.Errorf("env %s%s=%q: %v", env_prefix, s.env, value, err))
+			}
+		}
+	}
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, s := range settings {
+		if set[s.name] {
+			if err := s.set(conf, *values[s.name]); err != nil {
+				errs = append(errs, fmt.Errorf("flag --%s=%q: %v", s.name, *values[s.name], err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return conf, conf.Validate()
+}
+
+func (conf *Config) readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// json is a subset of yaml, one strict decoder covers both
+	node := yaml.Node{}
+	if err := yaml.NewDecoder(file).Decode(&node); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	seconds(&node, reflect.TypeOf(conf).Elem())
+	content, err := yaml.Marshal(&node)
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(conf); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// seconds reads bare integers given for durations as seconds, the way
+// parseDuration does for flags and the environment, instead of the
+// nanoseconds yaml would decode them to
+func seconds(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case node.Kind == yaml.DocumentNode:
+		for _, child := range node.Content {
+			seconds(child, t)
+		}
+	case t == reflect.TypeOf(time.Duration(0)):
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!int" {
+			node.Value, node.Tag = node.Value+"s", "!!str"
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for _, child := range node.Content {
+			seconds(child, t.Elem())
+		}
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			for _, field := range reflect.VisibleFields(t) {
+				if name, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); name == node.Content[i].Value {
+					seconds(node.Content[i+1], field.Type)
+				}
+			}
+		}
+	}
+}
+
+// Validate reports every invalid value at once
+func (conf *Config) Validate() error {
+	errs := []error{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(slices.Contains(auth_schemes, conf.Auth.Scheme), "auth.scheme %q is not one of %s", conf.Auth.Scheme, strings.Join(auth_schemes, ", "))
+	check(conf.Auth.Scheme == "none" || len(conf.Auth.Secret) >= 16, "auth.secret must hold at least 16 bytes with scheme %s", conf.Auth.Scheme)
+	check(conf.Auth.Leeway >= 0, "auth.leeway must not be negative")
+	if conf.Admin.Listen != "" {
+		host, _, err := net.SplitHostPort(conf.Admin.Listen)
+		ip := net.ParseIP(host)
+		check(err == nil && (host == "localhost" || (ip != nil && ip.IsLoopback())),
+			"admin.listen %q must be a loopback host:port address", conf.Admin.Listen)
+		check(len(conf.Admin.Token) >= 16, "admin.token must hold at least 16 bytes")
+	}
+	check(conf.VideoChannel == 0 || conf.VideoChannel == 1, "videoChannel must be 0 or 1, got %d", conf.VideoChannel)
+	check(!conf.Codecs.Simulcast || conf.VideoChannel == 0, "simulcast and a second display are mutually exclusive, videoChannel must be 0 as queue 1 carries the low encoding")
+	if conf.Signalling.Bundle != "" {
+		check(validURL(conf.Signalling.Bundle), "signalling.bundle %q is not an http(s) url", conf.Signalling.Bundle)
+	} else {
+		check(validURL(conf.Signalling.Video), "signalling.video %q is not an http(s) url", conf.Signalling.Video)
+		check(validURL(conf.Signalling.Audio), "signalling.audio %q is not an http(s) url", conf.Signalling.Audio)
+	}
+
+	for i, server := range conf.WebRTC.Ices {
+		check(len(server.URLs) > 0, "webrtc.iceServers[%d] has no urls, turn credentials need turn urls", i)
+		for _, u := range server.URLs {
+			check(hasPrefix(u, ice_schemes), "webrtc.iceServers[%d] url %q must start with stun:, stuns:, turn: or turns:", i, u)
+			if strings.HasPrefix(u, "turn") {
+				check(server.Username != "" && server.Credential != nil && server.Credential != "",
+					"webrtc.iceServers[%d] turn server %q needs a username and credential", i, u)
+			}
+		}
+	}
+	check(conf.WebRTC.ResumeTimeout >= 0, "webrtc.resumeTimeout must not be negative")
+	conf.WebRTC.Network.validate(check)
+	policy := conf.WebRTC.Policy
+	check(policy.IdleTimeout >= 0 && policy.MaxDuration >= 0 && policy.ReportTimeout >= 0 && policy.Warning >= 0,
+		"webrtc.policy limits must not be negative")
+	extensions := conf.WebRTC.Extensions
+	check(extensions.MinDelay >= 0 && extensions.MinDelay <= extensions.MaxDelay && extensions.MaxDelay <= max_playout_delay,
+		"webrtc.extensions playout delay %s-%s is not a range within 0-%s", extensions.MinDelay, extensions.MaxDelay, max_playout_delay)
+	check(extensions.VideoTiming >= 0, "webrtc.extensions.videoTiming must not be negative")
+	pacer := conf.WebRTC.Pacer
+	check(pacer.Bitrate >= 0 && pacer.Burst >= 0 && pacer.MaxDelay >= 0, "webrtc.pacer values must not be negative")
+	check(pacer.Bitrate == 0 || pacer.Burst > 0, "webrtc.pacer.burst must be positive while pacing")
+	opus := conf.WebRTC.Opus
+	check(slices.Contains(opus_channels, opus.Channels), "webrtc.opus.channels %d is not one of 0, 1, 2, 6, 8", opus.Channels)
+	check(opus.MaxAverageBitrate == 0 || (opus.MaxAverageBitrate >= min_opus_bitrate && opus.MaxAverageBitrate <= max_opus_bitrate),
+		"webrtc.opus.maxAverageBitrate %d is not within %d-%d", opus.MaxAverageBitrate, min_opus_bitrate, max_opus_bitrate)
+	if conf.Turn.Listen != "" {
+		_, _, err := net.SplitHostPort(conf.Turn.Listen)
+		check(err == nil, "turn.listen %q is not a host:port address", conf.Turn.Listen)
+		check(net.ParseIP(conf.Turn.PublicIP) != nil, "turn.publicIP %q is not an ip", conf.Turn.PublicIP)
+		check(conf.Turn.Realm != "", "turn.realm must not be empty")
+		check(conf.Turn.TTL > 0, "turn.ttl must be positive")
+		check((conf.Turn.PortMin == 0) == (conf.Turn.PortMax == 0) && conf.Turn.PortMin <= conf.Turn.PortMax,
+			"turn port range %d-%d is not a valid range", conf.Turn.PortMin, conf.Turn.PortMax)
+		check(conf.Turn.Bandwidth >= 0, "turn.bandwidth must not be negative")
+	}
+
+	check(conf.Codecs.VideoMimeType() != "", "codecs.video %q is not one of h264, h265, av1, vp8, vp9", conf.Codecs.Video)
+	check(conf.Codecs.AudioMimeType() != "", "codecs.audio %q is not one of opus", conf.Codecs.Audio)
+
+	names := map[string]bool{}
+	for i, dc := range conf.Datachannels {
+		check(dc.Name != "", "datachannels[%d] has no name", i)
+		check(!names[dc.Name], "datachannels[%d] name %q is used twice", i, dc.Name)
+		names[dc.Name] = true
+		check(dc.MaxRetransmits == nil || dc.MaxPacketLifeTime == nil,
+			"datachannels[%d] %q sets both maxRetransmits and maxPacketLifeTime", i, dc.Name)
+		check(dc.QueueSize >= 0, "datachannels[%d] %q queueSize must not be negative", i, dc.Name)
+		check(dc.Overflow == "" || slices.Contains(overflows, dc.Overflow),
+			"datachannels[%d] %q overflow %q is not one of %s", i, dc.Name, dc.Overflow, strings.Join(overflows, ", "))
+	}
+
+	check(slices.Contains(clipboards, conf.Clipboard), "clipboard %q is not one of %s", conf.Clipboard, strings.Join(clipboards, ", "))
+	check(conf.Limits.ClipboardSize > 0 && conf.Limits.ClipboardSize <= clipboard.MaxSize,
+		"limits.clipboardSize must be between 1 and %d, larger payloads do not fit a datachannel message", clipboard.MaxSize)
+	check(conf.Limits.FileSize > 0, "limits.fileSize must be positive")
+	check(conf.Limits.FileConcurrency > 0, "limits.fileConcurrency must be positive")
+	check(slices.Contains(log_levels, conf.Logging.Level), "logging.level %q is not one of %s", conf.Logging.Level, strings.Join(log_levels, ", "))
+
+	return errors.Join(errs...)
+}
+
+func (network NetworkConfig) validate(check func(bool, string, ...interface{})) {
+	check((network.PortMin == 0) == (network.PortMax == 0) && network.PortMin <= network.PortMax,
+		"webrtc.network port range %d-%d is not a valid range", network.PortMin, network.PortMax)
+	check(network.UDPPort >= 0 && network.UDPPort <= 0xFFFF, "webrtc.network.udpPort %d is not a port", network.UDPPort)
+	check(network.TCPPort >= 0 && network.TCPPort <= 0xFFFF, "webrtc.network.tcpPort %d is not a port", network.TCPPort)
+	for _, ip := range network.NAT1To1IPs {
+		check(net.ParseIP(ip) != nil, "webrtc.network.nat1To1IPs %q is not an ip", ip)
+	}
+	check(network.NAT1To1Type == "" || slices.Contains(nat_types, network.NAT1To1Type),
+		"webrtc.network.nat1To1Type %q is not one of %s", network.NAT1To1Type, strings.Join(nat_types, ", "))
+	check(network.NAT1To1Type != "host" || network.MDNS != "gather",
+		"webrtc.network host nat1To1IPs can not be combined with mdns gather")
+	for _, kind := range network.NetworkTypes {
+		check(slices.Contains(network_types, kind), "webrtc.network.networkTypes %q is not one of %s", kind, strings.Join(network_types, ", "))
+	}
+	for _, ip := range network.IPs {
+		_, _, err := net.ParseCIDR(ip)
+		check(err == nil || net.ParseIP(ip) != nil, "webrtc.network.ips %q is not an ip or cidr", ip)
+	}
+	check(network.MDNS == "" || slices.Contains(mdns_modes, network.MDNS),
+		"webrtc.network.mdns %q is not one of %s", network.MDNS, strings.Join(mdns_modes, ", "))
+	check(network.DisconnectedTimeout >= 0 && network.FailedTimeout >= 0 && network.KeepAliveInterval >= 0,
+		"webrtc.network ICE timeouts must not be negative")
+}
+
+// isKind matches servers by the scheme of their first url, a turn server
+// without urls holds credentials given before its urls
+func isKind(server webrtc.ICEServer, kind string) bool {
+	if len(server.URLs) == 0 {
+		return kind == "turn"
+	}
+	return strings.HasPrefix(server.URLs[0], kind)
+}
+
+func replaceServers(conf *Config, kind string, urls []string) {
+	servers := []webrtc.ICEServer{}
+	previous := webrtc.ICEServer{}
+	for _, server := range conf.WebRTC.Ices {
+		if isKind(server, kind) {
+			previous = server
+		} else {
+			servers = append(servers, server)
+		}
+	}
+
+	if len(urls) > 0 {
+		// credentials set before the urls carry over to the new server
+		servers = append(servers, webrtc.ICEServer{
+			URLs:       urls,
+			Username:   previous.Username,
+			Credential: previous.Credential,
+		})
+	}
+	conf.WebRTC.Ices = servers
+}
+
+func forTurn(conf *Config, fun func(*webrtc.ICEServer)) {
+	found := false
+	for i := range conf.WebRTC.Ices {
+		if isKind(conf.WebRTC.Ices[i], "turn") {
+			fun(&conf.WebRTC.Ices[i])
+			found = true
+		}
+	}
+
+	if !found {
+		server := webrtc.ICEServer{URLs: []string{}}
+		fun(&server)
+		conf.WebRTC.Ices = append(conf.WebRTC.Ices, server)
+	}
+}
+
+func parseRange(value string) (min, max uint16, err error) {
+	low, high, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("expected min-max")
+	}
+	a, err := strconv.ParseUint(strings.TrimSpace(low), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := strconv.ParseUint(strings.TrimSpace(high), 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(a), uint16(b), nil
+}
+
+func split(value string) []string {
+	ret := []string{}
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && slices.Contains(signal_schemes, u.Scheme) && u.Host != ""
+}
+
+func hasPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
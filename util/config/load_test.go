@@ -0,0 +1,194 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rtchub.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`
+token: from-file
+videoChannel: 1
+webrtc:
+  resumeTimeout: 30s
+  iceServers:
+    - urls: ["stun:stun.example.com:3478"]
+    - urls: ["turn:turn.example.com:3478", "turns:turn.example.com:5349"]
+      username: user
+      credential: secret
+datachannels:
+  - name: hid
+    overflow: drop-oldest
+`), 0644))
+
+	t.Setenv("RTCHUB_CONFIG", file)
+	t.Setenv("RTCHUB_TOKEN", "from-env")
+	t.Setenv("RTCHUB_VIDEO_CHANNEL", "0")
+	conf, err := Load([]string{"--token", "from-flag", "--turn_password", "rotated"})
+	require.Nil(t, err)
+
+	require.Equal(t, "from-flag", conf.Token)
+	require.Equal(t, 0, conf.VideoChannel)
+	require.Equal(t, 30*time.Second, conf.WebRTC.ResumeTimeout)
+	require.Len(t, conf.WebRTC.Ices, 2)
+	require.Equal(t, "rotated", conf.WebRTC.Ices[1].Credential)
+	require.Equal(t, []DatachannelConfig{{Name: "hid", Overflow: "drop-oldest"}}, conf.Datachannels)
+}
+
+func TestJSONFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rtchub.json")
+	require.Nil(t, os.WriteFile(file, []byte(`{"codecs": {"video": "av1", "audio": "opus"}, "clipboard": "none"}`), 0644))
+
+	conf, err := Load([]string{"--config", file})
+	require.Nil(t, err)
+	require.Equal(t, "video/AV1", conf.Codecs.VideoMimeType())
+	require.Equal(t, "none", conf.Clipboard)
+	require.False(t, conf.UnicodeInput)
+
+	conf, err = Load([]string{"--config", file, "--unicode_input", "true"})
+	require.Nil(t, err)
+	require.True(t, conf.UnicodeInput)
+}
+
+func TestTurnCredentialsBeforeURLs(t *testing.T) {
+	t.Setenv("RTCHUB_TURN_USERNAME", "user")
+	t.Setenv("RTCHUB_TURN_PASSWORD", "secret")
+	conf, err := Load([]string{"--turn", "turn:a.example.com,turn:b.example.com", "--stun", "stun:s.example.com"})
+	require.Nil(t, err)
+	require.Len(t, conf.WebRTC.Ices, 2)
+	for _, server := range conf.WebRTC.Ices {
+		if isKind(server, "turn") {
+			require.Equal(t, []string{"turn:a.example.com", "turn:b.example.com"}, server.URLs)
+			require.Equal(t, "user", server.Username)
+			require.Equal(t, "secret", server.Credential)
+		}
+	}
+}
+
+func TestValidation(t *testing.T) {
+	_, err := Load([]string{"--video_channel", "two"})
+	require.ErrorContains(t, err, "--video_channel")
+
+	_, err = Load([]string{"--video_codec", "mpeg2", "--video", "ws://host", "--turn", "turn:host"})
+	require.ErrorContains(t, err, "codecs.video")
+	require.ErrorContains(t, err, "signalling.video")
+	require.ErrorContains(t, err, "needs a username and credential")
+
+	file := filepath.Join(t.TempDir(), "typo.yaml")
+	require.Nil(t, os.WriteFile(file, []byte("tokn: x\n"), 0644))
+	_, err = Load([]string{"--config", file})
+	require.ErrorContains(t, err, "tokn")
+
+	_, err = Load([]string{"--clipboard_max_size", "262144"})
+	require.ErrorContains(t, err, "limits.clipboardSize")
+}
+
+func TestNetwork(t *testing.T) {
+	conf, err := Load([]string{"--port_range", "40000-40100", "--nat_ips", "203.0.113.5", "--network_types", "udp4,tcp4", "--ice_failed_timeout", "10"})
+	require.Nil(t, err)
+	require.Equal(t, uint16(40000), conf.WebRTC.Network.PortMin)
+	require.Equal(t, uint16(40100), conf.WebRTC.Network.PortMax)
+	require.Equal(t, []string{"203.0.113.5"}, conf.WebRTC.Network.NAT1To1IPs)
+	require.Equal(t, []string{"udp4", "tcp4"}, conf.WebRTC.Network.NetworkTypes)
+	require.Equal(t, 10*time.Second, conf.WebRTC.Network.FailedTimeout)
+
+	_, err = Load([]string{"--port_range", "40100-40000", "--nat_ips", "public", "--network_types", "sctp", "--mdns", "loud", "--ips", "x/8"})
+	require.ErrorContains(t, err, "port range")
+	require.ErrorContains(t, err, "nat1To1IPs")
+	require.ErrorContains(t, err, "networkTypes")
+	require.ErrorContains(t, err, "mdns")
+	require.ErrorContains(t, err, "webrtc.network.ips")
+}
+
+func TestTurnServer(t *testing.T) {
+	conf, err := Load([]string{"--turn_listen", "0.0.0.0:3478", "--turn_public_ip", "203.0.113.5", "--turn_bandwidth", "8000000"})
+	require.Nil(t, err)
+	require.Equal(t, "rtchub", conf.Turn.Realm)
+	require.Equal(t, int64(8000000), conf.Turn.Bandwidth)
+
+	_, err = Load([]string{"--turn_listen", "3478", "--turn_ttl", "0"})
+	require.ErrorContains(t, err, "turn.listen")
+	require.ErrorContains(t, err, "turn.publicIP")
+	require.ErrorContains(t, err, "turn.ttl")
+}
+
+func TestAdmin(t *testing.T) {
+	t.Setenv("RTCHUB_ADMIN_TOKEN", "0123456789abcdef")
+	conf, err := Load([]string{"--admin_listen", "127.0.0.1:9090"})
+	require.Nil(t, err)
+	require.Equal(t, "127.0.0.1:9090", conf.Admin.Listen)
+
+	_, err = Load([]string{"--admin_listen", "0.0.0.0:9090", "--admin_token", "short"})
+	require.ErrorContains(t, err, "loopback")
+	require.ErrorContains(t, err, "admin.token")
+}
+
+func TestExtensions(t *testing.T) {
+	conf, err := Load([]string{"--playout_delay_max", "100ms", "--video_timing", "0"})
+	require.Nil(t, err)
+	require.True(t, conf.WebRTC.Extensions.PlayoutDelay)
+	require.Equal(t, 100*time.Millisecond, conf.WebRTC.Extensions.MaxDelay)
+	require.Zero(t, conf.WebRTC.Extensions.VideoTiming)
+
+	_, err = Load([]string{"--playout_delay_min", "1s", "--playout_delay_max", "100ms"})
+	require.ErrorContains(t, err, "playout delay")
+}
+
+func TestPacer(t *testing.T) {
+	conf, err := Load([]string{"--pacer_bitrate", "20000000", "--pacer_max_delay", "50ms"})
+	require.Nil(t, err)
+	require.Equal(t, int64(20000000), conf.WebRTC.Pacer.Bitrate)
+	require.Equal(t, 50*time.Millisecond, conf.WebRTC.Pacer.MaxDelay)
+	require.True(t, conf.WebRTC.Pacer.Estimate)
+
+	_, err = Load([]string{"--pacer_burst", "0"})
+	require.ErrorContains(t, err, "webrtc.pacer.burst")
+}
+
+func TestSimulcast(t *testing.T) {
+	conf, err := Load([]string{"--simulcast", "true"})
+	require.Nil(t, err)
+	require.True(t, conf.Codecs.Simulcast)
+
+	_, err = Load([]string{"--simulcast", "true", "--video_channel", "1"})
+	require.ErrorContains(t, err, "simulcast")
+}
+
+func TestOpus(t *testing.T) {
+	conf, err := Load([]string{"--opus_channels", "6", "--opus_dtx", "true", "--opus_max_bitrate", "256000"})
+	require.Nil(t, err)
+	require.Equal(t, 6, conf.WebRTC.Opus.Channels)
+	require.True(t, conf.WebRTC.Opus.Stereo)
+	require.True(t, conf.WebRTC.Opus.DTX)
+	require.Equal(t, 256000, conf.WebRTC.Opus.MaxAverageBitrate)
+
+	_, err = Load([]string{"--opus_channels", "4"})
+	require.ErrorContains(t, err, "webrtc.opus.channels")
+	_, err = Load([]string{"--opus_max_bitrate", "1000"})
+	require.ErrorContains(t, err, "maxAverageBitrate")
+}
+
+func TestDurationSeconds(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rtchub.yaml")
+	require.Nil(t, os.WriteFile(file, []byte(`
+webrtc:
+  resumeTimeout: 2m
+  policy:
+    idleTimeout: 300
+`), 0644))
+	fromFile, err := Load([]string{"--config", file})
+	require.Nil(t, err)
+
+	t.Setenv("RTCHUB_IDLE_TIMEOUT", "300")
+	fromEnv, err := Load(nil)
+	require.Nil(t, err)
+
+	require.Equal(t, 5*time.Minute, fromFile.WebRTC.Policy.IdleTimeout)
+	require.Equal(t, fromEnv.WebRTC.Policy.IdleTimeout, fromFile.WebRTC.Policy.IdleTimeout)
+	require.Equal(t, 2*time.Minute, fromFile.WebRTC.ResumeTimeout)
+}
@@ -0,0 +1,47 @@
+package hdr
+
+import (
+	"encoding/binary"
+)
+
+// ColorSpaceURI is the header extension carrying Metadata to the browser
+const ColorSpaceURI = "http://www.webrtc.org/experiments/rtp-hdrext/color-space"
+
+// H.273 code points of HDR10
+const (
+	PrimariesBT2020 = 9
+	TransferPQ      = 16
+	TransferHLG     = 18
+	MatrixBT2020NCL = 9
+)
+
+// Metadata is the HDR10 static metadata of a stream, chromaticities are in
+// 0.00002 units as in SMPTE ST 2086
+type Metadata struct {
+	// Primaries are the red, green and blue x,y of the mastering display
+	Primaries  [3][2]uint16 `json:"primaries"`
+	WhitePoint [2]uint16    `json:"whitePoint"`
+	// MaxLuminance is in nits, MinLuminance in 0.0001 nits
+	MaxLuminance uint16 `json:"maxLuminance"`
+	MinLuminance uint16 `json:"minLuminance"`
+	// MaxCLL and MaxFALL are the content light levels in nits
+	MaxCLL  uint16 `json:"maxCLL"`
+	MaxFALL uint16 `json:"maxFALL"`
+}
+
+// ColorSpace is the 28 byte payload of the color space extension for a
+// limited range BT.2020 PQ stream described by m
+func (m Metadata) ColorSpace() []byte {
+	b := make([]byte, 28)
+	b[0], b[1], b[2] = PrimariesBT2020, TransferPQ, MatrixBT2020NCL
+	b[3] = 1 << 4 // limited range, chroma siting unspecified
+	binary.BigEndian.PutUint16(b[4:], m.MaxLuminance)
+	binary.BigEndian.PutUint16(b[6:], m.MinLuminance)
+	for i, xy := range [][2]uint16{m.Primaries[0], m.Primaries[1], m.Primaries[2], m.WhitePoint} {
+		binary.BigEndian.PutUint16(b[8+i*4:], xy[0])
+		binary.BigEndian.PutUint16(b[10+i*4:], xy[1])
+	}
+	binary.BigEndian.PutUint16(b[24:], m.MaxCLL)
+	binary.BigEndian.PutUint16(b[26:], m.MaxFALL)
+	return b
+}
@@ -0,0 +1,28 @@
+package hdr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestColorSpace(t *testing.T) {
+	m := Metadata{
+		Primaries:    [3][2]uint16{{35400, 14600}, {8500, 39850}, {6550, 2300}},
+		WhitePoint:   [2]uint16{15635, 16450},
+		MaxLuminance: 1000,
+		MinLuminance: 50,
+		MaxCLL:       1000,
+		MaxFALL:      400,
+	}
+
+	require.Equal(t, []byte{
+		9, 16, 9, 0x10,
+		0x03, 0xE8, 0x00, 0x32,
+		0x8A, 0x48, 0x39, 0x08,
+		0x21, 0x34, 0x9B, 0xAA,
+		0x19, 0x96, 0x08, 0xFC,
+		0x3D, 0x13, 0x40, 0x42,
+		0x03, 0xE8, 0x01, 0x90,
+	}, m.ColorSpace())
+}
@@ -0,0 +1,38 @@
+package leakcheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	grace_period = 2 * time.Second
+	poll_period  = 10 * time.Millisecond
+)
+
+// Check records the supervised goroutines running now and, when the test
+// finishes, fails it if any call site still runs more than it did then
+func Check(t testing.TB) {
+	baseline := thread.Running()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(grace_period)
+		for {
+			leaked := map[string]int{}
+			for site, count := range thread.Running() {
+				if count > baseline[site] {
+					leaked[site] = count - baseline[site]
+				}
+			}
+
+			if len(leaked) == 0 {
+				return
+			} else if time.Now().After(deadline) {
+				t.Errorf("goroutines still running after teardown %v", leaked)
+				return
+			}
+			time.Sleep(poll_period)
+		}
+	})
+}
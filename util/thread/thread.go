@@ -1,98 +1,135 @@
 package thread
 
 import (
+	"context"
 	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"time"
 )
 
-func SafeThread(fun func()) {
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Printf("panic happened in safe thread %v", err)
-			}
-		}()
+const (
+	wait_period = time.Millisecond * 100
+)
 
-		fun()
-	}()
+// supervisor counts the goroutines started by this package, keyed by the
+// call site that started them, so leaked ones can be traced back
+type supervisor struct {
+	mut     *sync.Mutex
+	running map[string]int
 }
 
-func SafeWait(pass_condition func() bool, exe func()) chan error {
-	ret := make(chan error)
-	wait := func() (_break bool) {
-		defer func() {
-			if err := recover(); err != nil {
-				ret <- fmt.Errorf("panic happened in safe compare %v", err)
-				_break = false
-			}
-		}()
+var super = &supervisor{
+	mut:     &sync.Mutex{},
+	running: map[string]int{},
+}
 
-		return pass_condition()
+func (s *supervisor) add(site string, delta int) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.running[site] += delta
+	if s.running[site] == 0 {
+		delete(s.running, site)
 	}
+}
 
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				ret <- fmt.Errorf("panic happened in safe wait %v", err)
-			}
-		}()
-		for !wait() {
-			time.Sleep(time.Millisecond * 100)
-		}
-
-		exe()
-	}()
+// Running reports the supervised goroutines still alive per call site
+func Running() map[string]int {
+	super.mut.Lock()
+	defer super.mut.Unlock()
+	ret := map[string]int{}
+	for site, count := range super.running {
+		ret[site] = count
+	}
 	return ret
 }
 
-func TriggerStop(stop chan bool) {
-	if len(stop) > 0 {
-		return
+// caller names the call site of the exported function calling it
+func caller() string {
+	_, file, line, ok := runtime.Caller(2)
+	if !ok {
+		return "unknown"
 	}
-
-	stop <- true
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
 }
 
-func SafeLoop(stop chan bool, sleep_period time.Duration, fun func()) {
-	loop := func() {
+func spawn(site string, fun func()) {
+	super.add(site, 1)
+	go func() {
+		defer super.add(site, -1)
 		defer func() {
 			if err := recover(); err != nil {
-				fmt.Printf("panic happened in safe loop %v", err)
+				fmt.Printf("panic happened in thread %s %v\n", site, err)
 			}
 		}()
 
 		fun()
-	}
+	}()
+}
 
-	go func() {
-		for len(stop) == 0 {
-			loop()
-			time.Sleep(sleep_period)
+func safe(fun func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Printf("panic happened in safe loop %v\n", err)
 		}
 	}()
+
+	fun()
 }
 
-func SafeSelect(stop chan bool, target chan interface{}, fun func(interface{})) {
-	loop := func(i interface{}) {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Printf("panic happened in safe loop %v", err)
+func SafeThread(fun func()) {
+	spawn(caller(), fun)
+}
+
+// SafeWait runs exe once pass_condition holds, it gives up when ctx is done
+func SafeWait(ctx context.Context, pass_condition func() bool, exe func()) {
+	spawn(caller(), func() {
+		for !pass_condition() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait_period):
 			}
-		}()
+		}
 
-		fun(i)
-	}
+		exe()
+	})
+}
+
+// OnDone runs fun once ctx is cancelled
+func OnDone(ctx context.Context, fun func()) {
+	spawn(caller(), func() {
+		<-ctx.Done()
+		fun()
+	})
+}
+
+func SafeLoop(ctx context.Context, sleep_period time.Duration, fun func()) {
+	spawn(caller(), func() {
+		for ctx.Err() == nil {
+			safe(fun)
+			if sleep_period == 0 {
+				continue
+			}
 
-	go func() {
-		exit := false
-		for !exit {
 			select {
-			case <-stop:
-				exit = true
-				go func() { stop <- true }()
+			case <-ctx.Done():
+			case <-time.After(sleep_period):
+			}
+		}
+	})
+}
+
+func SafeSelect(ctx context.Context, target chan interface{}, fun func(interface{})) {
+	spawn(caller(), func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
 			case out := <-target:
-				loop(out)
+				safe(func() { fun(out) })
 			}
 		}
-	}()
+	})
 }
@@ -1,4 +1,17 @@
 package thread
 
+import "context"
+
 func HighPriorityThread() {
-}
\ No newline at end of file
+}
+
+// HighPriorityLoop runs fun until ctx is done, fun should return
+// regularly or select on ctx itself
+func HighPriorityLoop(ctx context.Context, fun func()) {
+	spawn(caller(), func() {
+		HighPriorityThread()
+		for ctx.Err() == nil {
+			safe(fun)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package thread
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupervisor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan interface{}, 1)
+	SafeLoop(ctx, time.Millisecond, func() {})
+	SafeSelect(ctx, ran, func(interface{}) {})
+	SafeWait(ctx, func() bool { return false }, func() {})
+	require.Len(t, Running(), 3)
+
+	cancel()
+	require.Eventually(t, func() bool { return len(Running()) == 0 }, time.Second, time.Millisecond*10)
+}
+
+func TestPanicRecovered(t *testing.T) {
+	done := make(chan bool)
+	SafeThread(func() {
+		defer close(done)
+		panic("boom")
+	})
+	<-done
+	require.Eventually(t, func() bool { return len(Running()) == 0 }, time.Second, time.Millisecond*10)
+}
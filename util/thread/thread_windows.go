@@ -63,7 +63,10 @@ int SetGPURealtimePriority() {
 
 */
 import "C"
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 func init() {
 	resulta := C.SetPriorityClass(C.GetCurrentProcess(), C.REALTIME_PRIORITY_CLASS)
@@ -75,22 +78,13 @@ func init() {
 	}
 }
 
-func HighPriorityLoop(stop chan bool, fun func()) {
-	wrapper := func() {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Printf("panic happened in thread %v", err)
-			}
-		}()
-
-		fun()
-	}
-	SafeThread(func() {
+// HighPriorityLoop runs fun on a high priority thread until ctx is done,
+// fun should return regularly or select on ctx itself
+func HighPriorityLoop(ctx context.Context, fun func()) {
+	spawn(caller(), func() {
 		C.SetThreadPriority(C.GetCurrentThread(), C.THREAD_PRIORITY_HIGHEST)
-		for len(stop) == 0 {
-			wrapper()
+		for ctx.Err() == nil {
+			safe(fun)
 		}
-
-		<-stop
 	})
 }
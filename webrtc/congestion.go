@@ -0,0 +1,33 @@
+package webrtc
+
+import (
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+)
+
+const (
+	// initial_estimate is where the estimate starts before any feedback,
+	// high enough to leave every layer on until congestion shows
+	initial_estimate = 10_000_000
+	max_estimate     = 100_000_000
+)
+
+// newEstimator runs Google congestion control over the transport-cc
+// feedback of a session, browsers send no REMB once transport-cc is
+// negotiated. Packets leave through the client pacer, so the estimator
+// does not pace them again
+func newEstimator(initial int, follow func(bps int)) (cc.BandwidthEstimator, error) {
+	estimator, err := gcc.NewSendSideBWE(
+		gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		gcc.SendSideBWEInitialBitrate(initial),
+		gcc.SendSideBWEMaxBitrate(max_estimate),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if follow != nil {
+		estimator.OnTargetBitrateChange(follow)
+	}
+	return estimator, nil
+}
@@ -0,0 +1,77 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+)
+
+// TestTransportCC drives the layer selector from transport-cc feedback
+// reporting heavy loss, as a browser sending no REMB does
+func TestTransportCC(t *testing.T) {
+	lis := frames{}
+	for i := 0; i < 400; i++ {
+		lis[uint32(i*3000)] = clock.Frame{FrameNumber: uint16(i), TemporalID: l1t3[i%len(l1t3)]}
+	}
+	now := time.Unix(1700000000, 0)
+	selector := newLayerSelector(lis, func() time.Time { return now })
+
+	estimates := make(chan int, 16)
+	estimator, err := newEstimator(300_000, func(bps int) {
+		selector.setEstimate(float64(bps))
+		estimates <- bps
+	})
+	require.Nil(t, err)
+	defer estimator.Close()
+
+	const ssrc, id = 1, 5
+	info := &interceptor.StreamInfo{
+		SSRC:                ssrc,
+		RTPHeaderExtensions: []interceptor.RTPHeaderExtension{{URI: sdp.TransportCCURI, ID: id}},
+	}
+	writer := estimator.AddStream(info, interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, _ interceptor.Attributes) (int, error) {
+		return len(payload), nil
+	}))
+
+	// one packet in five arrives, spaced as they were sent
+	recorder := twcc.NewRecorder(2)
+	for seq := uint16(0); seq < 40; seq++ {
+		header := &rtp.Header{Version: 2, SSRC: ssrc, SequenceNumber: seq}
+		extension, err := (&rtp.TransportCCExtension{TransportSequence: seq}).Marshal()
+		require.Nil(t, err)
+		require.Nil(t, header.SetExtension(id, extension))
+		_, err = writer.Write(header, make([]byte, 1000), nil)
+		require.Nil(t, err)
+		if seq%5 == 0 {
+			recorder.Record(ssrc, seq, int64(seq)*6000)
+		}
+		time.Sleep(time.Millisecond * 6)
+	}
+	require.Nil(t, estimator.WriteRTCP(recorder.BuildFeedbackPacket(), nil))
+
+	select {
+	case bps := <-estimates:
+		require.Less(t, bps, 240_000)
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "no estimate from transport-cc feedback")
+	}
+
+	// 60kbps on each of the two lower layers and 120kbps on the top one
+	// no longer fit, the top layer is dropped
+	forwarded := map[int]int{}
+	for frame := 0; frame < 120; frame++ {
+		pk := &rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(frame), Timestamp: uint32(frame * 3000)}, Payload: make([]byte, 1000)}
+		if _, ok := selector.forward(pk); ok && frame >= 90 {
+			forwarded[lis[pk.Timestamp].TemporalID]++
+		}
+		now = now.Add(time.Second / 30)
+	}
+	require.Zero(t, forwarded[2])
+	require.NotZero(t, forwarded[0])
+}
@@ -0,0 +1,195 @@
+package webrtc
+
+import (
+	"encoding/binary"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
+)
+
+const (
+	PlayoutDelayURI   = "http://www.webrtc.org/experiments/rtp-hdrext/playout-delay"
+	AbsCaptureTimeURI = "http://www.webrtc.org/experiments/rtp-hdrext/abs-capture-time"
+	VideoTimingURI    = "http://www.webrtc.org/experiments/rtp-hdrext/video-timing"
+
+	// ids_interval is how often the negotiated ids are looked up again
+	ids_interval = time.Second
+
+	// timing_by_timer flags a video-timing frame picked by interval
+	timing_by_timer = 1 << 0
+)
+
+// headerExtension is one extension to write on a packet
+type headerExtension struct {
+	id      uint8
+	payload []byte
+}
+
+// extensionWriter writes the header extensions negotiated for one track
+// of a session
+type extensionWriter struct {
+	conf   config.ExtensionConfig
+	sender *webrtc.RTPSender
+	uris   []string
+
+	colorspace interface{ ColorSpace() []byte }
+	frames     listener.Frames
+	codec      string
+
+	ids      map[string]uint8
+	resolved time.Time
+	// timed is when the last frame carried video-timing
+	timed time.Time
+	// refs follows the layers of the frames sent to the viewer
+	refs references
+}
+
+func newExtensionWriter(conf config.ExtensionConfig, lis listener.Listener,
+	sender *webrtc.RTPSender, kind webrtc.RTPCodecType) *extensionWriter {
+	w := &extensionWriter{
+		conf:   conf,
+		sender: sender,
+		uris:   extensions[kind],
+		ids:    map[string]uint8{},
+	}
+	w.colorspace, _ = lis.(interface{ ColorSpace() []byte })
+	w.frames, _ = lis.(listener.Frames)
+	w.codec = lis.GetCodec()
+	return w
+}
+
+// resolve looks up the negotiated ids again once ids_interval passed
+func (w *extensionWriter) resolve(now time.Time) {
+	if w.sender != nil && now.Sub(w.resolved) > ids_interval {
+		for _, uri := range w.uris {
+			w.ids[uri] = extensionID(w.sender, uri)
+		}
+		w.resolved = now
+	}
+}
+
+// write returns pk carrying the extensions that apply to it, a copy
+// when there is any
+func (w *extensionWriter) write(pk *rtp.Packet, now time.Time) *rtp.Packet {
+	w.resolve(now)
+
+	var (
+		frame  clock.Frame
+		found  bool
+		looked bool
+	)
+	lookup := func() (clock.Frame, bool) {
+		if !looked && w.frames != nil {
+			frame, found = w.frames.Frame(pk.Timestamp)
+		}
+		looked = true
+		return frame, found
+	}
+
+	exts := []headerExtension{}
+	for _, uri := range w.uris {
+		id := w.ids[uri]
+		if id == 0 {
+			continue
+		}
+
+		var payload []byte
+		switch uri {
+		case sdp.ABSSendTimeURI:
+			payload, _ = rtp.NewAbsSendTimeExtension(now).Marshal()
+		case PlayoutDelayURI:
+			if w.conf.PlayoutDelay {
+				payload, _ = rtp.PlayoutDelayExtension{
+					MinDelay: uint16(w.conf.MinDelay / (10 * time.Millisecond)),
+					MaxDelay: uint16(w.conf.MaxDelay / (10 * time.Millisecond)),
+				}.Marshal()
+			}
+		case AbsCaptureTimeURI:
+			if frame, ok := lookup(); ok && pk.Marker && !frame.Captured.IsZero() {
+				payload, _ = rtp.NewAbsCaptureTimeExtension(frame.Captured).Marshal()
+			}
+		case VideoTimingURI:
+			if !pk.Marker || w.conf.VideoTiming <= 0 || now.Sub(w.timed) < w.conf.VideoTiming {
+				break
+			} else if frame, ok := lookup(); ok {
+				if payload = videoTiming(frame, now); payload != nil {
+					w.timed = now
+				}
+			}
+		case hdr.ColorSpaceURI:
+			if pk.Marker && w.colorspace != nil {
+				payload = w.colorspace.ColorSpace()
+			}
+		}
+		if payload != nil {
+			exts = append(exts, headerExtension{id: id, payload: payload})
+		}
+	}
+	return withExtensions(pk, exts...)
+}
+
+// videoTiming is the video-timing payload of frame leaving the host at
+// now, deltas are milliseconds since capture. It is nil unless the
+// encoder reported its timings
+func videoTiming(frame clock.Frame, now time.Time) []byte {
+	if frame.Captured.IsZero() || frame.EncodeStart.IsZero() || frame.EncodeFinish.IsZero() {
+		return nil
+	}
+
+	delta := func(t time.Time) uint16 {
+		ms := t.Sub(frame.Captured).Milliseconds()
+		if ms < 0 {
+			return 0
+		} else if ms > 0xFFFF {
+			return 0xFFFF
+		}
+		return uint16(ms)
+	}
+
+	b := []byte{timing_by_timer}
+	for _, t := range []time.Time{frame.EncodeStart, frame.EncodeFinish, frame.Packetized, now} {
+		b = binary.BigEndian.AppendUint16(b, delta(t))
+	}
+	// network timestamps are left to relays
+	return append(b, 0, 0, 0, 0)
+}
+
+// withExtensions copies pk with exts on top of the extensions it already
+// carries, packets are shared by every session so they are never modified
+// in place. Extensions needing the two byte header go first so the
+// profile fits every one of them
+func withExtensions(pk *rtp.Packet, exts ...headerExtension) *rtp.Packet {
+	if len(exts) == 0 {
+		return pk
+	}
+	for _, id := range pk.GetExtensionIDs() {
+		if !slices.ContainsFunc(exts, func(ext headerExtension) bool { return ext.id == id }) {
+			exts = append(exts, headerExtension{id: id, payload: pk.GetExtension(id)})
+		}
+	}
+	sort.SliceStable(exts, func(i, j int) bool {
+		return twoByte(exts[i]) && !twoByte(exts[j])
+	})
+
+	header := pk.Header.Clone()
+	header.Extension, header.ExtensionProfile, header.Extensions = false, 0, nil
+	clone := &rtp.Packet{Header: header, Payload: pk.Payload}
+	for _, ext := range exts {
+		if err := clone.SetExtension(ext.id, ext.payload); err != nil {
+			return pk
+		}
+	}
+	return clone
+}
+
+func twoByte(ext headerExtension) bool {
+	return ext.id > 14 || len(ext.payload) > 16
+}
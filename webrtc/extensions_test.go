@@ -0,0 +1,82 @@
+package webrtc
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
+)
+
+type frames map[uint32]clock.Frame
+
+func (f frames) GetCodec() string                             { return webrtc.MimeTypeH264 }
+func (f frames) RegisterRTPHandler(string, func(*rtp.Packet)) {}
+func (f frames) DeregisterRTPHandler(string)                  {}
+func (f frames) Close()                                       {}
+func (f frames) Frame(timestamp uint32) (frame clock.Frame, ok bool) {
+	frame, ok = f[timestamp]
+	return
+}
+
+func TestWithExtensions(t *testing.T) {
+	pk := &rtp.Packet{Header: rtp.Header{Marker: true}, Payload: []byte{1}}
+	colorspace := hdr.Metadata{MaxLuminance: 1000}.ColorSpace()
+
+	require.Same(t, pk, withExtensions(pk))
+
+	extended := withExtensions(pk, headerExtension{id: 1, payload: []byte{1, 2, 3}}, headerExtension{id: 3, payload: colorspace})
+	require.Equal(t, colorspace, extended.GetExtension(3))
+	require.Equal(t, []byte{1, 2, 3}, extended.GetExtension(1))
+	require.False(t, pk.Extension)
+
+	raw, err := extended.Marshal()
+	require.Nil(t, err)
+	parsed := &rtp.Packet{}
+	require.Nil(t, parsed.Unmarshal(raw))
+	require.Equal(t, colorspace, parsed.GetExtension(3))
+	require.Equal(t, []byte{1, 2, 3}, parsed.GetExtension(1))
+}
+
+func TestExtensionWriter(t *testing.T) {
+	captured := time.Unix(1700000000, 0)
+	lis := frames{100: {
+		Timestamp:    100,
+		Captured:     captured,
+		EncodeStart:  captured.Add(2 * time.Millisecond),
+		EncodeFinish: captured.Add(7 * time.Millisecond),
+		Packetized:   captured.Add(8 * time.Millisecond),
+	}}
+	w := newExtensionWriter(config.ExtensionConfig{PlayoutDelay: true, MaxDelay: 100 * time.Millisecond, VideoTiming: time.Second},
+		lis, nil, webrtc.RTPCodecTypeVideo)
+	w.ids = map[string]uint8{sdp.ABSSendTimeURI: 1, PlayoutDelayURI: 2, AbsCaptureTimeURI: 3, VideoTimingURI: 4}
+
+	now := captured.Add(10 * time.Millisecond)
+	first := w.write(&rtp.Packet{Header: rtp.Header{Timestamp: 100}}, now)
+	require.NotNil(t, first.GetExtension(1))
+	require.Equal(t, []byte{0, 0, 10}, first.GetExtension(2))
+	require.Nil(t, first.GetExtension(3))
+	require.Nil(t, first.GetExtension(4))
+
+	last := w.write(&rtp.Packet{Header: rtp.Header{Timestamp: 100, Marker: true}}, now)
+	capture := rtp.AbsCaptureTimeExtension{}
+	require.Nil(t, capture.Unmarshal(last.GetExtension(3)))
+	require.Equal(t, captured.UnixMilli(), capture.CaptureTime().UnixMilli())
+
+	timing := last.GetExtension(4)
+	require.Len(t, timing, 13)
+	require.Equal(t, byte(timing_by_timer), timing[0])
+	for i, ms := range []uint16{2, 7, 8, 10} {
+		require.Equal(t, ms, binary.BigEndian.Uint16(timing[1+2*i:]))
+	}
+
+	// video-timing waits for the interval
+	next := w.write(&rtp.Packet{Header: rtp.Header{Timestamp: 100, Marker: true}}, now.Add(time.Millisecond))
+	require.Nil(t, next.GetExtension(4))
+}
@@ -0,0 +1,244 @@
+package webrtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/av1"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
+)
+
+const (
+	// layer_window is how long the rate of each temporal layer is
+	// measured before the selector reconsiders its target
+	layer_window = time.Second
+	// layer_headroom is how far the estimate has to exceed the rate of a
+	// layer before it is forwarded again
+	layer_headroom = 1.2
+
+	layers = av1.MaxTemporalID + 1
+)
+
+// references follows the temporal layers of the frames a viewer receives
+// to tell what each one depends on. A base layer frame refers to the
+// previous one, any other frame to the latest frame of a lower layer
+type references struct {
+	started   bool
+	timestamp uint32
+
+	last [layers]uint16
+	seen [layers]bool
+	top  int
+	tl0  uint8
+
+	// of the current frame
+	frame    clock.Frame
+	fdiffs   []uint16
+	chain    uint16
+	baseSync bool
+}
+
+// next moves to the frame of pk, true when pk starts it
+func (r *references) next(pk *rtp.Packet, frame clock.Frame) bool {
+	if r.started && pk.Timestamp == r.timestamp {
+		return false
+	}
+	r.started, r.timestamp = true, pk.Timestamp
+
+	tid := min(max(frame.TemporalID, 0), layers-1)
+	frame.TemporalID = tid
+	r.frame, r.fdiffs, r.chain, r.baseSync = frame, nil, 0, false
+	if frame.Keyframe {
+		r.seen = [layers]bool{}
+	} else {
+		// the base layer refers to itself, the others to the layers below
+		ref, below := -1, max(tid, 1)
+		for layer := 0; layer < below; layer++ {
+			if r.seen[layer] && (ref < 0 || frame.FrameNumber-r.last[layer] < frame.FrameNumber-r.last[ref]) {
+				ref = layer
+			}
+		}
+		if ref >= 0 {
+			r.fdiffs = []uint16{frame.FrameNumber - r.last[ref]}
+			r.baseSync = tid > 0 && ref == 0
+		}
+		if r.seen[0] {
+			r.chain = frame.FrameNumber - r.last[0]
+		}
+	}
+
+	if tid == 0 {
+		r.tl0++
+	}
+	r.last[tid], r.seen[tid] = frame.FrameNumber, true
+	r.top = max(r.top, tid)
+	return true
+}
+
+// layer writes the dependency descriptor or frame marking of pk. It runs
+// in packet order for the packets a viewer receives, before they are kept
+// for retransmission
+func (w *extensionWriter) layer(pk *rtp.Packet, now time.Time) *rtp.Packet {
+	w.resolve(now)
+	if w.frames == nil {
+		return pk
+	}
+
+	var uri string
+	switch w.codec {
+	case webrtc.MimeTypeAV1:
+		uri = av1.DependencyDescriptorURI
+	case webrtc.MimeTypeH264:
+		uri = h264.FrameMarkingURI
+	default:
+		return pk
+	}
+	id := w.ids[uri]
+	if id == 0 {
+		return pk
+	}
+	frame, ok := w.frames.Frame(pk.Timestamp)
+	if !ok {
+		return pk
+	}
+
+	start := w.refs.next(pk, frame)
+	frame = w.refs.frame
+
+	var payload []byte
+	switch uri {
+	case av1.DependencyDescriptorURI:
+		payload = av1.DependencyDescriptor{
+			StartOfFrame: start,
+			EndOfFrame:   pk.Marker,
+			FrameNumber:  frame.FrameNumber,
+			TemporalID:   frame.TemporalID,
+			Structure:    start && frame.Keyframe,
+			FrameDiffs:   w.refs.fdiffs,
+			ChainDiff:    w.refs.chain,
+		}.Marshal()
+	case h264.FrameMarkingURI:
+		payload = h264.FrameMarking{
+			Start:       start,
+			End:         pk.Marker,
+			Independent: frame.Keyframe,
+			Discardable: frame.TemporalID > 0 && frame.TemporalID >= w.refs.top,
+			BaseSync:    w.refs.baseSync,
+			TemporalID:  uint8(frame.TemporalID),
+			TL0PICIDX:   w.refs.tl0,
+		}.Marshal()
+	}
+	return withExtensions(pk, headerExtension{id: id, payload: payload})
+}
+
+// layerSelector forwards the temporal layers of a video track that fit
+// the bandwidth estimate of a viewer. Layers are dropped at any frame and
+// added back on base layer frames, sequence numbers skip over the packets
+// of dropped frames
+type layerSelector struct {
+	mut    *sync.Mutex
+	frames listener.Frames
+	now    func() time.Time
+
+	// estimate is the viewer bandwidth estimate in bits per second
+	estimate float64
+	bytes    [layers]float64
+	window   time.Time
+	// target is the highest layer fitting the estimate, max the highest
+	// one forwarded
+	target, max int
+
+	started    bool
+	timestamp  uint32
+	tid        int
+	forwarding bool
+	dropped    uint16
+}
+
+func newLayerSelector(lis listener.Listener, now func() time.Time) *layerSelector {
+	s := &layerSelector{
+		mut:    &sync.Mutex{},
+		now:    now,
+		window: now(),
+		target: layers - 1,
+		max:    layers - 1,
+	}
+	s.frames, _ = lis.(listener.Frames)
+	return s
+}
+
+// setEstimate follows the bandwidth estimate of the viewer in bits per
+// second
+func (s *layerSelector) setEstimate(bps float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.estimate = bps
+}
+
+// forward returns pk renumbered past the dropped packets, ok is false
+// when its frame is dropped
+func (s *layerSelector) forward(pk *rtp.Packet) (_ *rtp.Packet, ok bool) {
+	if s.frames == nil {
+		return pk, true
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.started || pk.Timestamp != s.timestamp {
+		s.started, s.timestamp, s.tid = true, pk.Timestamp, 0
+		if frame, ok := s.frames.Frame(pk.Timestamp); ok {
+			s.tid = min(max(frame.TemporalID, 0), layers-1)
+		}
+
+		s.measure(s.now())
+		if s.target < s.max || s.tid == 0 {
+			s.max = s.target
+		}
+		s.forwarding = s.tid <= s.max
+	}
+
+	s.bytes[s.tid] += float64(len(pk.Payload))
+	if !s.forwarding {
+		s.dropped++
+		return nil, false
+	} else if s.dropped == 0 {
+		return pk, true
+	}
+
+	clone := &rtp.Packet{Header: pk.Header.Clone(), Payload: pk.Payload}
+	clone.SequenceNumber -= s.dropped
+	return clone, true
+}
+
+// measure picks the target once a window of layer rates is complete,
+// every layer is forwarded until there is an estimate
+func (s *layerSelector) measure(now time.Time) {
+	elapsed := now.Sub(s.window)
+	if elapsed < layer_window {
+		return
+	}
+	s.window = now
+
+	target, rate := 0, 0.0
+	for tid := range s.bytes {
+		rate += s.bytes[tid] * 8 / elapsed.Seconds()
+		s.bytes[tid] = 0
+
+		limit := s.estimate
+		if tid > s.max {
+			limit /= layer_headroom
+		}
+		if tid > 0 && s.estimate > 0 && rate > limit {
+			break
+		}
+		target = tid
+	}
+	for tid := target + 1; tid < layers; tid++ {
+		s.bytes[tid] = 0
+	}
+	s.target = target
+}
@@ -0,0 +1,115 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+// l1t3 are the temporal layers of a three layer stream by frame number
+var l1t3 = []int{0, 2, 1, 2}
+
+func TestReferences(t *testing.T) {
+	r := references{}
+	for i, expected := range []struct {
+		fdiffs   []uint16
+		chain    uint16
+		baseSync bool
+	}{
+		{nil, 0, false},
+		{[]uint16{1}, 1, true},
+		{[]uint16{2}, 2, true},
+		{[]uint16{1}, 3, false},
+		{[]uint16{4}, 4, false},
+	} {
+		frame := clock.Frame{FrameNumber: uint16(i), TemporalID: l1t3[i%len(l1t3)], Keyframe: i == 0}
+		require.True(t, r.next(&rtp.Packet{Header: rtp.Header{Timestamp: uint32(i)}}, frame))
+		require.False(t, r.next(&rtp.Packet{Header: rtp.Header{Timestamp: uint32(i)}}, frame))
+		require.Equal(t, expected.fdiffs, r.fdiffs, i)
+		require.Equal(t, expected.chain, r.chain, i)
+		require.Equal(t, expected.baseSync, r.baseSync, i)
+	}
+	require.Equal(t, uint8(2), r.tl0)
+	require.Equal(t, 2, r.top)
+}
+
+func TestFrameMarking(t *testing.T) {
+	lis := frames{0: {Keyframe: true}, 3000: {FrameNumber: 1, TemporalID: 1}}
+	w := newExtensionWriter(config.ExtensionConfig{}, lis, nil, webrtc.RTPCodecTypeVideo)
+	w.ids = map[string]uint8{h264.FrameMarkingURI: 5}
+
+	pk := w.layer(&rtp.Packet{Header: rtp.Header{Timestamp: 0, Marker: true}}, time.Now())
+	require.Equal(t, h264.FrameMarking{Start: true, End: true, Independent: true, TL0PICIDX: 1}.Marshal(), pk.GetExtension(5))
+
+	pk = w.layer(&rtp.Packet{Header: rtp.Header{Timestamp: 3000}}, time.Now())
+	require.Equal(t, h264.FrameMarking{Start: true, Discardable: true, BaseSync: true, TemporalID: 1, TL0PICIDX: 1}.Marshal(), pk.GetExtension(5))
+
+	// extensions written as the packet leaves keep the marking
+	pk = withExtensions(pk, headerExtension{id: 1, payload: []byte{1}})
+	require.NotNil(t, pk.GetExtension(5))
+	require.Equal(t, []byte{1}, pk.GetExtension(1))
+}
+
+func TestLayerSelector(t *testing.T) {
+	lis := frames{}
+	for i := 0; i < 400; i++ {
+		lis[uint32(i*3000)] = clock.Frame{FrameNumber: uint16(i), TemporalID: l1t3[i%len(l1t3)]}
+	}
+
+	now := time.Unix(1700000000, 0)
+	s := newLayerSelector(lis, func() time.Time { return now })
+
+	// one 1000 byte packet a frame at 30 fps, 60kbps on each of the two
+	// lower layers and 120kbps on the top one
+	seq, frame := uint16(0), 0
+	send := func(frames int) (forwarded []*rtp.Packet) {
+		for end := frame + frames; frame < end; frame++ {
+			pk := &rtp.Packet{Header: rtp.Header{SequenceNumber: seq, Timestamp: uint32(frame * 3000)}, Payload: make([]byte, 1000)}
+			if pk, ok := s.forward(pk); ok {
+				forwarded = append(forwarded, pk)
+			}
+			seq++
+			now = now.Add(time.Second / 30)
+		}
+		return
+	}
+	layersOf := func(packets []*rtp.Packet) map[int]int {
+		count := map[int]int{}
+		for _, pk := range packets {
+			count[lis[pk.Timestamp].TemporalID]++
+		}
+		return count
+	}
+	contiguous := func(packets []*rtp.Packet) {
+		for i := 1; i < len(packets); i++ {
+			require.Equal(t, packets[i-1].SequenceNumber+1, packets[i].SequenceNumber)
+		}
+	}
+
+	// every layer goes until there is an estimate
+	forwarded := send(60)
+	require.Len(t, forwarded, 60)
+
+	s.setEstimate(150_000)
+	forwarded = append(forwarded, send(60)...)
+	require.Equal(t, 0, layersOf(forwarded[len(forwarded)-20:])[2])
+	contiguous(forwarded)
+
+	s.setEstimate(100_000)
+	forwarded = append(forwarded, send(60)...)
+	require.Equal(t, map[int]int{0: 8}, layersOf(forwarded[len(forwarded)-8:]))
+	contiguous(forwarded)
+
+	// layers come back on base layer frames once the estimate has room
+	s.setEstimate(1_000_000)
+	resumed := send(60)
+	require.Equal(t, 0, lis[resumed[0].Timestamp].TemporalID)
+	require.Len(t, layersOf(resumed[len(resumed)-30:]), 3)
+	contiguous(append(forwarded, resumed...))
+}
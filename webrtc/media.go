@@ -0,0 +1,170 @@
+package webrtc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/report"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/av1"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/h264"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/opus"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
+)
+
+var (
+	// codecs are offered on top of pion defaults, HEVC is not among them
+	codecs = []webrtc.RTPCodecParameters{{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:     webrtc.MimeTypeH265,
+			ClockRate:    90000,
+			RTCPFeedback: []webrtc.RTCPFeedback{{Type: "goog-remb"}, {Type: "ccm", Parameter: "fir"}, {Type: "nack"}, {Type: "nack", Parameter: "pli"}},
+		},
+		PayloadType: 116,
+	}, {
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    "video/rtx",
+			ClockRate:   90000,
+			SDPFmtpLine: "apt=116",
+		},
+		PayloadType: 117,
+	}}
+
+	// opus_payload_types number the opus codecs by channel count, pion
+	// defaults leave 118 and 119 free
+	opus_payload_types = map[int]webrtc.PayloadType{2: 111, 6: 118, 8: 119}
+
+	// extensions are written by the host on every session, their ids are
+	// picked during negotiation
+	extensions = map[webrtc.RTPCodecType][]string{
+		webrtc.RTPCodecTypeVideo: {
+			sdp.ABSSendTimeURI,
+			PlayoutDelayURI,
+			AbsCaptureTimeURI,
+			VideoTimingURI,
+			hdr.ColorSpaceURI,
+			av1.DependencyDescriptorURI,
+			h264.FrameMarkingURI,
+		},
+		webrtc.RTPCodecTypeAudio: {
+			sdp.ABSSendTimeURI,
+			AbsCaptureTimeURI,
+		},
+	}
+)
+
+// mediaEngine registers pion default codecs and interceptors, plus the
+// codecs and header extensions the host adds. Sender reports and NACK
+// responses are left to the client, they follow the capture timeline of
+// each listener and go through the pacer. estimate follows the bandwidth
+// estimate built from transport-cc feedback
+func mediaEngine(conf config.OpusConfig, estimate func(bps int)) (*webrtc.MediaEngine, *interceptor.Registry, error) {
+	engine := &webrtc.MediaEngine{}
+	// opus goes first so its fmtp replaces the pion default
+	for _, channels := range []int{2, 6, 8} {
+		codec := webrtc.RTPCodecParameters{
+			RTPCodecCapability: opusCapability(conf, channels),
+			PayloadType:        opus_payload_types[channels],
+		}
+		if err := engine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := engine.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, err
+	}
+	for _, codec := range codecs {
+		if err := engine.RegisterCodec(codec, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, nil, err
+		}
+	}
+	for kind, uris := range extensions {
+		for _, uri := range uris {
+			if err := engine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, kind); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// NACKs of the viewer are answered by the client through its pacer
+	engine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	engine.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+
+	registry := &interceptor.Registry{}
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return nil, nil, err
+	}
+	registry.Add(generator)
+	receiver, err := report.NewReceiverInterceptor()
+	if err != nil {
+		return nil, nil, err
+	}
+	registry.Add(receiver)
+	if err := webrtc.ConfigureSimulcastExtensionHeaders(engine); err != nil {
+		return nil, nil, err
+	}
+	congestion, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return newEstimator(initial_estimate, estimate)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	registry.Add(congestion)
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(engine, registry); err != nil {
+		return nil, nil, err
+	}
+	if err := webrtc.ConfigureTWCCSender(engine, registry); err != nil {
+		return nil, nil, err
+	}
+	return engine, registry, nil
+}
+
+// opusCapability is the codec of an audio track carrying channels, mono
+// and stereo are opus and surround layouts multiopus
+func opusCapability(conf config.OpusConfig, channels int) webrtc.RTPCodecCapability {
+	capability := webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}
+	params := []string{"minptime=10"}
+	if layout, found := opus.Layouts[channels]; found {
+		mapping := []string{}
+		for _, channel := range layout.Mapping {
+			mapping = append(mapping, strconv.Itoa(int(channel)))
+		}
+		capability.MimeType, capability.Channels = opus.MimeTypeMultiOpus, uint16(channels)
+		params = append(params,
+			"channel_mapping="+strings.Join(mapping, ","),
+			fmt.Sprintf("num_streams=%d", layout.Streams),
+			fmt.Sprintf("coupled_streams=%d", layout.Coupled))
+	} else if channels != 1 && conf.Stereo {
+		params = append(params, "stereo=1", "sprop-stereo=1")
+	}
+
+	if conf.InbandFEC {
+		params = append(params, "useinbandfec=1")
+	}
+	if conf.DTX {
+		params = append(params, "usedtx=1")
+	}
+	if conf.MaxAverageBitrate > 0 {
+		params = append(params, fmt.Sprintf("maxaveragebitrate=%d", conf.MaxAverageBitrate))
+	}
+	capability.SDPFmtpLine = strings.Join(params, ";")
+	return capability
+}
+
+// extensionID is the id negotiated for uri on sender, zero until the
+// peer accepted it
+func extensionID(sender *webrtc.RTPSender, uri string) uint8 {
+	for _, extension := range sender.GetParameters().HeaderExtensions {
+		if extension.URI == uri {
+			return uint8(extension.ID)
+		}
+	}
+	return 0
+}
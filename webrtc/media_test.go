@@ -0,0 +1,51 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/hdr"
+)
+
+func TestOfferHEVC(t *testing.T) {
+	api, err := newAPI(config.NetworkConfig{}, config.OpusConfig{}, nil)
+	require.Nil(t, err)
+	conn, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH265}, "video", "stream")
+	require.Nil(t, err)
+	_, err = conn.AddTrack(track)
+	require.Nil(t, err)
+
+	offer, err := conn.CreateOffer(nil)
+	require.Nil(t, err)
+	require.Contains(t, offer.SDP, "a=rtpmap:116 H265/90000")
+	require.Contains(t, offer.SDP, hdr.ColorSpaceURI)
+	require.Contains(t, offer.SDP, PlayoutDelayURI)
+}
+
+func TestOfferOpus(t *testing.T) {
+	conf := config.OpusConfig{Stereo: true, InbandFEC: true, MaxAverageBitrate: 128000}
+	api, err := newAPI(config.NetworkConfig{}, conf, nil)
+	require.Nil(t, err)
+	conn, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer conn.Close()
+
+	track, err := newSurroundTrack(opusCapability(conf, 6), opusCapability(conf, 2), "audio", "stream")
+	require.Nil(t, err)
+	_, err = conn.AddTrack(track)
+	require.Nil(t, err)
+
+	offer, err := conn.CreateOffer(nil)
+	require.Nil(t, err)
+	require.Contains(t, offer.SDP, "a=rtpmap:111 opus/48000/2")
+	require.Contains(t, offer.SDP, "a=fmtp:111 minptime=10;stereo=1;sprop-stereo=1;useinbandfec=1;maxaveragebitrate=128000")
+	require.Contains(t, offer.SDP, "a=rtpmap:118 multiopus/48000/6")
+	require.Contains(t, offer.SDP, "a=fmtp:118 minptime=10;channel_mapping=0,4,1,2,3,5;num_streams=4;coupled_streams=2;useinbandfec=1")
+	require.Contains(t, offer.SDP, "a=rtpmap:119 multiopus/48000/8")
+}
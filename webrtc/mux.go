@@ -0,0 +1,163 @@
+package webrtc
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	tcp_read_buffer = 8
+)
+
+// Mux holds the ICE sockets shared by every WebRTCClient of the process,
+// each session gathers its host candidates on the same configured port and
+// incoming packets are routed to the session whose local ufrag matches
+// the username of their STUN binding requests
+type Mux struct {
+	mut *sync.Mutex
+	udp map[int]ice.UDPMux
+	tcp map[int]ice.TCPMux
+
+	// sessions holds the UDP and TCP muxes routing each local ufrag, a
+	// ufrag stays until every one of them released it
+	sessions map[string]map[any]bool
+}
+
+var shared = NewMux()
+
+func NewMux() *Mux {
+	return &Mux{
+		mut:      &sync.Mutex{},
+		udp:      map[int]ice.UDPMux{},
+		tcp:      map[int]ice.TCPMux{},
+		sessions: map[string]map[any]bool{},
+	}
+}
+
+// SharedMux is the mux used by InitWebRtcClient
+func SharedMux() *Mux {
+	return shared
+}
+
+// UDP listens on port on first use, later calls with the same port share
+// the socket and ignore opts
+func (mux *Mux) UDP(port int, opts ...ice.UDPMuxFromPortOption) (ice.UDPMux, error) {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	if udp, found := mux.udp[port]; found {
+		return udp, nil
+	}
+
+	udp, err := ice.NewMultiUDPMuxFromPort(port, opts...)
+	if err != nil {
+		return nil, err
+	}
+	mux.udp[port] = &udpSessions{UDPMux: udp, mux: mux}
+	return mux.udp[port], nil
+}
+
+// TCP listens on port on first use, passive ICE-TCP candidates of every
+// session point at it
+func (mux *Mux) TCP(port int) (ice.TCPMux, error) {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	if tcp, found := mux.tcp[port]; found {
+		return tcp, nil
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	mux.tcp[port] = &tcpSessions{TCPMux: webrtc.NewICETCPMux(nil, listener, tcp_read_buffer), mux: mux}
+	return mux.tcp[port], nil
+}
+
+// Sessions lists the local ufrags currently routed through the mux
+func (mux *Mux) Sessions() []string {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	ret := []string{}
+	for ufrag := range mux.sessions {
+		ret = append(ret, ufrag)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// Close releases every socket, sessions still using them fail and a
+// later client listens again
+func (mux *Mux) Close() error {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	errs := []error{}
+	for port, udp := range mux.udp {
+		errs = append(errs, udp.Close())
+		delete(mux.udp, port)
+	}
+	for port, tcp := range mux.tcp {
+		errs = append(errs, tcp.Close())
+		delete(mux.tcp, port)
+	}
+	clear(mux.sessions)
+	return errors.Join(errs...)
+}
+
+func (mux *Mux) acquire(ufrag string, holder any) {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	if mux.sessions[ufrag] == nil {
+		mux.sessions[ufrag] = map[any]bool{}
+	}
+	mux.sessions[ufrag][holder] = true
+}
+
+func (mux *Mux) release(ufrag string, holder any) {
+	mux.mut.Lock()
+	defer mux.mut.Unlock()
+	delete(mux.sessions[ufrag], holder)
+	if len(mux.sessions[ufrag]) == 0 {
+		delete(mux.sessions, ufrag)
+	}
+}
+
+type udpSessions struct {
+	ice.UDPMux
+	mux *Mux
+}
+
+func (udp *udpSessions) GetConn(ufrag string, addr net.Addr) (net.PacketConn, error) {
+	conn, err := udp.UDPMux.GetConn(ufrag, addr)
+	if err == nil {
+		udp.mux.acquire(ufrag, udp)
+	}
+	return conn, err
+}
+
+func (udp *udpSessions) RemoveConnByUfrag(ufrag string) {
+	udp.UDPMux.RemoveConnByUfrag(ufrag)
+	udp.mux.release(ufrag, udp)
+}
+
+type tcpSessions struct {
+	ice.TCPMux
+	mux *Mux
+}
+
+func (tcp *tcpSessions) GetConnByUfrag(ufrag string, isIPv6 bool, local net.IP) (net.PacketConn, error) {
+	conn, err := tcp.TCPMux.GetConnByUfrag(ufrag, isIPv6, local)
+	if err == nil {
+		tcp.mux.acquire(ufrag, tcp)
+	}
+	return conn, err
+}
+
+func (tcp *tcpSessions) RemoveConnByUfrag(ufrag string) {
+	tcp.TCPMux.RemoveConnByUfrag(ufrag)
+	tcp.mux.release(ufrag, tcp)
+}
@@ -0,0 +1,162 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+const (
+	sessions     = 4
+	open_timeout = time.Second * 10
+)
+
+func freePort(t *testing.T, network string) int {
+	switch network {
+	case "udp":
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.Nil(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	default:
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.Nil(t, err)
+		defer listener.Close()
+		return listener.Addr().(*net.TCPAddr).Port
+	}
+}
+
+// loopback connects a host peer built from conf with a plain pion peer
+// and echoes a message over a datachannel, it returns the ports of the
+// host candidates
+func loopback(t *testing.T, conf config.NetworkConfig, network webrtc.NetworkType, id int) []int {
+	api, err := newAPI(conf, config.OpusConfig{}, nil)
+	require.Nil(t, err)
+	host, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer host.Close()
+
+	engine := webrtc.SettingEngine{}
+	engine.SetIncludeLoopbackCandidate(true)
+	engine.SetNetworkTypes([]webrtc.NetworkType{network})
+	engine.SetIPFilter(func(ip net.IP) bool { return ip.IsLoopback() })
+	viewer, err := webrtc.NewAPI(webrtc.WithSettingEngine(engine)).NewPeerConnection(webrtc.Configuration{})
+	require.Nil(t, err)
+	defer viewer.Close()
+
+	echoed := make(chan string, 1)
+	viewer.OnDataChannel(func(channel *webrtc.DataChannel) {
+		channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+			channel.SendText(string(msg.Data))
+		})
+	})
+	channel, err := host.CreateDataChannel("hid", nil)
+	require.Nil(t, err)
+	channel.OnOpen(func() {
+		channel.SendText(fmt.Sprintf("session %d", id))
+	})
+	channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		echoed <- string(msg.Data)
+	})
+
+	offer, err := host.CreateOffer(nil)
+	require.Nil(t, err)
+	gathered := webrtc.GatheringCompletePromise(host)
+	require.Nil(t, host.SetLocalDescription(offer))
+	<-gathered
+
+	require.Nil(t, viewer.SetRemoteDescription(*host.LocalDescription()))
+	answer, err := viewer.CreateAnswer(nil)
+	require.Nil(t, err)
+	gathered = webrtc.GatheringCompletePromise(viewer)
+	require.Nil(t, viewer.SetLocalDescription(answer))
+	<-gathered
+	require.Nil(t, host.SetRemoteDescription(*viewer.LocalDescription()))
+
+	select {
+	case msg := <-echoed:
+		require.Equal(t, fmt.Sprintf("session %d", id), msg)
+	case <-time.After(open_timeout):
+		require.FailNow(t, "session did not connect", "session %d", id)
+	}
+
+	ports := []int{}
+	for _, stat := range host.GetStats() {
+		if candidate, ok := stat.(webrtc.ICECandidateStats); ok && candidate.Type == webrtc.StatsTypeLocalCandidate {
+			ports = append(ports, int(candidate.Port))
+		}
+	}
+	require.Contains(t, SharedMux().Sessions(), ufrag(host.LocalDescription().SDP))
+	return ports
+}
+
+func ufrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\r\n") {
+		if value, found := strings.CutPrefix(line, "a=ice-ufrag:"); found {
+			return value
+		}
+	}
+	return ""
+}
+
+func concurrent(t *testing.T, conf config.NetworkConfig, network webrtc.NetworkType, port int) {
+	t.Cleanup(func() { require.Nil(t, SharedMux().Close()) })
+
+	t.Run("sessions", func(t *testing.T) {
+		for i := 0; i < sessions; i++ {
+			id := i
+			t.Run(fmt.Sprintf("session-%d", id), func(t *testing.T) {
+				t.Parallel()
+				ports := loopback(t, conf, network, id)
+				require.NotEmpty(t, ports)
+				for _, local := range ports {
+					require.Equal(t, port, local)
+				}
+			})
+		}
+	})
+
+	require.Eventually(t, func() bool {
+		return len(SharedMux().Sessions()) == 0
+	}, open_timeout, time.Millisecond*50)
+}
+
+func TestUDPMux(t *testing.T) {
+	port := freePort(t, "udp")
+	concurrent(t, config.NetworkConfig{
+		UDPPort:      port,
+		NetworkTypes: []string{"udp4"},
+		IPs:          []string{"127.0.0.1"},
+		Loopback:     true,
+	}, webrtc.NetworkTypeUDP4, port)
+}
+
+func TestTCPMux(t *testing.T) {
+	port := freePort(t, "tcp")
+	concurrent(t, config.NetworkConfig{
+		TCPPort:      port,
+		NetworkTypes: []string{"tcp4"},
+		IPs:          []string{"127.0.0.1"},
+		Loopback:     true,
+	}, webrtc.NetworkTypeTCP4, port)
+}
+
+func TestMuxRelease(t *testing.T) {
+	mux := NewMux()
+	udp, tcp := &udpSessions{mux: mux}, &tcpSessions{mux: mux}
+
+	// a session gathering on both muxes is routed until both released it
+	mux.acquire("ufrag", udp)
+	mux.acquire("ufrag", udp)
+	mux.acquire("ufrag", tcp)
+	mux.release("ufrag", udp)
+	require.Equal(t, []string{"ufrag"}, mux.Sessions())
+	mux.release("ufrag", tcp)
+	require.Empty(t, mux.Sessions())
+}
@@ -0,0 +1,39 @@
+package webrtc
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	// nack_history is how many packets of a track can be retransmitted
+	nack_history = 1024
+)
+
+// history keeps the latest packets of a track for NACK retransmissions,
+// which go through the pacer like any other packet
+type history struct {
+	mut     *sync.Mutex
+	packets [nack_history]*rtp.Packet
+}
+
+func newHistory() *history {
+	return &history{mut: &sync.Mutex{}}
+}
+
+func (h *history) add(pk *rtp.Packet) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.packets[int(pk.SequenceNumber)%nack_history] = pk
+}
+
+// get is the packet numbered seq, nil once it was overwritten
+func (h *history) get(seq uint16) *rtp.Packet {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if pk := h.packets[int(seq)%nack_history]; pk != nil && pk.SequenceNumber == seq {
+		return pk
+	}
+	return nil
+}
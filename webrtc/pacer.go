@@ -0,0 +1,198 @@
+package webrtc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	// pacing_factor is how far above the bandwidth estimate packets are
+	// paced, encoders overshoot it on keyframes
+	pacing_factor = 2.5
+	// min_pacing_rate keeps a low estimate from stalling the session
+	min_pacing_rate = 1_000_000
+
+	// pacer_queue_size bounds each priority queue, packets beyond it are
+	// dropped
+	pacer_queue_size = 4096
+)
+
+// priority orders the queues of a pacer, lower goes first
+type priority int
+
+const (
+	priorityAudio priority = iota
+	priorityRetransmission
+	priorityVideo
+	priorities
+)
+
+type paced struct {
+	pk     *rtp.Packet
+	size   int
+	queued time.Time
+	write  func(*rtp.Packet)
+}
+
+// pacer is a leaky bucket shared by every track of a session, it lets
+// out Burst worth of bytes back to back then drains at the pacing rate.
+// Audio is never held back, retransmissions go before video
+type pacer struct {
+	mut  *sync.Mutex
+	conf config.PacerConfig
+	now  func() time.Time
+	wake chan struct{}
+
+	queues [priorities][]*paced
+	// budget is the bytes that may leave now, negative while in debt
+	budget  float64
+	updated time.Time
+	// estimate is the viewer bandwidth estimate in bits per second
+	estimate float64
+}
+
+func newPacer(conf config.PacerConfig, now func() time.Time) *pacer {
+	p := &pacer{
+		mut:     &sync.Mutex{},
+		conf:    conf,
+		now:     now,
+		wake:    make(chan struct{}, 1),
+		updated: now(),
+	}
+	p.budget = p.burst()
+	return p
+}
+
+// run drains the queues until ctx is done
+func (p *pacer) run(ctx context.Context) {
+	if p.conf.Bitrate == 0 {
+		return
+	}
+
+	thread.HighPriorityLoop(ctx, func() {
+		p.mut.Lock()
+		next, wait := p.pop(p.now())
+		p.mut.Unlock()
+
+		if next != nil {
+			next.write(next.pk)
+			return
+		}
+
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-p.wake:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// push queues pk to be written, right away when pacing is disabled
+func (p *pacer) push(prio priority, pk *rtp.Packet, write func(*rtp.Packet)) {
+	if p.conf.Bitrate == 0 {
+		write(pk)
+		return
+	}
+
+	p.mut.Lock()
+	if len(p.queues[prio]) < pacer_queue_size {
+		p.queues[prio] = append(p.queues[prio], &paced{
+			pk:     pk,
+			size:   pk.MarshalSize(),
+			queued: p.now(),
+			write:  write,
+		})
+	}
+	p.mut.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// setEstimate follows the bandwidth estimate of the viewer in bits per
+// second, when enabled
+func (p *pacer) setEstimate(bps float64) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.conf.Estimate {
+		p.refill(p.now())
+		p.estimate = bps
+	}
+}
+
+// rate is the pacing rate in bytes per second
+func (p *pacer) rate() float64 {
+	rate := float64(p.conf.Bitrate)
+	if p.estimate > 0 {
+		rate = min(rate, max(p.estimate*pacing_factor, min_pacing_rate))
+	}
+	return rate / 8
+}
+
+func (p *pacer) burst() float64 {
+	return p.conf.Burst.Seconds() * p.rate()
+}
+
+func (p *pacer) refill(now time.Time) {
+	if elapsed := now.Sub(p.updated); elapsed > 0 {
+		p.budget = min(p.budget+elapsed.Seconds()*p.rate(), p.burst())
+	}
+	p.updated = now
+}
+
+// pop takes the packet that may leave at now, or tells how long until
+// one may, zero when every queue is empty
+func (p *pacer) pop(now time.Time) (next *paced, wait time.Duration) {
+	p.refill(now)
+
+	take := -1
+	for prio, queue := range p.queues {
+		if len(queue) == 0 {
+			continue
+		}
+
+		head := queue[0]
+		if priority(prio) == priorityAudio || p.budget > 0 {
+			take = prio
+			break
+		} else if p.conf.MaxDelay == 0 {
+			continue
+		} else if late := head.queued.Add(p.conf.MaxDelay).Sub(now); late <= 0 {
+			take = prio
+			break
+		} else if wait == 0 || late < wait {
+			wait = late
+		}
+	}
+
+	if take >= 0 {
+		next, p.queues[take] = p.queues[take][0], p.queues[take][1:]
+		p.budget -= float64(next.size)
+		return next, 0
+	}
+
+	for _, queue := range p.queues {
+		if len(queue) > 0 {
+			// the bucket is empty, queued packets wait for it to refill
+			refilled := time.Duration((1-p.budget)/p.rate()*float64(time.Second)) + 1
+			if wait == 0 || refilled < wait {
+				wait = refilled
+			}
+			break
+		}
+	}
+	return nil, wait
+}
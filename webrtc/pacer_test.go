@@ -0,0 +1,106 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+// packet marshals to size bytes
+func packet(seq uint16, size int) *rtp.Packet {
+	return &rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: seq}, Payload: make([]byte, size-12)}
+}
+
+func TestPacerDisabled(t *testing.T) {
+	p := newPacer(config.PacerConfig{}, time.Now)
+	written := []uint16{}
+	p.push(priorityVideo, packet(1, 500), func(pk *rtp.Packet) { written = append(written, pk.SequenceNumber) })
+	require.Equal(t, []uint16{1}, written)
+}
+
+func TestPacerBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// 1MB/s with a 1000 byte burst
+	p := newPacer(config.PacerConfig{Bitrate: 8_000_000, Burst: time.Millisecond}, func() time.Time { return now })
+	for seq := uint16(1); seq <= 4; seq++ {
+		p.push(priorityVideo, packet(seq, 500), nil)
+	}
+
+	for seq := uint16(1); seq <= 2; seq++ {
+		next, _ := p.pop(now)
+		require.Equal(t, seq, next.pk.SequenceNumber)
+	}
+	next, wait := p.pop(now)
+	require.Nil(t, next)
+	require.Equal(t, time.Microsecond+1, wait)
+
+	now = now.Add(wait)
+	next, _ = p.pop(now)
+	require.Equal(t, uint16(3), next.pk.SequenceNumber)
+
+	// the bucket is 499 bytes in debt
+	_, wait = p.pop(now)
+	require.InDelta(t, 500*time.Microsecond, wait, float64(time.Microsecond))
+
+	// an idle pacer only saves up to the burst
+	now = now.Add(time.Second)
+	next, _ = p.pop(now)
+	require.Equal(t, uint16(4), next.pk.SequenceNumber)
+	require.Equal(t, float64(500), p.budget)
+
+	next, wait = p.pop(now)
+	require.Nil(t, next)
+	require.Zero(t, wait)
+}
+
+func TestPacerPriority(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := newPacer(config.PacerConfig{Bitrate: 8_000_000, Burst: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		func() time.Time { return now })
+	p.push(priorityVideo, packet(1, 1200), nil)
+	p.push(priorityVideo, packet(2, 1200), nil)
+	p.push(priorityRetransmission, packet(3, 1200), nil)
+	p.push(priorityAudio, packet(4, 200), nil)
+
+	for _, seq := range []uint16{4, 3} {
+		next, _ := p.pop(now)
+		require.Equal(t, seq, next.pk.SequenceNumber)
+	}
+
+	// audio is never held back, even in debt
+	p.push(priorityAudio, packet(5, 200), nil)
+	next, _ := p.pop(now)
+	require.Equal(t, uint16(5), next.pk.SequenceNumber)
+	next, _ = p.pop(now)
+	require.Nil(t, next)
+
+	// packets waiting longer than MaxDelay leave regardless
+	now = now.Add(10 * time.Millisecond)
+	for _, seq := range []uint16{1, 2} {
+		next, _ = p.pop(now)
+		require.Equal(t, seq, next.pk.SequenceNumber)
+	}
+}
+
+func TestPacerEstimate(t *testing.T) {
+	p := newPacer(config.PacerConfig{Bitrate: 50_000_000, Burst: time.Millisecond}, time.Now)
+	p.setEstimate(2_000_000)
+	require.Equal(t, float64(50_000_000/8), p.rate())
+
+	p.conf.Estimate = true
+	p.setEstimate(4_000_000)
+	require.Equal(t, float64(10_000_000/8), p.rate())
+	p.setEstimate(100_000)
+	require.Equal(t, float64(min_pacing_rate/8), p.rate())
+}
+
+func TestHistory(t *testing.T) {
+	h := newHistory()
+	h.add(packet(7, 100))
+	require.Equal(t, uint16(7), h.get(7).SequenceNumber)
+	h.add(packet(7+nack_history, 100))
+	require.Nil(t, h.get(7))
+}
@@ -0,0 +1,67 @@
+package webrtc
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
+)
+
+const (
+	report_interval = time.Second
+)
+
+// sent counts what a track wrote for its sender reports
+type sent struct {
+	packets atomic.Uint32
+	octets  atomic.Uint32
+}
+
+func (s *sent) add(pk *rtp.Packet) {
+	s.packets.Add(1)
+	s.octets.Add(uint32(len(pk.Payload)))
+}
+
+// senderReport pairs now with the RTP time of timeline, ok is false until
+// the track sent its first packet
+func senderReport(ssrc uint32, now time.Time, timeline listener.Timeline, s *sent) (report *rtcp.SenderReport, ok bool) {
+	packets := s.packets.Load()
+	if packets == 0 {
+		return nil, false
+	}
+	rtpTime, ok := timeline.RTPTime(now)
+	if !ok {
+		return nil, false
+	}
+	return &rtcp.SenderReport{
+		SSRC:        ssrc,
+		NTPTime:     clock.NTP(now),
+		RTPTime:     rtpTime,
+		PacketCount: packets,
+		OctetCount:  s.octets.Load(),
+	}, true
+}
+
+// reportLoop sends the sender reports of a track whose listener exposes
+// its timeline, the browser pairs them across tracks for lip sync
+func (client *WebRTCClient) reportLoop(timeline listener.Timeline, sender *webrtc.RTPSender, s *sent) {
+	thread.SafeLoop(client.ctx, report_interval, func() {
+		encodings := sender.GetParameters().Encodings
+		if len(encodings) == 0 {
+			return
+		}
+		report, ok := senderReport(uint32(encodings[0].SSRC), time.Now(), timeline, s)
+		if !ok {
+			return
+		}
+		if err := client.conn.WriteRTCP([]rtcp.Packet{report}); err != nil && client.ctx.Err() == nil {
+			fmt.Printf("failed to send sender report %s\n", err.Error())
+		}
+	})
+}
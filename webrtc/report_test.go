@@ -0,0 +1,32 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+)
+
+func TestSenderReport(t *testing.T) {
+	timeline := clock.NewClock(90000)
+	start := time.Unix(1700000000, 0)
+	counts := &sent{}
+
+	_, ok := senderReport(1, start, timeline, counts)
+	require.False(t, ok)
+
+	ts := timeline.Timestamp(start)
+	counts.add(&rtp.Packet{Payload: make([]byte, 100)})
+	counts.add(&rtp.Packet{Payload: make([]byte, 50)})
+
+	now := start.Add(time.Second)
+	report, ok := senderReport(1, now, timeline, counts)
+	require.True(t, ok)
+	require.Equal(t, uint32(1), report.SSRC)
+	require.Equal(t, clock.NTP(now), report.NTPTime)
+	require.Equal(t, ts+90000, report.RTPTime)
+	require.Equal(t, uint32(2), report.PacketCount)
+	require.Equal(t, uint32(150), report.OctetCount)
+}
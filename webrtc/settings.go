@@ -0,0 +1,191 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+const (
+	// pion defaults, used when only some of the ICE timeouts are configured
+	disconnected_timeout = time.Second * 5
+	failed_timeout       = time.Second * 25
+	keepalive_interval   = time.Second * 2
+)
+
+var (
+	mdns_modes = map[string]ice.MulticastDNSMode{
+		"disabled": ice.MulticastDNSModeDisabled,
+		"query":    ice.MulticastDNSModeQueryOnly,
+		"gather":   ice.MulticastDNSModeQueryAndGather,
+	}
+)
+
+func newAPI(conf config.NetworkConfig, opus config.OpusConfig, estimate func(bps int)) (*webrtc.API, error) {
+	engine, err := settingEngine(conf)
+	if err != nil {
+		return nil, err
+	}
+	media, registry, err := mediaEngine(opus, estimate)
+	if err != nil {
+		return nil, err
+	}
+	return webrtc.NewAPI(
+		webrtc.WithSettingEngine(engine),
+		webrtc.WithMediaEngine(media),
+		webrtc.WithInterceptorRegistry(registry),
+	), nil
+}
+
+func settingEngine(conf config.NetworkConfig) (engine webrtc.SettingEngine, err error) {
+	if conf.PortMin != 0 || conf.PortMax != 0 {
+		if err = engine.SetEphemeralUDPPortRange(conf.PortMin, conf.PortMax); err != nil {
+			return
+		}
+	}
+
+	if conf.Loopback {
+		engine.SetIncludeLoopbackCandidate(true)
+	}
+
+	var (
+		interfaces func(string) bool
+		ips        func(net.IP) bool
+	)
+	if len(conf.Interfaces) > 0 {
+		allowed := slices.Clone(conf.Interfaces)
+		interfaces = func(name string) bool {
+			return slices.Contains(allowed, name)
+		}
+		engine.SetInterfaceFilter(interfaces)
+	}
+	if len(conf.IPs) > 0 {
+		networks, err := parseNetworks(conf.IPs)
+		if err != nil {
+			return engine, err
+		}
+		ips = func(ip net.IP) bool {
+			return slices.ContainsFunc(networks, func(network *net.IPNet) bool {
+				return network.Contains(ip)
+			})
+		}
+		engine.SetIPFilter(ips)
+	}
+
+	types := []webrtc.NetworkType{}
+	for _, raw := range conf.NetworkTypes {
+		kind, err := webrtc.NewNetworkType(raw)
+		if err != nil {
+			return engine, err
+		}
+		types = append(types, kind)
+	}
+	if len(types) > 0 {
+		engine.SetNetworkTypes(types)
+	}
+
+	if conf.UDPPort != 0 {
+		mux, err := shared.UDP(conf.UDPPort, udpOptions(conf, types, interfaces, ips)...)
+		if err != nil {
+			return engine, err
+		}
+		engine.SetICEUDPMux(mux)
+	}
+	if conf.TCPPort != 0 {
+		mux, err := shared.TCP(conf.TCPPort)
+		if err != nil {
+			return engine, err
+		}
+		engine.SetICETCPMux(mux)
+	}
+
+	if len(conf.NAT1To1IPs) > 0 {
+		kind := webrtc.ICECandidateTypeHost
+		if conf.NAT1To1Type != "" {
+			if kind, err = webrtc.NewICECandidateType(conf.NAT1To1Type); err != nil {
+				return
+			}
+		}
+		engine.SetNAT1To1IPs(conf.NAT1To1IPs, kind)
+	}
+
+	if conf.MDNS != "" {
+		mode, found := mdns_modes[conf.MDNS]
+		if !found {
+			return engine, fmt.Errorf("unknown mdns mode %s", conf.MDNS)
+		}
+		engine.SetICEMulticastDNSMode(mode)
+	}
+
+	if conf.DisconnectedTimeout != 0 || conf.FailedTimeout != 0 || conf.KeepAliveInterval != 0 {
+		engine.SetICETimeouts(
+			orDefault(conf.DisconnectedTimeout, disconnected_timeout),
+			orDefault(conf.FailedTimeout, failed_timeout),
+			orDefault(conf.KeepAliveInterval, keepalive_interval))
+	}
+
+	return
+}
+
+// udpOptions applies the gathering filters to the addresses the shared
+// udp socket advertises, pion skips them for muxed candidates
+func udpOptions(conf config.NetworkConfig,
+	types []webrtc.NetworkType,
+	interfaces func(string) bool,
+	ips func(net.IP) bool) []ice.UDPMuxFromPortOption {
+	opts := []ice.UDPMuxFromPortOption{}
+	if conf.Loopback {
+		opts = append(opts, ice.UDPMuxFromPortWithLoopback())
+	}
+	if interfaces != nil {
+		opts = append(opts, ice.UDPMuxFromPortWithInterfaceFilter(interfaces))
+	}
+	if ips != nil {
+		opts = append(opts, ice.UDPMuxFromPortWithIPFilter(ips))
+	}
+
+	networks := []ice.NetworkType{}
+	for _, kind := range types {
+		switch kind {
+		case webrtc.NetworkTypeUDP4:
+			networks = append(networks, ice.NetworkTypeUDP4)
+		case webrtc.NetworkTypeUDP6:
+			networks = append(networks, ice.NetworkTypeUDP6)
+		}
+	}
+	if len(networks) > 0 {
+		opts = append(opts, ice.UDPMuxFromPortWithNetworks(networks...))
+	}
+	return opts
+}
+
+// parseNetworks accepts both cidrs and single addresses
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			networks = append(networks, network)
+		} else if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			return nil, fmt.Errorf("invalid ip or cidr %s", value)
+		}
+	}
+	return networks, nil
+}
+
+func orDefault(value, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,43 @@
+package webrtc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks([]string{"10.0.0.0/8", "192.168.1.7", "fd00::1"})
+	require.Nil(t, err)
+	require.Len(t, networks, 3)
+	require.True(t, networks[0].Contains(net.ParseIP("10.1.2.3")))
+	require.True(t, networks[1].Contains(net.ParseIP("192.168.1.7")))
+	require.False(t, networks[1].Contains(net.ParseIP("192.168.1.8")))
+	require.True(t, networks[2].Contains(net.ParseIP("fd00::1")))
+
+	_, err = parseNetworks([]string{"nowhere"})
+	require.NotNil(t, err)
+}
+
+func TestSettingEngine(t *testing.T) {
+	_, err := settingEngine(config.NetworkConfig{
+		PortMin:       40000,
+		PortMax:       40100,
+		NAT1To1IPs:    []string{"203.0.113.5"},
+		NAT1To1Type:   "srflx",
+		NetworkTypes:  []string{"udp4", "tcp4"},
+		Interfaces:    []string{"eth0"},
+		IPs:           []string{"10.0.0.0/8"},
+		MDNS:          "disabled",
+		FailedTimeout: 10 * time.Second,
+	})
+	require.Nil(t, err)
+
+	_, err = settingEngine(config.NetworkConfig{PortMin: 50000, PortMax: 40000})
+	require.NotNil(t, err)
+	_, err = settingEngine(config.NetworkConfig{NetworkTypes: []string{"sctp"}})
+	require.NotNil(t, err)
+}
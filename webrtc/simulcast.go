@@ -0,0 +1,268 @@
+package webrtc
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+)
+
+const (
+	// simulcast_window is how long the rate of each encoding is measured
+	// before the forwarder reconsiders its target
+	simulcast_window = time.Second
+	// simulcast_headroom is how far the estimate has to exceed the rate of
+	// a higher encoding before switching up to it
+	simulcast_headroom = 1.2
+	// keyframe_interval is how long a switch waits for a keyframe of the
+	// target before asking again
+	keyframe_interval = time.Second
+)
+
+// simulcast forwards one encoding of a simulcast listener to a viewer as
+// a single stream. It switches to the highest encoding fitting the
+// bandwidth estimate on a keyframe of that encoding, timestamps follow
+// the timeline of the first encoding and sequence numbers stay contiguous
+type simulcast struct {
+	mut       *sync.Mutex
+	codec     string
+	encodings []listener.Encoding
+	now       func() time.Time
+
+	// estimate is the viewer bandwidth estimate in bits per second
+	estimate float64
+	bytes    []float64
+	window   time.Time
+	// current is the encoding forwarded, -1 until the first keyframe
+	current, target int
+	requested       time.Time
+
+	offsets []uint32
+	started []bool
+	last    []uint32
+	// seq and timestamp follow the packets forwarded
+	seq       uint16
+	timestamp uint32
+
+	// forwarded is published on every switch for lookups from within the
+	// handler, which runs under mut
+	forwarded atomic.Pointer[forwarded]
+}
+
+// forwarded is the encoding a switch moved to and the timestamp offsets
+// of every encoding at that time
+type forwarded struct {
+	current int
+	offsets []uint32
+}
+
+func newSimulcast(lis listener.Simulcast, codec string, now func() time.Time) *simulcast {
+	encodings := lis.Encodings()
+	return &simulcast{
+		mut:       &sync.Mutex{},
+		codec:     codec,
+		encodings: encodings,
+		now:       now,
+		bytes:     make([]float64, len(encodings)),
+		window:    now(),
+		current:   -1,
+		offsets:   make([]uint32, len(encodings)),
+		started:   make([]bool, len(encodings)),
+		last:      make([]uint32, len(encodings)),
+	}
+}
+
+// setEstimate follows the bandwidth estimate of the viewer in bits per
+// second
+func (s *simulcast) setEstimate(bps float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.estimate = bps
+}
+
+// forward hands pk of encoding i to fun when that encoding is forwarded,
+// under the lock so packets of two encodings never interleave
+func (s *simulcast) forward(i int, pk *rtp.Packet, fun func(*rtp.Packet)) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	now := s.now()
+	start := !s.started[i] || pk.Timestamp != s.last[i]
+	s.started[i], s.last[i] = true, pk.Timestamp
+	s.bytes[i] += float64(len(pk.Payload))
+	s.measure(now)
+
+	if s.current != s.target {
+		if i == s.target && start && s.isKeyframe(i, pk.Timestamp) {
+			s.switchTo(i, pk.Timestamp, now)
+		} else if now.Sub(s.requested) > keyframe_interval {
+			s.requested = now
+			s.keyframe(s.target)
+		}
+	}
+	if i != s.current {
+		return
+	}
+
+	clone := &rtp.Packet{Header: pk.Header.Clone(), Payload: pk.Payload}
+	clone.Timestamp += s.offsets[i]
+	clone.SequenceNumber = s.seq
+	s.seq, s.timestamp = s.seq+1, clone.Timestamp
+	fun(clone)
+}
+
+func (s *simulcast) isKeyframe(i int, timestamp uint32) bool {
+	frames, ok := s.encodings[i].Listener.(listener.Frames)
+	if !ok {
+		return false
+	}
+	frame, ok := frames.Frame(timestamp)
+	return ok && frame.Keyframe
+}
+
+// switchTo forwards encoding i from its keyframe stamped timestamp on.
+// Its timestamps are moved onto the timeline of the first encoding so
+// frames captured together keep the same timestamp, though never one a
+// forwarded frame already had
+func (s *simulcast) switchTo(i int, timestamp uint32, now time.Time) {
+	s.offsets[i] = 0
+	reference, ok := s.encodings[0].Listener.(listener.Timeline)
+	timeline, found := s.encodings[i].Listener.(listener.Timeline)
+	if ok && found {
+		base, ok := reference.RTPTime(now)
+		ts, found := timeline.RTPTime(now)
+		if ok && found {
+			s.offsets[i] = base - ts
+		}
+	}
+	if s.current >= 0 && int32(timestamp+s.offsets[i]-s.timestamp) <= 0 {
+		s.offsets[i] = s.timestamp + 1 - timestamp
+	}
+
+	s.forwarded.Store(&forwarded{current: i, offsets: slices.Clone(s.offsets)})
+	if s.current >= 0 {
+		fmt.Printf("simulcast switched from %s to %s\n", s.encodings[s.current].RID, s.encodings[i].RID)
+	}
+	s.current = i
+}
+
+// measure picks the target once a window of encoding rates is complete,
+// the highest encoding is forwarded until there is an estimate
+func (s *simulcast) measure(now time.Time) {
+	elapsed := now.Sub(s.window)
+	if elapsed < simulcast_window {
+		return
+	}
+	s.window = now
+
+	// encodings that produced nothing are not switched to, when none fits
+	// the lowest one producing is
+	target, lowest := -1, -1
+	for i := range s.bytes {
+		if s.bytes[i] == 0 {
+			continue
+		}
+		rate := s.bytes[i] * 8 / elapsed.Seconds()
+		limit := s.estimate
+		if i < s.current {
+			limit /= simulcast_headroom
+		}
+		if target < 0 && (s.estimate == 0 || rate <= limit) {
+			target = i
+		}
+		lowest, s.bytes[i] = i, 0
+	}
+	if target < 0 {
+		target = lowest
+	}
+	if target < 0 {
+		return
+	}
+
+	if target != s.target {
+		s.target, s.requested = target, now
+		s.keyframe(target)
+	}
+}
+
+// keyframe asks encoding i alone for a keyframe, the others keep their
+// rate
+func (s *simulcast) keyframe(i int) {
+	if keyframe := s.encodings[i].Keyframe; keyframe != nil {
+		keyframe()
+	}
+}
+
+// requestKeyframe answers a picture loss of the viewer with a keyframe
+// of the encoding it will be forwarded next
+func (s *simulcast) requestKeyframe() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.requested = s.now()
+	s.keyframe(s.target)
+}
+
+// Frame looks timestamp up on the forwarded encoding first, the others
+// cover packets queued before a switch
+func (s *simulcast) Frame(timestamp uint32) (clock.Frame, bool) {
+	state := s.forwarded.Load()
+	if state == nil {
+		return clock.Frame{}, false
+	}
+
+	order := []int{state.current}
+	for i := range s.encodings {
+		if i != state.current {
+			order = append(order, i)
+		}
+	}
+	for _, i := range order {
+		if frames, ok := s.encodings[i].Listener.(listener.Frames); ok {
+			if frame, found := frames.Frame(timestamp - state.offsets[i]); found {
+				frame.Timestamp = timestamp
+				return frame, true
+			}
+		}
+	}
+	return clock.Frame{}, false
+}
+
+// RTPTime follows the first encoding, the timeline of the stream
+func (s *simulcast) RTPTime(t time.Time) (uint32, bool) {
+	if timeline, ok := s.encodings[0].Listener.(listener.Timeline); ok {
+		return timeline.RTPTime(t)
+	}
+	return 0, false
+}
+
+func (s *simulcast) ColorSpace() []byte {
+	if colorspace, ok := s.encodings[0].Listener.(interface{ ColorSpace() []byte }); ok {
+		return colorspace.ColorSpace()
+	}
+	return nil
+}
+
+func (s *simulcast) GetCodec() string {
+	return s.codec
+}
+
+func (s *simulcast) Close() {}
+
+func (s *simulcast) RegisterRTPHandler(id string, fun func(*rtp.Packet)) {
+	for i, encoding := range s.encodings {
+		encoding.Listener.RegisterRTPHandler(id+"-"+encoding.RID, func(pk *rtp.Packet) {
+			s.forward(i, pk, fun)
+		})
+	}
+}
+
+func (s *simulcast) DeregisterRTPHandler(id string) {
+	for _, encoding := range s.encodings {
+		encoding.Listener.DeregisterRTPHandler(id + "-" + encoding.RID)
+	}
+}
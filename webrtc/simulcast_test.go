@@ -0,0 +1,91 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/clock"
+)
+
+// encoding is a simulcast encoding whose timeline starts at base
+type encoding struct {
+	frames
+	base    uint32
+	origin  time.Time
+	handler func(*rtp.Packet)
+}
+
+func (e *encoding) RegisterRTPHandler(_ string, fun func(*rtp.Packet)) { e.handler = fun }
+func (e *encoding) RTPTime(t time.Time) (uint32, bool) {
+	return e.base + uint32(t.Sub(e.origin)*90000/time.Second), true
+}
+
+type encodings []listener.Encoding
+
+func (e encodings) Encodings() []listener.Encoding { return e }
+
+func TestSimulcast(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	high := &encoding{frames: frames{}, base: 1000, origin: now}
+	low := &encoding{frames: frames{}, base: 50000, origin: now}
+
+	// keyframes are counted per encoding, a switch asks its target only
+	keyframes := []int{0, 0}
+	s := newSimulcast(encodings{
+		{RID: "h", Listener: high, Keyframe: func() { keyframes[0]++ }},
+		{RID: "l", Listener: low, Keyframe: func() { keyframes[1]++ }},
+	}, "video/H264", func() time.Time { return now })
+
+	forwarded := []*rtp.Packet{}
+	s.RegisterRTPHandler("viewer", func(pk *rtp.Packet) { forwarded = append(forwarded, pk) })
+
+	// one frame a packet on both encodings, 10kB high and 1kB low at 30 fps
+	frame := 0
+	send := func(frames int, keyframe func(frame int) bool) {
+		for end := frame + frames; frame < end; frame++ {
+			elapsed := uint32(frame * 3000)
+			high.frames[high.base+elapsed] = clock.Frame{Keyframe: keyframe(frame)}
+			low.frames[low.base+elapsed] = clock.Frame{Keyframe: keyframe(frame)}
+			high.handler(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(frame), Timestamp: high.base + elapsed}, Payload: make([]byte, 10000)})
+			low.handler(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(frame + 500), Timestamp: low.base + elapsed}, Payload: make([]byte, 1000)})
+			now = now.Add(time.Second / 30)
+		}
+	}
+	never := func(int) bool { return false }
+
+	// nothing goes before a keyframe of the high encoding
+	send(5, never)
+	require.Empty(t, forwarded)
+	require.Equal(t, []int{1, 0}, keyframes)
+	send(25, func(frame int) bool { return frame == 5 })
+	require.Len(t, forwarded, 25)
+	require.Equal(t, high.base+5*3000, forwarded[0].Timestamp)
+
+	// a low estimate switches down on the next keyframe of the low encoding
+	s.setEstimate(500_000)
+	send(10, never)
+	require.Equal(t, []int{1, 1}, keyframes)
+	send(10, func(frame int) bool { return frame == 45 })
+	require.Len(t, forwarded, 46)
+	for i, pk := range forwarded {
+		require.Equal(t, uint16(i), pk.SequenceNumber)
+		require.Equal(t, i <= 40, len(pk.Payload) == 10000)
+		if i <= 40 {
+			require.Equal(t, high.base+uint32(i+5)*3000, pk.Timestamp)
+		} else {
+			// the high frame captured with the keyframe went out already
+			require.Equal(t, high.base+uint32(i+4)*3000+1, pk.Timestamp)
+		}
+	}
+
+	info, ok := s.Frame(high.base + 45*3000 + 1)
+	require.True(t, ok)
+	require.True(t, info.Keyframe)
+
+	// picture loss of the viewer asks the forwarded encoding alone
+	s.requestKeyframe()
+	require.Equal(t, []int{1, 2}, keyframes)
+}
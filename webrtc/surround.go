@@ -0,0 +1,71 @@
+package webrtc
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/opus"
+)
+
+// trackWriter is the local track a listener is sent on
+type trackWriter interface {
+	webrtc.TrackLocal
+	WriteRTP(*rtp.Packet) error
+}
+
+// surroundTrack is a multiopus track for viewers accepting its layout,
+// the others bind it as stereo opus and receive the front pair
+type surroundTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	stereo   *webrtc.TrackLocalStaticRTP
+	fallback atomic.Bool
+}
+
+func newSurroundTrack(surround, stereo webrtc.RTPCodecCapability, id, stream string) (*surroundTrack, error) {
+	track, err := webrtc.NewTrackLocalStaticRTP(surround, id, stream)
+	if err != nil {
+		return nil, err
+	}
+	fallback, err := webrtc.NewTrackLocalStaticRTP(stereo, id, stream)
+	if err != nil {
+		return nil, err
+	}
+	return &surroundTrack{TrackLocalStaticRTP: track, stereo: fallback}, nil
+}
+
+// Bind takes the multiopus codec when negotiated with the channel count
+// of the track, and falls back to stereo otherwise
+func (t *surroundTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.TrackLocalStaticRTP.Bind(ctx)
+	if err == nil && codec.Channels == t.Codec().Channels {
+		t.fallback.Store(false)
+		return codec, nil
+	} else if err == nil {
+		t.TrackLocalStaticRTP.Unbind(ctx)
+	}
+
+	t.fallback.Store(true)
+	return t.stereo.Bind(ctx)
+}
+
+func (t *surroundTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	if t.fallback.Load() {
+		return t.stereo.Unbind(ctx)
+	}
+	return t.TrackLocalStaticRTP.Unbind(ctx)
+}
+
+// WriteRTP writes the first stream of each packet once bound as stereo,
+// malformed packets are dropped then
+func (t *surroundTrack) WriteRTP(pk *rtp.Packet) error {
+	if !t.fallback.Load() {
+		return t.TrackLocalStaticRTP.WriteRTP(pk)
+	}
+
+	stream, ok := opus.FirstStream(pk.Payload)
+	if !ok {
+		return nil
+	}
+	return t.stereo.WriteRTP(&rtp.Packet{Header: pk.Header, Payload: stream})
+}
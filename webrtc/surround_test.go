@@ -0,0 +1,66 @@
+package webrtc
+
+import (
+	"testing"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+)
+
+// binding is a negotiated track context keeping what is written to it
+type binding struct {
+	codecs   []webrtc.RTPCodecParameters
+	payloads [][]byte
+}
+
+func (b *binding) CodecParameters() []webrtc.RTPCodecParameters { return b.codecs }
+func (b *binding) HeaderExtensions() []webrtc.RTPHeaderExtensionParameter {
+	return nil
+}
+func (b *binding) SSRC() webrtc.SSRC                    { return 1 }
+func (b *binding) WriteStream() webrtc.TrackLocalWriter { return b }
+func (b *binding) ID() string                           { return "binding" }
+func (b *binding) RTCPReader() interceptor.RTCPReader   { return nil }
+func (b *binding) Write(raw []byte) (int, error)        { return len(raw), nil }
+func (b *binding) WriteRTP(_ *rtp.Header, payload []byte) (int, error) {
+	b.payloads = append(b.payloads, payload)
+	return len(payload), nil
+}
+
+func TestSurroundTrack(t *testing.T) {
+	conf := config.OpusConfig{Stereo: true, InbandFEC: true}
+	// a 5.1 packet, the front pair stream is self-delimited
+	packet := []byte{0x78, 2, 1, 2, 0x78, 3, 4, 0x78, 5, 0x78, 6}
+
+	for _, c := range []struct {
+		channels    int
+		payloadType webrtc.PayloadType
+		payload     []byte
+	}{
+		{6, 118, packet},
+		// a layout the track does not carry falls back like none at all
+		{8, 111, []byte{0x78, 1, 2}},
+		{2, 111, []byte{0x78, 1, 2}},
+	} {
+		track, err := newSurroundTrack(opusCapability(conf, 6), opusCapability(conf, 2), "audio", "stream")
+		require.Nil(t, err)
+
+		ctx := &binding{}
+		for _, channels := range []int{c.channels, 2} {
+			ctx.codecs = append(ctx.codecs, webrtc.RTPCodecParameters{
+				RTPCodecCapability: opusCapability(conf, channels),
+				PayloadType:        opus_payload_types[channels],
+			})
+		}
+		codec, err := track.Bind(ctx)
+		require.Nil(t, err)
+		require.Equal(t, c.payloadType, codec.PayloadType, c.channels)
+
+		require.Nil(t, track.WriteRTP(&rtp.Packet{Header: rtp.Header{}, Payload: packet}))
+		require.Equal(t, [][]byte{c.payload}, ctx.payloads)
+		require.Nil(t, track.Unbind(ctx))
+	}
+}
@@ -1,7 +1,12 @@
 package webrtc
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/rtcp"
@@ -9,21 +14,62 @@ import (
 	"github.com/pion/webrtc/v4"
 	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
 	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/listener/rtppay/opus"
 	"github.com/thinkonmay/thinkremote-rtchub/util/config"
 	"github.com/thinkonmay/thinkremote-rtchub/util/thread"
 )
 
+const (
+	// stats_interval is how often the outbound rtp rate is sampled
+	stats_interval = time.Second * 2
+)
+
+// handles numbers the datachannel handles of every client in the process,
+// a handle id is also the peer replies are routed to
+var handles atomic.Uint64
+
 type OnTrackFunc func(*webrtc.TrackRemote)
 type OnIDRFunc func()
 
 type WebRTCClient struct {
 	conn   *webrtc.PeerConnection
 	Closed bool
-	stop   chan bool
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stream is the msid shared by every track of this connection,
+	// the browser lip-syncs tracks within the same stream
+	stream string
 
 	onTrack OnTrackFunc
 	onIDR   OnIDRFunc
 
+	// extensions tunes the header extensions written on every track
+	extensions config.ExtensionConfig
+	// pacer spreads the packets of every track of the session
+	pacer *pacer
+	// followers get the bandwidth estimate of the viewer, estimated is
+	// the latest one. twcc is set once the estimate comes from
+	// transport-cc feedback, REMB is ignored from then on
+	followers []func(bps float64)
+	estimated float64
+	twcc      atomic.Bool
+	// opus shapes the codec of audio tracks
+	opus config.OpusConfig
+
+	mut      *sync.Mutex
+	groups   []string
+	channels map[string]*webrtc.DataChannel
+	// reported is the unix nano time of the last rtcp receiver report
+	reported atomic.Int64
+	// written counts the rtp bytes sent on every track, retransmissions
+	// included. sent and sampled are its value at the previous sample,
+	// bitrate is the rate measured by it
+	written atomic.Uint64
+	sent    uint64
+	sampled time.Time
+	bitrate atomic.Uint64
+
 	fromSdpChannel, fromIceChannel,
 	toSdpChannel, toIceChannel,
 	connectionState, gatherState chan interface{}
@@ -31,7 +77,7 @@ type WebRTCClient struct {
 
 func InitWebRtcClient(track OnTrackFunc, idr OnIDRFunc, conf config.WebRTCConfig) (client *WebRTCClient, err error) {
 	client = &WebRTCClient{
-		stop:            make(chan bool, 2),
+		stream:          fmt.Sprintf("%d", time.Now().UnixNano()),
 		toSdpChannel:    make(chan interface{}, 2),
 		fromSdpChannel:  make(chan interface{}, 2),
 		toIceChannel:    make(chan interface{}, 2),
@@ -41,11 +87,24 @@ func InitWebRtcClient(track OnTrackFunc, idr OnIDRFunc, conf config.WebRTCConfig
 		onTrack:         track,
 		onIDR:           idr,
 		Closed:          false,
+		mut:             &sync.Mutex{},
+		groups:          []string{},
+		channels:        map[string]*webrtc.DataChannel{},
+		sampled:         time.Now(),
+		extensions:      conf.Extensions,
+		opus:            conf.Opus,
+		pacer:           newPacer(conf.Pacer, time.Now),
 	}
 
-	if client.conn, err = webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: conf.Ices}); err != nil {
+	api, err := newAPI(conf.Network, conf.Opus, client.estimate)
+	if err != nil {
+		return
+	} else if client.conn, err = api.NewPeerConnection(webrtc.Configuration{ICEServers: conf.Ices}); err != nil {
 		return
 	}
+	client.ctx, client.cancel = context.WithCancel(context.Background())
+	client.pacer.run(client.ctx)
+	thread.SafeLoop(client.ctx, stats_interval, client.sample)
 
 	client.conn.OnICECandidate(func(ice *webrtc.ICECandidate) {
 		if ice == nil {
@@ -81,7 +140,7 @@ func InitWebRtcClient(track OnTrackFunc, idr OnIDRFunc, conf config.WebRTCConfig
 		client.onTrack(track)
 	})
 
-	thread.SafeSelect(client.stop, client.fromSdpChannel, func(_sdp interface{}) {
+	thread.SafeSelect(client.ctx, client.fromSdpChannel, func(_sdp interface{}) {
 		sdp := _sdp.(*webrtc.SessionDescription)
 		switch sdp.Type {
 		case webrtc.SDPTypeAnswer: // answer
@@ -98,7 +157,7 @@ func InitWebRtcClient(track OnTrackFunc, idr OnIDRFunc, conf config.WebRTCConfig
 		}
 	})
 
-	thread.SafeSelect(client.stop, client.fromIceChannel, func(_ice interface{}) {
+	thread.SafeSelect(client.ctx, client.fromIceChannel, func(_ice interface{}) {
 		ice := _ice.(*webrtc.ICECandidateInit)
 		sdp := client.conn.RemoteDescription()
 		pending := client.conn.PendingRemoteDescription()
@@ -113,13 +172,24 @@ func InitWebRtcClient(track OnTrackFunc, idr OnIDRFunc, conf config.WebRTCConfig
 }
 
 func (client *WebRTCClient) Listen(listeners []listener.Listener) {
-	for _, lis := range listeners {
+	for i, lis := range listeners {
 		codec := lis.GetCodec()
-		track, err := webrtc.NewTrackLocalStaticRTP(
-			webrtc.RTPCodecCapability{MimeType: codec},
-			fmt.Sprintf("%d", time.Now().UnixNano()),
-			fmt.Sprintf("%d", time.Now().UnixNano()))
+		if encodings, ok := lis.(listener.Simulcast); ok {
+			// the viewer receives one encoding at a time on a single track
+			lis = newSimulcast(encodings, codec, time.Now)
+		}
+		capability := webrtc.RTPCodecCapability{MimeType: codec}
+		if audio, ok := lis.(interface{ Channels() int }); ok {
+			capability = opusCapability(client.opus, audio.Channels())
+		}
 
+		var track trackWriter
+		var err error
+		if id := fmt.Sprintf("%s-%d", client.stream, i); capability.MimeType == opus.MimeTypeMultiOpus {
+			track, err = newSurroundTrack(capability, opusCapability(client.opus, 2), id, client.stream)
+		} else {
+			track, err = webrtc.NewTrackLocalStaticRTP(capability, id, client.stream)
+		}
 		if err != nil {
 			fmt.Printf("error add track %s\n", err.Error())
 			continue
@@ -135,90 +205,307 @@ func (client *WebRTCClient) Listen(listeners []listener.Listener) {
 	}
 }
 
-func (client *WebRTCClient) RegisterDataChannels(chans datachannel.IDatachannel) {
+// RegisterDataChannels opens every group to the peer, messages from the
+// peer carry perms and messages requiring more than perms never reach it
+func (client *WebRTCClient) RegisterDataChannels(chans datachannel.IDatachannel, perms *datachannel.Permissions) {
 	for _, group := range chans.Groups() {
 		fmt.Printf("new datachannel %s\n", group)
-		client.RegisterDataChannel(chans, group)
+		client.RegisterDataChannel(chans, group, perms)
 	}
 }
 
-func (client *WebRTCClient) RegisterDataChannel(dc datachannel.IDatachannel, group string) {
-	channel, err := client.conn.CreateDataChannel(group, nil)
+func (client *WebRTCClient) RegisterDataChannel(dc datachannel.IDatachannel, group string, perms *datachannel.Permissions) {
+	opts := dc.Options(group)
+	ordered := !opts.Unordered
+	init := &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    opts.MaxRetransmits,
+		MaxPacketLifeTime: opts.MaxPacketLifeTime,
+	}
+	if opts.Protocol != "" {
+		init.Protocol = &opts.Protocol
+	}
+	if opts.ID != nil {
+		negotiated := true
+		init.Negotiated = &negotiated
+		init.ID = opts.ID
+	}
+
+	channel, err := client.conn.CreateDataChannel(group, init)
 	if err != nil {
 		fmt.Printf("unable to add data channel: %s\n", err.Error())
 		return
 	}
+	client.mut.Lock()
+	client.groups = append(client.groups, group)
+	client.channels[group] = channel
+	client.mut.Unlock()
 
-	rand := fmt.Sprintf("%d", time.Now().UnixNano())
-	dc.RegisterHandle(group, rand, func(msg string) {
+	handle := strconv.FormatUint(handles.Add(1), 10)
+	if err := dc.RegisterHandle(group, handle, func(msg datachannel.Message) error {
 		if client.Closed {
-			return
+			return webrtc.ErrConnectionClosed
+		} else if !perms.Allows(msg.Requires) {
+			return nil
+		} else if msg.Binary {
+			return channel.Send(msg.Data)
+		} else {
+			return channel.SendText(string(msg.Data))
 		}
-		channel.SendText(msg)
-	})
-	thread.SafeWait(func() bool {
-		return client.Closed
-	}, func() {
-		dc.DeregisterHandle(group, rand)
+	}); err != nil {
+		fmt.Printf("unable to handle data channel %s: %s\n", group, err.Error())
+		return
+	}
+	thread.OnDone(client.ctx, func() {
+		dc.DeregisterHandle(group, handle)
 	})
 	channel.OnOpen(func() {
 		channel.OnMessage(
 			func(msg webrtc.DataChannelMessage) {
 				if !client.Closed {
-					dc.Send(group, string(msg.Data))
+					dc.Send(group, datachannel.Message{
+						Data:   msg.Data,
+						Binary: !msg.IsString,
+						From:   perms,
+						Peer:   handle,
+					})
 				}
 			})
 	})
 }
 
+// estimate follows the transport-cc based estimate of the viewer
+func (client *WebRTCClient) estimate(bps int) {
+	client.twcc.Store(true)
+	client.follow(float64(bps))
+}
+
+// follow hands a bandwidth estimate to the pacer and every track
+func (client *WebRTCClient) follow(bps float64) {
+	client.pacer.setEstimate(bps)
+	client.mut.Lock()
+	client.estimated = bps
+	followers := slices.Clone(client.followers)
+	client.mut.Unlock()
+	for _, fun := range followers {
+		fun(bps)
+	}
+}
+
+// onEstimate calls fun with every bandwidth estimate of the viewer,
+// starting with the current one
+func (client *WebRTCClient) onEstimate(fun func(bps float64)) {
+	client.mut.Lock()
+	client.followers = append(client.followers, fun)
+	bps := client.estimated
+	client.mut.Unlock()
+	if bps > 0 {
+		fun(bps)
+	}
+}
+
 func (client *WebRTCClient) readLoopRTP(listener listener.Listener,
-	track *webrtc.TrackLocalStaticRTP,
+	track trackWriter,
 	sender *webrtc.RTPSender) {
 	id := track.ID()
 
-	listener.RegisterRTPHandler(id, func(pk *rtp.Packet) {
+	counts := &sent{}
+	if timeline, ok := listener.(interface {
+		RTPTime(time.Time) (uint32, bool)
+	}); ok {
+		client.reportLoop(timeline, sender, counts)
+	}
+
+	// extensions are written as packets leave the pacer
+	extensions := newExtensionWriter(client.extensions, listener, sender, track.Kind())
+	write := func(pk *rtp.Packet) {
+		pk = extensions.write(pk, time.Now())
 		if err := track.WriteRTP(pk); err != nil {
 			fmt.Printf("failed to send rtp %s", err.Error())
+		} else {
+			counts.add(pk)
+			client.written.Add(uint64(pk.MarshalSize()))
+		}
+	}
+
+	// temporal layers above what the viewer can take are dropped before
+	// packets are kept for retransmission
+	prio, resend := priorityVideo, newHistory()
+	selector := newLayerSelector(listener, time.Now)
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		prio = priorityAudio
+	} else if forwarder, ok := listener.(*simulcast); ok {
+		client.onEstimate(forwarder.setEstimate)
+	}
+	client.onEstimate(selector.setEstimate)
+	listener.RegisterRTPHandler(id, func(pk *rtp.Packet) {
+		if prio == priorityVideo {
+			var ok bool
+			if pk, ok = selector.forward(pk); !ok {
+				return
+			}
+			pk = extensions.layer(pk, time.Now())
 		}
+		resend.add(pk)
+		client.pacer.push(prio, pk, write)
 	})
 
-	stop := make(chan bool, 2)
-	thread.SafeLoop(stop, 0, func() {
+	thread.SafeLoop(client.ctx, 0, func() {
 		if packets, _, err := sender.ReadRTCP(); err == nil {
 			IDR := false
 			for _, pkt := range packets {
-				switch pkt.(type) {
+				switch pkt := pkt.(type) {
 				case *rtcp.FullIntraRequest:
 					IDR = true
 				case *rtcp.PictureLossIndication:
 					IDR = true
 				case *rtcp.TransportLayerNack:
+					for _, nack := range pkt.Nacks {
+						for _, seq := range nack.PacketList() {
+							if pk := resend.get(seq); pk != nil {
+								client.pacer.push(priorityRetransmission, pk, write)
+							}
+						}
+					}
+				case *rtcp.ReceiverEstimatedMaximumBitrate:
+					if !client.twcc.Load() {
+						client.follow(float64(pkt.Bitrate))
+					}
 				case *rtcp.ReceiverReport:
+					client.reported.Store(time.Now().UnixNano())
 				case *rtcp.SenderReport:
 				case *rtcp.ExtendedReport:
 				}
 			}
 
-			if IDR {
+			if forwarder, ok := listener.(*simulcast); ok && IDR {
+				forwarder.requestKeyframe()
+			} else if IDR {
 				client.onIDR()
 			}
-		} else {
+		} else if client.ctx.Err() == nil {
 			fmt.Printf("failed to receive rtcp %s", err.Error())
 			time.Sleep(time.Second)
 		}
 	})
 
-	thread.SafeWait(func() bool {
-		return client.Closed
-	}, func() {
-		thread.TriggerStop(stop)
+	thread.OnDone(client.ctx, func() {
 		listener.DeregisterRTPHandler(id)
 	})
 }
 
+// LastReport is when the peer last sent a receiver report, zero before
+// the first one
+func (client *WebRTCClient) LastReport() time.Time {
+	if reported := client.reported.Load(); reported != 0 {
+		return time.Unix(0, reported)
+	}
+	return time.Time{}
+}
+
+// Notify sends msg to this peer only, bypassing the consumer of group
+func (client *WebRTCClient) Notify(group string, msg datachannel.Message) error {
+	client.mut.Lock()
+	channel, found := client.channels[group]
+	client.mut.Unlock()
+	if !found {
+		return fmt.Errorf("no datachannel %s", group)
+	} else if client.Closed {
+		return webrtc.ErrConnectionClosed
+	} else if msg.Binary {
+		return channel.Send(msg.Data)
+	}
+	return channel.SendText(string(msg.Data))
+}
+
+// Flush waits until the messages sent on group were acknowledged by the
+// peer, or timeout passed
+func (client *WebRTCClient) Flush(group string, timeout time.Duration) {
+	client.mut.Lock()
+	channel, found := client.channels[group]
+	client.mut.Unlock()
+	if !found {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for channel.BufferedAmount() > 0 && time.Now().Before(deadline) && !client.Closed {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// RestartICE renegotiates ICE credentials on the existing connection,
+// tracks and datachannels stay attached while the new offer is exchanged
+func (client *WebRTCClient) RestartICE() error {
+	offer, err := client.conn.CreateOffer(&webrtc.OfferOptions{
+		ICERestart: true,
+	})
+	if err != nil {
+		return err
+	} else if err = client.conn.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	client.toSdpChannel <- &offer
+	return nil
+}
+
+// Stats is a snapshot of the connection for operators
+type Stats struct {
+	ICEState string   `json:"iceState"`
+	Local    string   `json:"localCandidate,omitempty"`
+	Remote   string   `json:"remoteCandidate,omitempty"`
+	Codecs   []string `json:"codecs"`
+	// Bitrate is the rtp bits per second sent over the last sampling
+	// interval
+	Bitrate      uint64   `json:"bitrate"`
+	Datachannels []string `json:"datachannels"`
+}
+
+func (client *WebRTCClient) Stats() Stats {
+	stats := Stats{
+		ICEState: client.conn.ICEConnectionState().String(),
+		Codecs:   []string{},
+	}
+
+	if sctp := client.conn.SCTP(); sctp != nil {
+		if pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair(); err == nil && pair != nil {
+			stats.Local, stats.Remote = pair.Local.String(), pair.Remote.String()
+		}
+	}
+
+	for _, transceiver := range client.conn.GetTransceivers() {
+		if sender := transceiver.Sender(); sender != nil {
+			for _, codec := range sender.GetParameters().Codecs {
+				if !slices.Contains(stats.Codecs, codec.MimeType) {
+					stats.Codecs = append(stats.Codecs, codec.MimeType)
+				}
+			}
+		}
+	}
+
+	stats.Bitrate = client.bitrate.Load()
+	client.mut.Lock()
+	defer client.mut.Unlock()
+	stats.Datachannels = slices.Clone(client.groups)
+	return stats
+}
+
+// sample measures the outbound rtp rate every stats_interval, however
+// often stats are read
+func (client *WebRTCClient) sample() {
+	sent := client.written.Load()
+	now := time.Now()
+	if elapsed := now.Sub(client.sampled).Seconds(); elapsed > 0 && sent >= client.sent {
+		client.bitrate.Store(uint64(float64(sent-client.sent) * 8 / elapsed))
+	}
+	client.sent, client.sampled = sent, now
+}
+
 func (client *WebRTCClient) Close() {
-	client.conn.Close()
 	client.Closed = true
+	client.cancel()
+	client.conn.Close()
 }
 func (webrtc *WebRTCClient) StopSignaling() {
 	fmt.Println("stopping signaling process")
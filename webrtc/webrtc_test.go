@@ -0,0 +1,66 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkonmay/thinkremote-rtchub/datachannel"
+	"github.com/thinkonmay/thinkremote-rtchub/listener"
+	"github.com/thinkonmay/thinkremote-rtchub/util/config"
+	"github.com/thinkonmay/thinkremote-rtchub/util/thread/leakcheck"
+)
+
+func TestClientTeardown(t *testing.T) {
+	leakcheck.Check(t)
+	client, err := InitWebRtcClient(func(*webrtc.TrackRemote) {}, func() {}, config.WebRTCConfig{})
+	require.Nil(t, err)
+
+	chans := datachannel.NewDatachannel(datachannel.Group{Name: "hid"}, datachannel.Group{Name: "manual"})
+	client.RegisterDataChannels(chans, datachannel.NewPermissions())
+	client.Listen([]listener.Listener{frames{}})
+	client.Close()
+}
+
+// capture hands the rtp handler of the track to the test
+type capture struct {
+	frames
+	handler chan func(*rtp.Packet)
+}
+
+func (c capture) RegisterRTPHandler(_ string, fun func(*rtp.Packet)) { c.handler <- fun }
+
+func TestBitrate(t *testing.T) {
+	client, err := InitWebRtcClient(func(*webrtc.TrackRemote) {}, func() {}, config.WebRTCConfig{})
+	require.Nil(t, err)
+	defer client.Close()
+
+	lis := capture{frames{}, make(chan func(*rtp.Packet), 1)}
+	client.Listen([]listener.Listener{lis})
+	handler := <-lis.handler
+	for i := range 10 {
+		handler(&rtp.Packet{Header: rtp.Header{SequenceNumber: uint16(i)}, Payload: make([]byte, 1000)})
+	}
+
+	require.NotZero(t, client.written.Load())
+	require.Eventually(t, func() bool {
+		return client.Stats().Bitrate > 0
+	}, 2*stats_interval, time.Millisecond*100)
+}
+
+func TestDistinctHandles(t *testing.T) {
+	chans := datachannel.NewDatachannel(datachannel.Group{Name: "hid"})
+	for range 2 {
+		client, err := InitWebRtcClient(func(*webrtc.TrackRemote) {}, func() {}, config.WebRTCConfig{})
+		require.Nil(t, err)
+		defer client.Close()
+		client.RegisterDataChannels(chans, datachannel.NewPermissions())
+	}
+
+	// clients registering within one clock tick still get their own handle
+	stats, err := chans.Stats("hid")
+	require.Nil(t, err)
+	require.Len(t, stats.Handlers, 2)
+}